@@ -0,0 +1,367 @@
+/*
+Copyright © 2024 Yevhen Lebid ealebed@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+	certv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	certlisters "k8s.io/client-go/listers/certificates/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/ealebed/admission-webhook-certificator/pkg/certificator"
+)
+
+const (
+	approverApprovedReason = "AutoApproved"
+	approverDeniedReason   = "PolicyViolation"
+
+	serviceAccountUsernamePrefix = "system:serviceaccount:"
+)
+
+// ApprovalPolicy describes which CertificateSigningRequests the approver accepts
+type ApprovalPolicy struct {
+	// SignerNames are the signers whose CSRs are evaluated, others are ignored
+	SignerNames []string `json:"signerNames"`
+	// AllowedServiceAccounts are the requesters allowed to obtain certificates, as namespace/name
+	AllowedServiceAccounts []string `json:"allowedServiceAccounts"`
+	// KeyAlgorithms are the accepted public key algorithms: RSA, ECDSA, Ed25519
+	KeyAlgorithms []string `json:"keyAlgorithms"`
+	// MinRSAKeySize is the smallest accepted RSA modulus in bits
+	MinRSAKeySize int `json:"minRSAKeySize"`
+	// MinECDSAKeySize is the smallest accepted ECDSA curve size in bits
+	MinECDSAKeySize int `json:"minECDSAKeySize"`
+	// ClusterDomain is accepted as a suffix of service DNS names
+	ClusterDomain string `json:"clusterDomain"`
+}
+
+// ApproverOptions represents options for approver command
+type ApproverOptions struct {
+	controller ControllerOptions
+	policyFile string
+	policy     ApprovalPolicy
+}
+
+// NewApproverCmd returns new approver command
+func NewApproverCmd() *cobra.Command {
+	options := ApproverOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "approver",
+		Short: "Run a controller which approves webhook serving CSRs matching a policy.",
+		Long: "This command watches CertificateSigningRequests of the configured signers and approves\n" +
+			"only those requesting serving certificates for existing Services by allowed ServiceAccounts.\n" +
+			"Every other request for these signers is denied with a descriptive reason.",
+		Example: "approver --allowed-service-account=webhook/webhook-cert-sa [--policy=policy.yaml]",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			policy, err := loadApprovalPolicy(options.policyFile, options.policy)
+			if err != nil {
+				return err
+			}
+			return runApprover(&options.controller, &policy)
+		},
+	}
+
+	addControllerFlags(cmd, &options.controller, "certificator-approver")
+	cmd.Flags().StringVar(&options.policyFile, "policy", "",
+		"Path to a YAML policy file, its fields override the policy flags.")
+	cmd.Flags().StringSliceVar(&options.policy.SignerNames, "signer-name", []string{certificator.KubeAPIServerClientSignerName},
+		"Signer names whose CSRs are evaluated.")
+	cmd.Flags().StringSliceVar(&options.policy.AllowedServiceAccounts, "allowed-service-account", nil,
+		"ServiceAccount allowed to request certificates, as namespace/name.")
+	cmd.Flags().StringSliceVar(&options.policy.KeyAlgorithms, "key-algorithm", []string{"RSA", "ECDSA"},
+		"Accepted public key algorithms: RSA, ECDSA, Ed25519.")
+	cmd.Flags().IntVar(&options.policy.MinRSAKeySize, "min-rsa-key-size", 2048, "Smallest accepted RSA key size in bits.")
+	cmd.Flags().IntVar(&options.policy.MinECDSAKeySize, "min-ecdsa-key-size", 256, "Smallest accepted ECDSA key size in bits.")
+	cmd.Flags().StringVar(&options.policy.ClusterDomain, "cluster-domain", "cluster.local", "Cluster DNS domain.")
+
+	return cmd
+}
+
+// loadApprovalPolicy reads the policy file on top of the policy built from flags
+func loadApprovalPolicy(path string, policy ApprovalPolicy) (ApprovalPolicy, error) {
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return policy, err
+		}
+		if err := yaml.UnmarshalStrict(data, &policy); err != nil {
+			return policy, fmt.Errorf("policy %s: %w", path, err)
+		}
+	}
+
+	if len(policy.SignerNames) == 0 {
+		return policy, fmt.Errorf("policy must list at least one signer name")
+	}
+	if len(policy.AllowedServiceAccounts) == 0 {
+		return policy, fmt.Errorf("policy must list at least one allowed service account")
+	}
+	for _, serviceAccount := range policy.AllowedServiceAccounts {
+		if _, _, err := splitNamespacedName(serviceAccount); err != nil {
+			return policy, fmt.Errorf("allowed service accounts: %w", err)
+		}
+	}
+
+	return policy, nil
+}
+
+func runApprover(options *ControllerOptions, policy *ApprovalPolicy) error {
+	cs, err := initK8sClient(options.kubeconfig)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	factory := informers.NewSharedInformerFactory(cs, options.resync)
+	a := &approver{
+		client:        cs,
+		csrLister:     factory.Certificates().V1().CertificateSigningRequests().Lister(),
+		serviceLister: factory.Core().V1().Services().Lister(),
+		policy:        policy,
+	}
+	c, err := a.controller(factory)
+	if err != nil {
+		return err
+	}
+
+	return runLeaderElected(ctx, cs, options, []*controller{c}, func(ctx context.Context) {
+		factory.Start(ctx.Done())
+		if err := c.run(ctx, options.workers); err != nil {
+			log.Printf("Controller %s - error occurred, detail: %v", c.name, err)
+		}
+	})
+}
+
+// approver approves or denies CSRs according to the policy
+type approver struct {
+	client        kubernetes.Interface
+	csrLister     certlisters.CertificateSigningRequestLister
+	serviceLister corelisters.ServiceLister
+	policy        *ApprovalPolicy
+}
+
+func (a *approver) controller(factory informers.SharedInformerFactory) (*controller, error) {
+	c := newController("approver", a.sync)
+	err := c.watch(factory.Certificates().V1().CertificateSigningRequests().Informer(), func(obj interface{}) []string {
+		if csr, ok := obj.(*certv1.CertificateSigningRequest); ok && a.pending(csr) {
+			return []string{csr.Name}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.waitFor(factory.Core().V1().Services().Informer())
+
+	return c, nil
+}
+
+// pending reports whether the CSR belongs to a policy signer and awaits a decision
+func (a *approver) pending(csr *certv1.CertificateSigningRequest) bool {
+	if !slices.Contains(a.policy.SignerNames, csr.Spec.SignerName) {
+		return false
+	}
+	for _, condition := range csr.Status.Conditions {
+		if condition.Type == certv1.CertificateApproved || condition.Type == certv1.CertificateDenied {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (a *approver) sync(ctx context.Context, name string) error {
+	csr, err := a.csrLister.Get(name)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !a.pending(csr) {
+		return nil
+	}
+
+	violation, err := a.evaluate(csr)
+	if err != nil {
+		return err
+	}
+
+	condition := certv1.CertificateSigningRequestCondition{
+		Type:           certv1.CertificateApproved,
+		Status:         corev1.ConditionTrue,
+		Reason:         approverApprovedReason,
+		Message:        "This CSR was approved by certificator approver policy",
+		LastUpdateTime: metav1.Now(),
+	}
+	if violation != "" {
+		condition.Type = certv1.CertificateDenied
+		condition.Reason = approverDeniedReason
+		condition.Message = violation
+	}
+
+	csr = csr.DeepCopy()
+	csr.Status.Conditions = append(csr.Status.Conditions, condition)
+	if _, err := a.client.CertificatesV1().CertificateSigningRequests().UpdateApproval(ctx, csr.Name, csr,
+		metav1.UpdateOptions{}); err != nil {
+		return err
+	}
+	log.Printf("Certificate signing request %s, status: %s, %s", csr.Name, condition.Type, condition.Message)
+
+	return nil
+}
+
+// evaluate returns a description of the first policy violation of the CSR, or an
+// empty string when the CSR may be approved
+func (a *approver) evaluate(csr *certv1.CertificateSigningRequest) (string, error) {
+	if violation := a.evaluateRequester(csr.Spec.Username); violation != "" {
+		return violation, nil
+	}
+	if violation := evaluateUsages(csr.Spec.Usages); violation != "" {
+		return violation, nil
+	}
+
+	block, _ := pem.Decode(csr.Spec.Request)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return "request is not a PEM encoded CERTIFICATE REQUEST", nil
+	}
+	request, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return fmt.Sprintf("request cannot be parsed: %v", err), nil
+	}
+	if err := request.CheckSignature(); err != nil {
+		return fmt.Sprintf("request signature is invalid: %v", err), nil
+	}
+	if violation := a.evaluateKey(request); violation != "" {
+		return violation, nil
+	}
+
+	return a.evaluateNames(request)
+}
+
+func (a *approver) evaluateRequester(username string) string {
+	serviceAccount := strings.Replace(strings.TrimPrefix(username, serviceAccountUsernamePrefix), ":", "/", 1)
+	if !strings.HasPrefix(username, serviceAccountUsernamePrefix) || !slices.Contains(a.policy.AllowedServiceAccounts, serviceAccount) {
+		return fmt.Sprintf("requester %q is not an allowed service account", username)
+	}
+
+	return ""
+}
+
+func evaluateUsages(usages []certv1.KeyUsage) string {
+	allowed := []certv1.KeyUsage{certv1.UsageDigitalSignature, certv1.UsageKeyEncipherment, certv1.UsageServerAuth}
+	for _, usage := range usages {
+		if !slices.Contains(allowed, usage) {
+			return fmt.Sprintf("usage %q is not allowed, only server auth certificates are approved", usage)
+		}
+	}
+	if !slices.Contains(usages, certv1.UsageServerAuth) {
+		return "usages must include server auth"
+	}
+
+	return ""
+}
+
+func (a *approver) evaluateKey(request *x509.CertificateRequest) string {
+	var algorithm string
+	var size, minSize int
+
+	switch key := request.PublicKey.(type) {
+	case *rsa.PublicKey:
+		algorithm, size, minSize = "RSA", key.N.BitLen(), a.policy.MinRSAKeySize
+	case *ecdsa.PublicKey:
+		algorithm, size, minSize = "ECDSA", key.Curve.Params().BitSize, a.policy.MinECDSAKeySize
+	case ed25519.PublicKey:
+		algorithm = "Ed25519"
+	default:
+		return fmt.Sprintf("public key type %T is not supported", key)
+	}
+
+	if !slices.Contains(a.policy.KeyAlgorithms, algorithm) {
+		return fmt.Sprintf("key algorithm %s is not allowed", algorithm)
+	}
+	if size < minSize {
+		return fmt.Sprintf("%s key size %d is below the minimum of %d bits", algorithm, size, minSize)
+	}
+
+	return ""
+}
+
+// evaluateNames accepts only DNS names of a single existing Service:
+// svc, svc.ns, svc.ns.svc and svc.ns.svc.<cluster domain>
+func (a *approver) evaluateNames(request *x509.CertificateRequest) (string, error) {
+	if len(request.IPAddresses) > 0 || len(request.EmailAddresses) > 0 || len(request.URIs) > 0 {
+		return "only DNS subject alternative names are allowed", nil
+	}
+
+	var service, namespace string
+	for _, name := range request.DNSNames {
+		labels := strings.Split(name, ".")
+		if len(labels) == 3 && labels[2] == "svc" {
+			if service != "" && (service != labels[0] || namespace != labels[1]) {
+				return "subject alternative names must belong to a single service", nil
+			}
+			service, namespace = labels[0], labels[1]
+		}
+	}
+	if service == "" {
+		return "subject alternative names must include <service>.<namespace>.svc", nil
+	}
+
+	allowed := []string{
+		service,
+		service + "." + namespace,
+		service + "." + namespace + ".svc",
+		service + "." + namespace + ".svc." + a.policy.ClusterDomain,
+	}
+	for _, name := range request.DNSNames {
+		if !slices.Contains(allowed, name) {
+			return fmt.Sprintf("subject alternative name %q is not a name of service %s/%s", name, namespace, service), nil
+		}
+	}
+	if cn := request.Subject.CommonName; cn != "" && !slices.Contains(allowed, cn) {
+		return fmt.Sprintf("common name %q is not a name of service %s/%s", cn, namespace, service), nil
+	}
+
+	if _, err := a.serviceLister.Services(namespace).Get(service); err != nil {
+		if apierrors.IsNotFound(err) {
+			return fmt.Sprintf("service %s/%s does not exist", namespace, service), nil
+		}
+		return "", err
+	}
+
+	return "", nil
+}
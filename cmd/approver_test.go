@@ -0,0 +1,275 @@
+package cmd
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	certv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	certlisters "k8s.io/client-go/listers/certificates/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/ealebed/admission-webhook-certificator/pkg/certificator"
+)
+
+// newTestCSRPEM returns a PEM encoded certificate request signed by key
+func newTestCSRPEM(t *testing.T, key crypto.Signer, template *x509.CertificateRequest) []byte {
+	t.Helper()
+
+	der, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificateRequest: %v", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+}
+
+func newTestApprover(t *testing.T, objects ...*certv1.CertificateSigningRequest) (*approver, *fake.Clientset) {
+	t.Helper()
+
+	services := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	_ = services.Add(&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "webhook-svc", Namespace: "webhook"}})
+
+	csrs := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	cs := fake.NewClientset()
+	for _, csr := range objects {
+		_ = csrs.Add(csr)
+		_, _ = cs.CertificatesV1().CertificateSigningRequests().Create(context.TODO(), csr, metav1.CreateOptions{})
+	}
+
+	return &approver{
+		client:        cs,
+		csrLister:     certlisters.NewCertificateSigningRequestLister(csrs),
+		serviceLister: corelisters.NewServiceLister(services),
+		policy: &ApprovalPolicy{
+			SignerNames:            []string{certificator.KubeAPIServerClientSignerName},
+			AllowedServiceAccounts: []string{"webhook/webhook-cert-sa"},
+			KeyAlgorithms:          []string{"RSA", "ECDSA"},
+			MinRSAKeySize:          2048,
+			MinECDSAKeySize:        256,
+			ClusterDomain:          "cluster.local",
+		},
+	}, cs
+}
+
+func TestApproverEvaluate(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey: %v", err)
+	}
+	weakKey, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey: %v", err)
+	}
+	serviceNames := []string{"webhook-svc", "webhook-svc.webhook", "webhook-svc.webhook.svc"}
+
+	tests := []struct {
+		name          string
+		username      string
+		usages        []certv1.KeyUsage
+		key           crypto.Signer
+		template      x509.CertificateRequest
+		wantViolation string
+	}{
+		{
+			name:     "request generated by certify",
+			template: x509.CertificateRequest{Subject: pkix.Name{CommonName: "webhook-svc.webhook"}, DNSNames: serviceNames},
+		},
+		{
+			name:     "cluster domain name",
+			template: x509.CertificateRequest{DNSNames: []string{"webhook-svc.webhook.svc", "webhook-svc.webhook.svc.cluster.local"}},
+		},
+		{
+			name:          "requester not allowed",
+			username:      "system:serviceaccount:default:default",
+			template:      x509.CertificateRequest{DNSNames: serviceNames},
+			wantViolation: "is not an allowed service account",
+		},
+		{
+			name:          "client auth usage",
+			usages:        []certv1.KeyUsage{certv1.UsageDigitalSignature, certv1.UsageClientAuth},
+			template:      x509.CertificateRequest{DNSNames: serviceNames},
+			wantViolation: "usage \"client auth\" is not allowed",
+		},
+		{
+			name:          "weak key",
+			key:           weakKey,
+			template:      x509.CertificateRequest{DNSNames: serviceNames},
+			wantViolation: "ECDSA key size 224 is below the minimum of 256 bits",
+		},
+		{
+			name:          "foreign DNS name",
+			template:      x509.CertificateRequest{DNSNames: append([]string{"example.com"}, serviceNames...)},
+			wantViolation: "subject alternative name \"example.com\"",
+		},
+		{
+			name:          "two services",
+			template:      x509.CertificateRequest{DNSNames: []string{"webhook-svc.webhook.svc", "other.webhook.svc"}},
+			wantViolation: "must belong to a single service",
+		},
+		{
+			name: "IP address",
+			template: x509.CertificateRequest{
+				DNSNames: serviceNames, IPAddresses: []net.IP{net.ParseIP("10.0.0.1")},
+			},
+			wantViolation: "only DNS subject alternative names are allowed",
+		},
+		{
+			name:          "service does not exist",
+			template:      x509.CertificateRequest{DNSNames: []string{"missing.webhook.svc"}},
+			wantViolation: "service webhook/missing does not exist",
+		},
+		{
+			name:          "foreign common name",
+			template:      x509.CertificateRequest{Subject: pkix.Name{CommonName: "admin"}, DNSNames: serviceNames},
+			wantViolation: "common name \"admin\"",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, _ := newTestApprover(t)
+			key := tt.key
+			if key == nil {
+				key = rsaKey
+			}
+			username := tt.username
+			if username == "" {
+				username = "system:serviceaccount:webhook:webhook-cert-sa"
+			}
+			usages := tt.usages
+			if usages == nil {
+				usages = []certv1.KeyUsage{certv1.UsageDigitalSignature, certv1.UsageKeyEncipherment, certv1.UsageServerAuth}
+			}
+			csr := &certv1.CertificateSigningRequest{Spec: certv1.CertificateSigningRequestSpec{
+				Request:  newTestCSRPEM(t, key, &tt.template),
+				Usages:   usages,
+				Username: username,
+			}}
+
+			violation, err := a.evaluate(csr)
+			if err != nil {
+				t.Fatalf("evaluate() error = %v", err)
+			}
+			if tt.wantViolation == "" && violation != "" {
+				t.Errorf("Expected CSR to be approved, got violation '%s'", violation)
+			}
+			if tt.wantViolation != "" && !strings.Contains(violation, tt.wantViolation) {
+				t.Errorf("Expected violation to contain '%s', got '%s'", tt.wantViolation, violation)
+			}
+		})
+	}
+}
+
+func TestApproverSync(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey: %v", err)
+	}
+	newCSR := func(name, signer, username string) *certv1.CertificateSigningRequest {
+		return &certv1.CertificateSigningRequest{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: certv1.CertificateSigningRequestSpec{
+				Request:    newTestCSRPEM(t, key, &x509.CertificateRequest{DNSNames: []string{"webhook-svc.webhook.svc"}}),
+				Usages:     []certv1.KeyUsage{certv1.UsageServerAuth},
+				Username:   username,
+				SignerName: signer,
+			},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		csr      *certv1.CertificateSigningRequest
+		wantType certv1.RequestConditionType
+	}{
+		{
+			name:     "approves matching CSR",
+			csr:      newCSR("allowed", certificator.KubeAPIServerClientSignerName, "system:serviceaccount:webhook:webhook-cert-sa"),
+			wantType: certv1.CertificateApproved,
+		},
+		{
+			name:     "denies violating CSR",
+			csr:      newCSR("denied", certificator.KubeAPIServerClientSignerName, "system:serviceaccount:default:default"),
+			wantType: certv1.CertificateDenied,
+		},
+		{
+			name: "ignores other signers",
+			csr:  newCSR("ignored", "example.com/signer", "system:serviceaccount:default:default"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, cs := newTestApprover(t, tt.csr)
+
+			if err := a.sync(context.TODO(), tt.csr.Name); err != nil {
+				t.Fatalf("sync() error = %v", err)
+			}
+
+			csr, _ := cs.CertificatesV1().CertificateSigningRequests().Get(context.TODO(), tt.csr.Name, metav1.GetOptions{})
+			if tt.wantType == "" {
+				if len(csr.Status.Conditions) != 0 {
+					t.Errorf("Expected no conditions, got %v", csr.Status.Conditions)
+				}
+				return
+			}
+			if len(csr.Status.Conditions) != 1 || csr.Status.Conditions[0].Type != tt.wantType {
+				t.Errorf("Expected %s condition, got %v", tt.wantType, csr.Status.Conditions)
+			}
+		})
+	}
+}
+
+func TestLoadApprovalPolicy(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "valid.yaml")
+	_ = os.WriteFile(valid, []byte("allowedServiceAccounts: [webhook/webhook-cert-sa]\nminRSAKeySize: 3072\n"), 0o600)
+	unknown := filepath.Join(dir, "unknown.yaml")
+	_ = os.WriteFile(unknown, []byte("allowedServiceAccount: [webhook/webhook-cert-sa]\n"), 0o600)
+
+	base := ApprovalPolicy{SignerNames: []string{certificator.KubeAPIServerClientSignerName}, MinRSAKeySize: 2048}
+
+	tests := []struct {
+		name    string
+		path    string
+		base    ApprovalPolicy
+		wantErr bool
+	}{
+		{name: "file overrides flags", path: valid, base: base},
+		{name: "unknown field", path: unknown, base: base, wantErr: true},
+		{name: "no allowed service accounts", base: base, wantErr: true},
+		{
+			name:    "invalid service account",
+			base:    ApprovalPolicy{SignerNames: base.SignerNames, AllowedServiceAccounts: []string{"webhook-cert-sa"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := loadApprovalPolicy(tt.path, tt.base)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadApprovalPolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (policy.MinRSAKeySize != 3072 || len(policy.SignerNames) != 1) {
+				t.Errorf("Expected file values merged over flags, got %+v", policy)
+			}
+		})
+	}
+}
@@ -0,0 +1,304 @@
+/*
+Copyright © 2024 Yevhen Lebid ealebed@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"slices"
+
+	"github.com/spf13/cobra"
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/ealebed/admission-webhook-certificator/pkg/certificator"
+)
+
+// kubeRootCAConfigMap is published into every namespace by kube-controller-manager
+// and holds the cluster CA which signs kubernetes.io/* certificates
+const kubeRootCAConfigMap = "kube-root-ca.crt"
+
+// caCertKey is the Secret and ConfigMap key holding a CA bundle, like kube-root-ca.crt does
+const caCertKey = "ca.crt"
+
+var (
+	crdResource        = schema.GroupVersionResource{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"}
+	apiServiceResource = schema.GroupVersionResource{Group: "apiregistration.k8s.io", Version: "v1", Resource: "apiservices"}
+)
+
+// CABundleOptions represents the objects whose caBundle is patched with the CA
+type CABundleOptions struct {
+	mutatingWebhooks   []string
+	validatingWebhooks []string
+	crds               []string
+	crdSelector        string
+	apiServices        []string
+}
+
+// addCABundleFlags registers flags selecting the objects whose caBundle is patched
+func addCABundleFlags(cmd *cobra.Command, options *CABundleOptions) {
+	cmd.Flags().StringSliceVar(&options.mutatingWebhooks, "mutating-webhook-configuration", nil,
+		"MutatingWebhookConfiguration whose webhooks get the CA as caBundle, may be repeated.")
+	cmd.Flags().StringSliceVar(&options.validatingWebhooks, "validating-webhook-configuration", nil,
+		"ValidatingWebhookConfiguration whose webhooks get the CA as caBundle, may be repeated.")
+	cmd.Flags().StringSliceVar(&options.crds, "crd", nil,
+		"CustomResourceDefinition whose conversion webhook gets the CA as caBundle, may be repeated.")
+	cmd.Flags().StringVar(&options.crdSelector, "crd-selector", "",
+		"Label selector of CustomResourceDefinitions whose conversion webhook gets the CA as caBundle.")
+	cmd.Flags().StringSliceVar(&options.apiServices, "apiservice", nil,
+		"APIService of an aggregated API which gets the CA as caBundle, may be repeated.")
+}
+
+// enabled reports whether any object is selected for patching
+func (o *CABundleOptions) enabled() bool {
+	return len(o.mutatingWebhooks) > 0 || len(o.validatingWebhooks) > 0 || o.dynamic()
+}
+
+// dynamic reports whether objects without a typed client, CRDs and APIServices, are selected
+func (o *CABundleOptions) dynamic() bool {
+	return len(o.crds) > 0 || o.crdSelector != "" || len(o.apiServices) > 0
+}
+
+// args returns command line arguments reproducing the options
+func (o *CABundleOptions) args() []string {
+	var args []string
+	for _, name := range o.mutatingWebhooks {
+		args = append(args, "--mutating-webhook-configuration="+name)
+	}
+	for _, name := range o.validatingWebhooks {
+		args = append(args, "--validating-webhook-configuration="+name)
+	}
+	for _, name := range o.crds {
+		args = append(args, "--crd="+name)
+	}
+	if o.crdSelector != "" {
+		args = append(args, "--crd-selector="+o.crdSelector)
+	}
+	for _, name := range o.apiServices {
+		args = append(args, "--apiservice="+name)
+	}
+
+	return args
+}
+
+// patchCABundles sets caBundle of every webhook of the selected configurations, of conversion
+// webhooks of the selected CRDs and of the selected APIServices
+func patchCABundles(ctx context.Context, cs kubernetes.Interface, dyn dynamic.Interface, recorder record.EventRecorder,
+	options *CABundleOptions, caBundle []byte) error {
+	for _, name := range options.mutatingWebhooks {
+		if err := patchMutatingWebhookCABundle(ctx, cs, recorder, name, caBundle); err != nil {
+			return err
+		}
+	}
+	for _, name := range options.validatingWebhooks {
+		if err := patchValidatingWebhookCABundle(ctx, cs, recorder, name, caBundle); err != nil {
+			return err
+		}
+	}
+
+	if !options.dynamic() {
+		return nil
+	}
+	crds, err := selectCRDs(ctx, dyn, options)
+	if err != nil {
+		return err
+	}
+	for _, name := range crds {
+		if err = patchCRDCABundle(ctx, dyn, recorder, name, caBundle); err != nil {
+			return err
+		}
+	}
+	for _, name := range options.apiServices {
+		if err = patchAPIServiceCABundle(ctx, dyn, recorder, name, caBundle); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// patchMutatingWebhookCABundle sets caBundle of every webhook of a MutatingWebhookConfiguration
+func patchMutatingWebhookCABundle(ctx context.Context, cs kubernetes.Interface, recorder record.EventRecorder, name string, caBundle []byte) error {
+	client := cs.AdmissionregistrationV1().MutatingWebhookConfigurations()
+	return patchCABundle(ctx, recorder, "MutatingWebhookConfiguration", name, client.Get, client.Update,
+		func(config *admissionv1.MutatingWebhookConfiguration) bool {
+			changed := false
+			for i := range config.Webhooks {
+				changed = setCABundle(&config.Webhooks[i].ClientConfig.CABundle, caBundle) || changed
+			}
+			return changed
+		})
+}
+
+// patchValidatingWebhookCABundle sets caBundle of every webhook of a ValidatingWebhookConfiguration
+func patchValidatingWebhookCABundle(ctx context.Context, cs kubernetes.Interface, recorder record.EventRecorder, name string, caBundle []byte) error {
+	client := cs.AdmissionregistrationV1().ValidatingWebhookConfigurations()
+	return patchCABundle(ctx, recorder, "ValidatingWebhookConfiguration", name, client.Get, client.Update,
+		func(config *admissionv1.ValidatingWebhookConfiguration) bool {
+			changed := false
+			for i := range config.Webhooks {
+				changed = setCABundle(&config.Webhooks[i].ClientConfig.CABundle, caBundle) || changed
+			}
+			return changed
+		})
+}
+
+// patchCRDCABundle sets caBundle of the conversion webhook of a CRD, CRDs without one are skipped
+func patchCRDCABundle(ctx context.Context, dyn dynamic.Interface, recorder record.EventRecorder, name string, caBundle []byte) error {
+	return patchCABundle(ctx, recorder, "CustomResourceDefinition", name, dynamicGet(dyn, crdResource), dynamicUpdate(dyn, crdResource),
+		func(crd *unstructured.Unstructured) bool {
+			strategy, _, _ := unstructured.NestedString(crd.Object, "spec", "conversion", "strategy")
+			if strategy != "Webhook" {
+				log.Printf("CustomResourceDefinition %s, status: No conversion webhook, skipping", name)
+				return false
+			}
+			return setUnstructuredCABundle(crd, caBundle, "spec", "conversion", "webhook", "clientConfig", "caBundle")
+		})
+}
+
+// patchAPIServiceCABundle sets caBundle of an APIService
+func patchAPIServiceCABundle(ctx context.Context, dyn dynamic.Interface, recorder record.EventRecorder, name string, caBundle []byte) error {
+	return patchCABundle(ctx, recorder, "APIService", name, dynamicGet(dyn, apiServiceResource), dynamicUpdate(dyn, apiServiceResource),
+		func(apiService *unstructured.Unstructured) bool {
+			return setUnstructuredCABundle(apiService, caBundle, "spec", "caBundle")
+		})
+}
+
+// selectCRDs returns the CRDs given by name together with the ones matching the selector
+func selectCRDs(ctx context.Context, dyn dynamic.Interface, options *CABundleOptions) ([]string, error) {
+	names := slices.Clone(options.crds)
+	if options.crdSelector == "" {
+		return names, nil
+	}
+
+	list, err := dyn.Resource(crdResource).List(ctx, metav1.ListOptions{LabelSelector: options.crdSelector})
+	if err != nil {
+		return nil, fmt.Errorf("list CustomResourceDefinitions: %w", err)
+	}
+	for i := range list.Items {
+		if !slices.Contains(names, list.Items[i].GetName()) {
+			names = append(names, list.Items[i].GetName())
+		}
+	}
+
+	return names, nil
+}
+
+func dynamicGet(dyn dynamic.Interface, resource schema.GroupVersionResource) func(context.Context, string,
+	metav1.GetOptions) (*unstructured.Unstructured, error) {
+	return func(ctx context.Context, name string, opts metav1.GetOptions) (*unstructured.Unstructured, error) {
+		return dyn.Resource(resource).Get(ctx, name, opts)
+	}
+}
+
+func dynamicUpdate(dyn dynamic.Interface, resource schema.GroupVersionResource) func(context.Context, *unstructured.Unstructured,
+	metav1.UpdateOptions) (*unstructured.Unstructured, error) {
+	return func(ctx context.Context, obj *unstructured.Unstructured, opts metav1.UpdateOptions) (*unstructured.Unstructured, error) {
+		return dyn.Resource(resource).Update(ctx, obj, opts)
+	}
+}
+
+// setUnstructuredCABundle sets the base64 encoded caBundle at fields and reports whether it changed
+func setUnstructuredCABundle(obj *unstructured.Unstructured, caBundle []byte, fields ...string) bool {
+	encoded := base64.StdEncoding.EncodeToString(caBundle)
+	if current, _, _ := unstructured.NestedString(obj.Object, fields...); current == encoded {
+		return false
+	}
+
+	return unstructured.SetNestedField(obj.Object, encoded, fields...) == nil
+}
+
+// patchCABundle updates an object when set changed its caBundle, objects already carrying
+// the bundle are not updated and update conflicts are retried with a fresh copy
+func patchCABundle[T runtime.Object](ctx context.Context, recorder record.EventRecorder, kind, name string,
+	get func(ctx context.Context, name string, opts metav1.GetOptions) (T, error),
+	update func(ctx context.Context, obj T, opts metav1.UpdateOptions) (T, error),
+	set func(obj T) bool) error {
+	ctx, span := certificator.StartSpan(ctx, "patchCABundle", attrKind.String(kind), attrName.String(name))
+	defer span.End()
+
+	changed := false
+	var updated T
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		obj, err := get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if changed = set(obj); !changed {
+			return nil
+		}
+		updated, err = update(ctx, obj, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return certificator.SpanError(span, fmt.Errorf("patch caBundle of %s %s: %w", kind, name, err))
+	}
+	if changed {
+		log.Printf("%s %s, status: caBundle updated", kind, name)
+		recorder.Event(updated, corev1.EventTypeNormal, eventReasonCABundleInjected, "caBundle updated with the certificator CA")
+	} else {
+		log.Printf("%s %s, status: caBundle up to date", kind, name)
+	}
+
+	return nil
+}
+
+// setCABundle replaces field with caBundle and reports whether it changed
+func setCABundle(field *[]byte, caBundle []byte) bool {
+	if bytes.Equal(*field, caBundle) {
+		return false
+	}
+	*field = caBundle
+
+	return true
+}
+
+// clusterCABundle returns the cluster CA published into namespace, which signs
+// certificates of the kubernetes.io/* signers
+func clusterCABundle(ctx context.Context, cs kubernetes.Interface, namespace string) ([]byte, error) {
+	configMap, err := cs.CoreV1().ConfigMaps(namespace).Get(ctx, kubeRootCAConfigMap, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("get cluster CA: %w", err)
+	}
+	caPEM := configMap.Data[caCertKey]
+	if caPEM == "" {
+		return nil, fmt.Errorf("get cluster CA: ConfigMap %s/%s has no ca.crt", namespace, kubeRootCAConfigMap)
+	}
+
+	return []byte(caPEM), nil
+}
+
+// caBundleFromSecret returns the trust bundle of a CA Secret, which holds the previous
+// and next CAs as ca.crt during rotation, or its CA certificate otherwise
+func caBundleFromSecret(secret *corev1.Secret) []byte {
+	if caPEM := secret.Data[caCertKey]; len(caPEM) > 0 {
+		return caPEM
+	}
+
+	return secret.Data[corev1.TLSCertKey]
+}
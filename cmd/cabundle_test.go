@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestPatchCABundles(t *testing.T) {
+	newMutating := func(caBundle string) *admissionv1.MutatingWebhookConfiguration {
+		return &admissionv1.MutatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: "webhook-cfg"},
+			Webhooks: []admissionv1.MutatingWebhook{
+				{Name: "a.webhook.io", ClientConfig: admissionv1.WebhookClientConfig{CABundle: []byte(caBundle)}},
+				{Name: "b.webhook.io"},
+			},
+		}
+	}
+
+	tests := []struct {
+		name        string
+		existing    *admissionv1.MutatingWebhookConfiguration
+		wantUpdates int
+		wantErr     bool
+	}{
+		{name: "sets caBundle of all webhooks", existing: newMutating("old"), wantUpdates: 1},
+		{name: "missing configuration", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := fake.NewClientset()
+			if tt.existing != nil {
+				cs = fake.NewClientset(tt.existing)
+			}
+			options := &CABundleOptions{mutatingWebhooks: []string{"webhook-cfg"}}
+			recorder := record.NewFakeRecorder(10)
+
+			// the second run must find everything up to date
+			for range 2 {
+				err := patchCABundles(context.TODO(), cs, nil, recorder, options, []byte("new"))
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("patchCABundles() error = %v, wantErr %v", err, tt.wantErr)
+				}
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(recorder.Events) != tt.wantUpdates {
+				t.Errorf("Expected %d %s events, got %d", tt.wantUpdates, eventReasonCABundleInjected, len(recorder.Events))
+			}
+
+			updates := 0
+			for _, action := range cs.Actions() {
+				if action.GetVerb() == "update" {
+					updates++
+				}
+			}
+			if updates != tt.wantUpdates {
+				t.Errorf("Expected %d updates, got %d", tt.wantUpdates, updates)
+			}
+			config, _ := cs.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(context.TODO(), "webhook-cfg", metav1.GetOptions{})
+			for _, webhook := range config.Webhooks {
+				if string(webhook.ClientConfig.CABundle) != "new" {
+					t.Errorf("Expected caBundle 'new' for %s, got '%s'", webhook.Name, webhook.ClientConfig.CABundle)
+				}
+			}
+		})
+	}
+}
+
+func TestPatchCABundlesDynamic(t *testing.T) {
+	newCRD := func(name, strategy string, labels map[string]interface{}) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "apiextensions.k8s.io/v1",
+			"kind":       "CustomResourceDefinition",
+			"metadata":   map[string]interface{}{"name": name, "labels": labels},
+			"spec":       map[string]interface{}{"conversion": map[string]interface{}{"strategy": strategy}},
+		}}
+	}
+	apiService := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apiregistration.k8s.io/v1",
+		"kind":       "APIService",
+		"metadata":   map[string]interface{}{"name": "v1beta1.metrics.example.io"},
+		"spec":       map[string]interface{}{"caBundle": "b2xk"},
+	}}
+	selected := map[string]interface{}{"certificator.ealebed.io/inject": "true"}
+	caBundle := base64.StdEncoding.EncodeToString([]byte("new"))
+
+	tests := []struct {
+		name     string
+		options  *CABundleOptions
+		resource schema.GroupVersionResource
+		object   string
+		fields   []string
+		want     string
+	}{
+		{
+			name:     "CRD by name",
+			options:  &CABundleOptions{crds: []string{"widgets.example.io"}},
+			resource: crdResource,
+			object:   "widgets.example.io",
+			fields:   []string{"spec", "conversion", "webhook", "clientConfig", "caBundle"},
+			want:     caBundle,
+		},
+		{
+			name:     "CRD by selector",
+			options:  &CABundleOptions{crdSelector: "certificator.ealebed.io/inject=true"},
+			resource: crdResource,
+			object:   "gadgets.example.io",
+			fields:   []string{"spec", "conversion", "webhook", "clientConfig", "caBundle"},
+			want:     caBundle,
+		},
+		{
+			name:     "CRD without conversion webhook",
+			options:  &CABundleOptions{crds: []string{"plain.example.io"}},
+			resource: crdResource,
+			object:   "plain.example.io",
+			fields:   []string{"spec", "conversion", "webhook", "clientConfig", "caBundle"},
+		},
+		{
+			name:     "APIService",
+			options:  &CABundleOptions{apiServices: []string{"v1beta1.metrics.example.io"}},
+			resource: apiServiceResource,
+			object:   "v1beta1.metrics.example.io",
+			fields:   []string{"spec", "caBundle"},
+			want:     caBundle,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dyn := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+				map[schema.GroupVersionResource]string{crdResource: "CustomResourceDefinitionList", apiServiceResource: "APIServiceList"},
+				newCRD("widgets.example.io", "Webhook", nil),
+				newCRD("gadgets.example.io", "Webhook", selected),
+				newCRD("plain.example.io", "None", nil),
+				apiService,
+			)
+
+			if err := patchCABundles(context.TODO(), fake.NewClientset(), dyn, record.NewFakeRecorder(10), tt.options, []byte("new")); err != nil {
+				t.Fatalf("patchCABundles() error = %v", err)
+			}
+
+			obj, err := dyn.Resource(tt.resource).Get(context.TODO(), tt.object, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			if got, _, _ := unstructured.NestedString(obj.Object, tt.fields...); got != tt.want {
+				t.Errorf("Expected caBundle '%s', got '%s'", tt.want, got)
+			}
+		})
+	}
+}
@@ -17,199 +17,207 @@ limitations under the License.
 package cmd
 
 import (
-	"bytes"
 	"context"
-	"crypto/rand"
-	"crypto/rsa"
-	"crypto/x509"
-	"crypto/x509/pkix"
-	"encoding/pem"
-	"fmt"
 	"log"
-	"strings"
 	"time"
 
-	certv1 "k8s.io/api/certificates/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	certsv1 "k8s.io/client-go/kubernetes/typed/certificates/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/ealebed/admission-webhook-certificator/pkg/certificator"
 )
 
+// issuance failure reasons of the phases following the Secret, the ones of the certificate
+// itself are the certificator.Reason* constants
 const (
-	csrNameTemplate0 = "${service}"
-	csrNameTemplate1 = "${service}.${namespace}"
-	csrNameTemplate2 = "${service}.${namespace}.svc"
+	failureReasonCABundle = "ca_bundle"
+	failureReasonRestart  = "restart"
+	failureReasonVerify   = "verify"
 )
 
-func createAndSignCert(service, namespace, secret, kubeconfig string) error {
+func createAndSignCert(options *CreateAndSignCertOptions) error {
 	start := time.Now()
 
 	ctx := context.TODO()
-	cs, _ := initK8sClient(kubeconfig)
-
-	clientCSRPEM, clientPrivateKeyPEM, csrNameWithServiceAndNamespace, err :=
-		generateCertificateRequest(service, namespace)
+	cs, err := initK8sClient(options.kubeconfig)
 	if err != nil {
 		return err
 	}
 
-	csrClient := cs.CertificatesV1().CertificateSigningRequests()
-	csr := createCSRObject(csrNameWithServiceAndNamespace, clientCSRPEM)
-
-	if err = createCSR(csrClient, ctx, csr, csrNameWithServiceAndNamespace); err != nil {
-		log.Fatalf("Create CertificateSigningRequest - error occurred, detail: %v", err)
+	labels, err := certificator.OwnerLabels(options.service, options.namespace, options.secret)
+	if err != nil {
+		return err
 	}
 
-	if err = approveCSR(csrClient, ctx, csr); err != nil {
-		log.Fatalf("Approve CertificateSigningRequest - error occurred, detail: %v", err)
+	err = issueCertificate(ctx, cs, newEventRecorder(cs), options, labels)
+	result := &runResult{
+		namespace: options.namespace,
+		secret:    options.secret,
+		success:   err == nil,
+		err:       err,
+		duration:  time.Since(start),
+		finished:  time.Now(),
 	}
+	if err == nil {
+		log.Printf("Done in %d milliseconds", result.duration.Milliseconds())
+	}
+	if options.runMetrics.enabled() {
+		if result.success {
+			result.certificate = storedCertificate(ctx, cs, options)
+		}
+		publishRunResult(ctx, &options.runMetrics, result)
+	}
+
+	return err
+}
 
-	updatedCsr, err := retrieveUpdatedCSR(csrClient, ctx, csrNameWithServiceAndNamespace)
+// issueCertificate runs the key, CSR and Secret pipeline for options and labels the CSR and
+// the Secret with labels. Errors are returned rather than fatal, so controllers can retry.
+// Progress is recorded as Events on the Service, the Secret and the caBundle targets.
+func issueCertificate(ctx context.Context, cs kubernetes.Interface, recorder record.EventRecorder,
+	options *CreateAndSignCertOptions, labels map[string]string) error {
+	ctx, span := certificator.StartSpan(ctx, "issueCertificate",
+		certificator.AttrNamespace.String(options.namespace), certificator.AttrService.String(options.service),
+		certificator.AttrSecret.String(options.secret), certificator.AttrSigner.String(options.signerLabel()))
+	defer span.End()
+
+	// certify may run before the Service is created, its Events are skipped then
+	service, err := cs.CoreV1().Services(options.namespace).Get(ctx, options.service, metav1.GetOptions{})
 	if err != nil {
-		log.Fatalf("Retrieve updated CertificateSigningRequest - error occurred, detail: %v", err)
+		log.Printf("Service %s/%s, status: Not available for Events, %v", options.namespace, options.service, err)
+		service = nil
+	}
+	serviceEvent := func(eventtype, reason, messageFmt string, args ...interface{}) {
+		if service != nil {
+			recorder.Eventf(service, eventtype, reason, messageFmt, args...)
+		}
 	}
 
-	clientCert := updatedCsr.Status.Certificate
-	if err := createOrUpdateSecret(cs, ctx, clientCert, clientPrivateKeyPEM, namespace, secret); err != nil {
-		log.Fatalf("Secret, status: Error occurred, detail: %v", err)
+	// a certificate replacing one in an existing Secret is notified as renewal
+	renewal := false
+	if options.notify.enabled() {
+		_, getErr := cs.CoreV1().Secrets(options.namespace).Get(ctx, options.secret, metav1.GetOptions{})
+		renewal = getErr == nil
 	}
+	start := time.Now()
 
-	log.Printf("Done in %d milliseconds", time.Since(start).Milliseconds())
+	issuanceAttempts.WithLabelValues(options.signerLabel()).Inc()
+	err = runIssuance(ctx, cs, recorder, serviceEvent, options, labels)
+	if options.notify.enabled() {
+		notifyIssuance(ctx, cs, options, renewal, start, err)
+	}
+	if err != nil {
+		issuanceFailures.WithLabelValues(options.signerLabel(), certificator.FailureReason(err)).Inc()
+		serviceEvent(corev1.EventTypeWarning, eventReasonIssuanceFailed, "Issuing Secret %s failed: %v", options.secret, err)
+		if secret, getErr := cs.CoreV1().Secrets(options.namespace).Get(ctx, options.secret, metav1.GetOptions{}); getErr == nil {
+			recorder.Eventf(secret, corev1.EventTypeWarning, eventReasonIssuanceFailed, "Issuing certificate failed: %v", err)
+		}
+		return certificator.SpanError(span, err)
+	}
 
 	return nil
 }
 
-func generateCertificateRequest(service, namespace string) (
-	*bytes.Buffer, *bytes.Buffer, string, error,
-) {
-	r := strings.NewReplacer("${service}", service, "${namespace}", namespace)
-
-	clientPrivateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+// runIssuance is the pipeline of issueCertificate, serviceEvent records Events on the Service
+func runIssuance(ctx context.Context, cs kubernetes.Interface, recorder record.EventRecorder,
+	serviceEvent func(eventtype, reason, messageFmt string, args ...interface{}),
+	options *CreateAndSignCertOptions, labels map[string]string) error {
+	certIssuer, err := options.newIssuer(cs, recorder, labels)
 	if err != nil {
-		return nil, nil, "", fmt.Errorf("rsa.GenerateKey: %w", err)
+		return certificator.Failure(certificator.ReasonRequest, err)
 	}
-
-	csrNameWithService := r.Replace(csrNameTemplate0)
-	csrNameWithServiceAndNamespace := r.Replace(csrNameTemplate1)
-	csrNameFull := r.Replace(csrNameTemplate2)
-
-	template := x509.CertificateRequest{
-		Subject: pkix.Name{
-			CommonName: csrNameWithServiceAndNamespace,
-		},
-		DNSNames: []string{csrNameWithService, csrNameWithServiceAndNamespace, csrNameFull},
+	result, err := certIssuer.Issue(ctx)
+	if err != nil {
+		return err
+	}
+	if result.CSRName != "" {
+		csrWaitDuration.WithLabelValues(options.signerName).Observe(result.CSRWait.Seconds())
 	}
 
-	csrBytes, err := x509.CreateCertificateRequest(rand.Reader, &template, clientPrivateKey)
+	caBundle, err := injectCABundle(ctx, cs, recorder, options, result.CAPEM)
 	if err != nil {
-		return nil, nil, "", fmt.Errorf("x509.CreateCertificateRequest: %w", err)
+		return certificator.Failure(failureReasonCABundle, err)
 	}
 
-	clientCSRPEM := new(bytes.Buffer)
-	_ = pem.Encode(clientCSRPEM, &pem.Block{
-		Type:  "CERTIFICATE REQUEST",
-		Bytes: csrBytes,
-	})
-
-	clientPrivateKeyPEM := new(bytes.Buffer)
-	_ = pem.Encode(clientPrivateKeyPEM, &pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(clientPrivateKey),
-	})
-
-	return clientCSRPEM, clientPrivateKeyPEM, csrNameWithServiceAndNamespace, nil
+	return rolloutCertificate(ctx, cs, serviceEvent, options, caBundle, result.CertificatePEM)
 }
 
-func createCSRObject(csrName string, clientCSRPEM *bytes.Buffer) *certv1.CertificateSigningRequest {
-	return &certv1.CertificateSigningRequest{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: csrName,
-		},
-		Spec: certv1.CertificateSigningRequestSpec{
-			Request: clientCSRPEM.Bytes(),
-			Usages: []certv1.KeyUsage{
-				certv1.UsageDigitalSignature,
-				certv1.UsageKeyEncipherment,
-				certv1.UsageServerAuth,
-			},
-			Groups:     []string{"system:authenticated"},
-			SignerName: "kubernetes.io/kube-apiserver-client",
-		},
+// newIssuer returns the certificator.Issuer of the certificate described by options, labels are
+// set on the CSR and the Secret
+func (o *CreateAndSignCertOptions) newIssuer(cs kubernetes.Interface, recorder record.EventRecorder,
+	labels map[string]string) (*certificator.Issuer, error) {
+	backend, err := o.issuer.backend(cs, o.namespace, o.timeout)
+	if err != nil {
+		return nil, err
 	}
+
+	return certificator.New(cs, o.service, o.namespace, o.secret,
+		certificator.WithSignerName(o.signerName),
+		certificator.WithKeyAlgorithm(o.request.KeyAlgorithm),
+		certificator.WithDNSNames(o.request.DNSNames...),
+		certificator.WithIPAddresses(o.request.IPAddresses...),
+		certificator.WithDuration(o.duration),
+		certificator.WithStrictDuration(o.strictDuration),
+		certificator.WithApproval(o.approval),
+		certificator.WithTimeout(o.timeout),
+		certificator.WithKeepCSR(o.keepCSR),
+		certificator.WithLabels(labels),
+		certificator.WithEventRecorder(recorder),
+		certificator.WithIssuer(backend))
 }
 
-func createCSR(csrClient certsv1.CertificateSigningRequestInterface, ctx context.Context,
-	csr *certv1.CertificateSigningRequest, csrNameWithServiceAndNamespace string) error {
-	log.Println("Certificate signing request, status: Check if already exists")
-	csExistInCluster, _ := csrClient.Get(ctx, csrNameWithServiceAndNamespace, metav1.GetOptions{})
-	if csExistInCluster.Status.Certificate != nil {
-		log.Println("Certificate signing request, status: Already exists, deleting")
-		if err := csrClient.Delete(ctx, csrNameWithServiceAndNamespace, metav1.DeleteOptions{}); err != nil {
-			log.Printf("Delete CertificateSigningRequest - error occurred, detail: %v, but ignored", err)
-			return err
+// rolloutCertificate restarts the workloads of the stored certificate and verifies that the
+// webhook serves it, as configured by options
+func rolloutCertificate(ctx context.Context, cs kubernetes.Interface,
+	serviceEvent func(eventtype, reason, messageFmt string, args ...interface{}),
+	options *CreateAndSignCertOptions, caBundle, clientCert []byte) error {
+	if options.restart.enabled() {
+		if err := restartWorkloads(ctx, cs, options, clientCert, serviceEvent); err != nil {
+			return certificator.Failure(failureReasonRestart, err)
 		}
-		log.Println("Certificate signing request, status: Deleted")
 	}
 
-	log.Println("Certificate signing request, status: Not exists, creating")
-	if _, err := csrClient.Create(ctx, csr, metav1.CreateOptions{}); err != nil {
-		log.Printf("Create CertificateSigningRequest - error occurred, detail: %v", err)
-		return err
+	if options.verify.enabled() {
+		if err := verifyEndpoint(ctx, cs, options, caBundle, clientCert); err != nil {
+			return certificator.Failure(failureReasonVerify, err)
+		}
+		serviceEvent(corev1.EventTypeNormal, eventReasonEndpointVerified, "Webhook serves the certificate of Secret %s", options.secret)
 	}
-	log.Println("Certificate signing request, status: Created")
 
 	return nil
 }
 
-func approveCSR(csrClient certsv1.CertificateSigningRequestInterface, ctx context.Context,
-	csr *certv1.CertificateSigningRequest) error {
-	log.Println("Certificate signing request, status: Approving")
-
-	csr.Status.Conditions = append(csr.Status.Conditions, certv1.CertificateSigningRequestCondition{
-		Type:           certv1.CertificateApproved,
-		Status:         corev1.ConditionTrue,
-		Reason:         "Self-generated and auto-approved by certificator",
-		Message:        "This CSR was approved by certificator cli",
-		LastUpdateTime: metav1.Now(),
-	})
-
-	if _, err := csrClient.UpdateApproval(ctx, csr.Name, csr, metav1.UpdateOptions{}); err != nil {
-		log.Printf("UpdateApproval - error occurred, detail: %v", err)
-		return err
+// injectCABundle writes the CA of the issued certificate into the caBundle targets and returns
+// it, caCert is the CA returned by the signer, if any. The CA is needed only for caBundle
+// targets and the endpoint verification, it is nil when neither is configured.
+func injectCABundle(ctx context.Context, cs kubernetes.Interface, recorder record.EventRecorder,
+	options *CreateAndSignCertOptions, caCert []byte) ([]byte, error) {
+	if !options.caBundle.enabled() && !options.verify.enabled() {
+		return nil, nil
 	}
-	log.Println("Certificate signing request, status: Approved")
 
-	return nil
-}
-
-func retrieveUpdatedCSR(csrClient certsv1.CertificateSigningRequestInterface, ctx context.Context,
-	csrNameWithServiceAndNamespace string) (*certv1.CertificateSigningRequest, error) {
-	log.Println("Certificate signing request, status: Retrieving updated CSR")
-
-	var updatedCsr *certv1.CertificateSigningRequest
-	var attempt = 0
-
-	for {
-		if attempt < 5 {
-			res, err := csrClient.Get(ctx, csrNameWithServiceAndNamespace, metav1.GetOptions{})
-			if err != nil {
-				log.Fatalf("Get CertificateSigningRequest - error occurred, detail: %v", err)
-			}
-			updatedCsr = res
-			if updatedCsr.Status.Certificate != nil {
-				log.Println("Certificate signing request, status: Certificate Found")
-				break
-			}
-			log.Println("Certificate signing request, status: No certificate found trying after 1 sec")
-			time.Sleep(1 * time.Second)
-		} else {
-			log.Printf("Certificate signing request, status: No certificate found, backed off after 5 attempt")
-			return nil, fmt.Errorf("certificate signing request, status: No certificate found")
+	caBundle := caCert
+	if caBundle == nil {
+		// kubernetes.io/* signers don't return their CA, which is the cluster CA
+		var err error
+		if caBundle, err = clusterCABundle(ctx, cs, options.namespace); err != nil {
+			return nil, err
 		}
-		attempt += 1
+	}
+	if !options.caBundle.enabled() {
+		return caBundle, nil
 	}
 
-	log.Println("Certificate signing request, status: Retrieved")
+	var dyn dynamic.Interface
+	if options.caBundle.dynamic() {
+		var err error
+		if dyn, err = initDynamicClient(options.kubeconfig); err != nil {
+			return nil, err
+		}
+	}
 
-	return updatedCsr, nil
+	return caBundle, patchCABundles(ctx, cs, dyn, recorder, &options.caBundle, caBundle)
 }
@@ -0,0 +1,170 @@
+/*
+Copyright © 2024 Yevhen Lebid ealebed@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"log"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+
+	"github.com/ealebed/admission-webhook-certificator/pkg/certificator"
+)
+
+// configMapPublisher copies the CA into a ConfigMap of every namespace matching a selector
+type configMapPublisher struct {
+	client          kubernetes.Interface
+	namespaceLister corelisters.NamespaceLister
+	configMapLister corelisters.ConfigMapLister
+	secretLister    corelisters.SecretLister
+	caNamespace     string
+	caName          string
+	name            string
+	selector        labels.Selector
+}
+
+// newConfigMapPublisher returns a publisher for the configured CA ConfigMap, or nil when disabled
+func newConfigMapPublisher(cs kubernetes.Interface, options *TrustOptions, caNamespace, caName string) (*configMapPublisher, error) {
+	if options.caConfigMap == "" {
+		return nil, nil
+	}
+	selector, err := labels.Parse(options.caConfigMapSelector)
+	if err != nil {
+		return nil, err
+	}
+
+	return &configMapPublisher{
+		client:      cs,
+		caNamespace: caNamespace,
+		caName:      caName,
+		name:        options.caConfigMap,
+		selector:    selector,
+	}, nil
+}
+
+// controller returns a controller which syncs the CA ConfigMap per namespace, namespaces are
+// enqueued when they change, when their copy changes and when the CA Secret changes
+func (p *configMapPublisher) controller(factory, configMapFactory, secretFactory informers.SharedInformerFactory) (*controller, error) {
+	p.namespaceLister = factory.Core().V1().Namespaces().Lister()
+	p.configMapLister = configMapFactory.Core().V1().ConfigMaps().Lister()
+	p.secretLister = secretFactory.Core().V1().Secrets().Lister()
+
+	c := newController("ca-configmap", p.sync)
+	err := c.watch(factory.Core().V1().Namespaces().Informer(), func(obj interface{}) []string {
+		if namespace, ok := obj.(*corev1.Namespace); ok {
+			return []string{namespace.Name}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = c.watch(configMapFactory.Core().V1().ConfigMaps().Informer(), func(obj interface{}) []string {
+		if configMap, ok := obj.(*corev1.ConfigMap); ok && configMap.Name == p.name {
+			return []string{configMap.Namespace}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = c.watch(secretFactory.Core().V1().Secrets().Informer(), func(obj interface{}) []string {
+		namespaces, listErr := p.namespaceLister.List(labels.Everything())
+		if listErr != nil {
+			return nil
+		}
+		keys := make([]string, 0, len(namespaces))
+		for _, namespace := range namespaces {
+			keys = append(keys, namespace.Name)
+		}
+		return keys
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// sync creates or updates the CA ConfigMap of a matching namespace and removes it otherwise
+func (p *configMapPublisher) sync(ctx context.Context, name string) error {
+	namespace, err := p.namespaceLister.Get(name)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	existing, err := p.configMapLister.ConfigMaps(name).Get(p.name)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	var caPEM []byte
+	secret, err := p.secretLister.Secrets(p.caNamespace).Get(p.caName)
+	switch {
+	case err == nil:
+		caPEM = caBundleFromSecret(secret)
+	case !apierrors.IsNotFound(err):
+		return err
+	}
+
+	if namespace.DeletionTimestamp != nil || !p.selector.Matches(labels.Set(namespace.Labels)) || len(caPEM) == 0 {
+		if existing == nil || existing.Labels[certificator.ManagedByLabel] != certificator.ManagedByValue {
+			return nil
+		}
+		log.Printf("ConfigMap %s/%s, status: Namespace no longer matches, deleting", name, p.name)
+		err = p.client.CoreV1().ConfigMaps(name).Delete(ctx, p.name, metav1.DeleteOptions{
+			Preconditions: &metav1.Preconditions{UID: &existing.UID},
+		})
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      p.name,
+			Namespace: name,
+			Labels:    map[string]string{certificator.ManagedByLabel: certificator.ManagedByValue},
+		},
+		Data: map[string]string{caCertKey: string(caPEM)},
+	}
+	switch {
+	case existing == nil:
+		log.Printf("ConfigMap %s/%s, status: Not exists, creating", name, p.name)
+		_, err = p.client.CoreV1().ConfigMaps(name).Create(ctx, configMap, metav1.CreateOptions{})
+		if apierrors.IsAlreadyExists(err) {
+			// an unmanaged ConfigMap of the same name is not in the cache, leave it alone
+			log.Printf("ConfigMap %s/%s, status: Exists and is not managed by certificator, skipping", name, p.name)
+			return nil
+		}
+	case existing.Data[caCertKey] != configMap.Data[caCertKey]:
+		log.Printf("ConfigMap %s/%s, status: Outdated, updating", name, p.name)
+		configMap.ResourceVersion = existing.ResourceVersion
+		_, err = p.client.CoreV1().ConfigMaps(name).Update(ctx, configMap, metav1.UpdateOptions{})
+	}
+
+	return err
+}
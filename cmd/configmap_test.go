@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/ealebed/admission-webhook-certificator/pkg/certificator"
+)
+
+func TestConfigMapPublisherSync(t *testing.T) {
+	ca := newTestCA(t, time.Hour)
+	caSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "certificator-ca", Namespace: "webhook"},
+		Data:       map[string][]byte{corev1.TLSCertKey: ca.CertificatePEM},
+	}
+	newNamespace := func(labels map[string]string) *corev1.Namespace {
+		return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "clients", Labels: labels}}
+	}
+	newConfigMap := func(managed bool, caPEM string) *corev1.ConfigMap {
+		configMap := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "webhook-ca", Namespace: "clients"},
+			Data:       map[string]string{caCertKey: caPEM},
+		}
+		if managed {
+			configMap.Labels = map[string]string{certificator.ManagedByLabel: certificator.ManagedByValue}
+		}
+		return configMap
+	}
+	matching := map[string]string{"webhook-clients": "true"}
+
+	tests := []struct {
+		name      string
+		namespace *corev1.Namespace
+		configMap *corev1.ConfigMap
+		secret    *corev1.Secret
+		wantCA    string
+		wantGone  bool
+	}{
+		{name: "creates in matching namespace", namespace: newNamespace(matching), secret: caSecret, wantCA: string(ca.CertificatePEM)},
+		{
+			name:      "updates outdated copy",
+			namespace: newNamespace(matching),
+			configMap: newConfigMap(true, "old"),
+			secret:    caSecret,
+			wantCA:    string(ca.CertificatePEM),
+		},
+		{name: "skips namespace not matching", namespace: newNamespace(nil), secret: caSecret, wantGone: true},
+		{
+			name:      "removes stale copy",
+			namespace: newNamespace(nil),
+			configMap: newConfigMap(true, string(ca.CertificatePEM)),
+			secret:    caSecret,
+			wantGone:  true,
+		},
+		{
+			name:      "keeps unmanaged ConfigMap",
+			namespace: newNamespace(nil),
+			configMap: newConfigMap(false, "unmanaged"),
+			secret:    caSecret,
+			wantCA:    "unmanaged",
+		},
+		{name: "waits for CA secret", namespace: newNamespace(matching), wantGone: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objects := []runtime.Object{tt.namespace}
+			namespaces := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			configMaps := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+			secrets := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+			_ = namespaces.Add(tt.namespace)
+			if tt.configMap != nil {
+				objects = append(objects, tt.configMap)
+				// only managed ConfigMaps are cached by the controller
+				if tt.configMap.Labels[certificator.ManagedByLabel] == certificator.ManagedByValue {
+					_ = configMaps.Add(tt.configMap)
+				}
+			}
+			if tt.secret != nil {
+				_ = secrets.Add(tt.secret)
+			}
+			cs := fake.NewClientset(objects...)
+			options := &TrustOptions{caConfigMap: "webhook-ca", caConfigMapSelector: "webhook-clients=true"}
+			p, err := newConfigMapPublisher(cs, options, "webhook", "certificator-ca")
+			if err != nil {
+				t.Fatalf("newConfigMapPublisher() error = %v", err)
+			}
+			p.namespaceLister = corelisters.NewNamespaceLister(namespaces)
+			p.configMapLister = corelisters.NewConfigMapLister(configMaps)
+			p.secretLister = corelisters.NewSecretLister(secrets)
+
+			if err := p.sync(context.TODO(), "clients"); err != nil {
+				t.Fatalf("sync() error = %v", err)
+			}
+
+			configMap, err := cs.CoreV1().ConfigMaps("clients").Get(context.TODO(), "webhook-ca", metav1.GetOptions{})
+			if tt.wantGone {
+				if !apierrors.IsNotFound(err) {
+					t.Errorf("Expected no ConfigMap, got %v, error %v", configMap, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected ConfigMap, got %v", err)
+			}
+			if configMap.Data[caCertKey] != tt.wantCA {
+				t.Errorf("Expected ca.crt '%s', got '%s'", tt.wantCA, configMap.Data[caCertKey])
+			}
+		})
+	}
+}
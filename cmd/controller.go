@@ -0,0 +1,259 @@
+/*
+Copyright © 2024 Yevhen Lebid ealebed@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/util/workqueue"
+
+	"github.com/ealebed/admission-webhook-certificator/pkg/certificator"
+)
+
+// ControllerOptions represents options shared by long-running controller commands
+type ControllerOptions struct {
+	kubeconfig     string
+	workers        int
+	resync         time.Duration
+	leaderElect    bool
+	leaseNamespace string
+	leaseName      string
+	metricsAddress string
+	healthAddress  string
+	enablePprof    bool
+	// apiServerThreshold is how long the API server may be unreachable before the replica is not ready
+	apiServerThreshold time.Duration
+}
+
+// addControllerFlags registers flags shared by long-running controller commands
+func addControllerFlags(cmd *cobra.Command, options *ControllerOptions, leaseName string) {
+	cmd.Flags().StringVarP(&options.kubeconfig, "kubeconfig", "k", "", "kubeconfig path")
+	cmd.Flags().IntVar(&options.workers, "workers", 2, "Number of objects processed concurrently.")
+	cmd.Flags().DurationVar(&options.resync, "resync", 10*time.Minute, "Informer resync period.")
+	cmd.Flags().BoolVar(&options.leaderElect, "leader-elect", false,
+		"Enable leader election, required when running more than one replica.")
+	cmd.Flags().StringVar(&options.leaseNamespace, "leader-elect-namespace", "webhook",
+		"Namespace of the leader election Lease.")
+	cmd.Flags().StringVar(&options.leaseName, "leader-elect-name", leaseName, "Name of the leader election Lease.")
+	cmd.Flags().StringVar(&options.metricsAddress, "metrics-bind-address", ":8080",
+		"Address serving Prometheus metrics on "+metricsPath+", 0 disables it.")
+	cmd.Flags().StringVar(&options.healthAddress, "health-probe-bind-address", ":8081",
+		"Address serving "+healthzPath+" and "+readyzPath+", 0 disables it.")
+	cmd.Flags().BoolVar(&options.enablePprof, "enable-pprof", false,
+		"Serve "+pprofPath+" on the health probe address.")
+	cmd.Flags().DurationVar(&options.apiServerThreshold, "apiserver-unreachable-threshold", time.Minute,
+		"How long the API server may be unreachable before "+readyzPath+" fails.")
+}
+
+// signalContext returns a context canceled on SIGINT or SIGTERM
+func signalContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+// runLeaderElected runs fn, which starts controllers, until ctx is done, holding the Lease first when
+// leader election is enabled. Metrics and health probes are served by every replica, also while
+// waiting for the Lease, readiness waits for the caches of controllers once they run.
+func runLeaderElected(ctx context.Context, cs kubernetes.Interface, options *ControllerOptions,
+	controllers []*controller, fn func(ctx context.Context)) error {
+	health := newHealthChecker(cs, options, controllers)
+	serveMetrics(ctx, options.metricsAddress)
+	serveHTTP(ctx, "Health probes", options.healthAddress, health.handler(options.enablePprof))
+	go health.probeAPIServer(ctx)
+
+	run := func(ctx context.Context) {
+		health.setRunning()
+		fn(ctx)
+	}
+	if !options.leaderElect {
+		run(ctx)
+		return nil
+	}
+
+	identity, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("os.Hostname: %w", err)
+	}
+
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock: &resourcelock.LeaseLock{
+			LeaseMeta: metav1.ObjectMeta{
+				Name:      options.leaseName,
+				Namespace: options.leaseNamespace,
+			},
+			Client:     cs.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+		},
+		LeaseDuration:   15 * time.Second,
+		RenewDeadline:   10 * time.Second,
+		RetryPeriod:     2 * time.Second,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: run,
+			OnStoppedLeading: func() {
+				log.Printf("Leader election, status: Lost lease %s/%s", options.leaseNamespace, options.leaseName)
+			},
+			OnNewLeader: func(leader string) {
+				health.setLeader(leader)
+				log.Printf("Leader election, status: Current leader is %s", leader)
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	elector.Run(ctx)
+
+	return nil
+}
+
+// runControllers runs all controllers until ctx is done
+func runControllers(ctx context.Context, workers int, controllers ...*controller) {
+	var wg sync.WaitGroup
+	for _, c := range controllers {
+		wg.Go(func() {
+			if err := c.run(ctx, workers); err != nil {
+				log.Printf("Controller %s - error occurred, detail: %v", c.name, err)
+			}
+		})
+	}
+	wg.Wait()
+}
+
+// newManagedInformerFactory returns an informer factory which caches only objects managed by certificator
+func newManagedInformerFactory(cs kubernetes.Interface, options *ControllerOptions) informers.SharedInformerFactory {
+	return informers.NewSharedInformerFactoryWithOptions(cs, options.resync,
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.LabelSelector = labels.SelectorFromSet(labels.Set{certificator.ManagedByLabel: certificator.ManagedByValue}).String()
+		}))
+}
+
+// controller processes object keys from a rate limited work queue with a sync function
+type controller struct {
+	name   string
+	queue  workqueue.TypedRateLimitingInterface[string]
+	sync   func(ctx context.Context, key string) error
+	synced []cache.InformerSynced
+}
+
+func newController(name string, sync func(ctx context.Context, key string) error) *controller {
+	return &controller{
+		name: name,
+		queue: workqueue.NewTypedRateLimitingQueueWithConfig(
+			workqueue.DefaultTypedControllerRateLimiter[string](),
+			workqueue.TypedRateLimitingQueueConfig[string]{Name: name, MetricsProvider: workqueueMetrics},
+		),
+		sync: sync,
+	}
+}
+
+// watch registers informer event handlers which enqueue keys returned by keys,
+// the informer must be synced before workers start
+func (c *controller) watch(informer cache.SharedIndexInformer, keys func(obj interface{}) []string) error {
+	enqueue := func(obj interface{}) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		for _, key := range keys(obj) {
+			c.queue.Add(key)
+		}
+	}
+
+	registration, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    enqueue,
+		UpdateFunc: func(_, obj interface{}) { enqueue(obj) },
+		DeleteFunc: enqueue,
+	})
+	if err != nil {
+		return err
+	}
+	c.synced = append(c.synced, registration.HasSynced)
+
+	return nil
+}
+
+// waitFor registers an informer used only through its lister, so workers wait for it to sync
+func (c *controller) waitFor(informer cache.SharedIndexInformer) {
+	c.synced = append(c.synced, informer.HasSynced)
+}
+
+// hasSynced reports whether the informer caches of the controller are synced
+func (c *controller) hasSynced() bool {
+	for _, synced := range c.synced {
+		if !synced() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// run waits for informer caches and processes the queue until ctx is done
+func (c *controller) run(ctx context.Context, workers int) error {
+	defer c.queue.ShutDown()
+
+	log.Printf("Controller %s, status: Waiting for informer caches to sync", c.name)
+	if !cache.WaitForCacheSync(ctx.Done(), c.synced...) {
+		return fmt.Errorf("controller %s: failed to wait for caches to sync", c.name)
+	}
+
+	log.Printf("Controller %s, status: Started %d workers", c.name, workers)
+	for range workers {
+		go wait.UntilWithContext(ctx, c.runWorker, time.Second)
+	}
+	<-ctx.Done()
+	log.Printf("Controller %s, status: Shutting down", c.name)
+
+	return nil
+}
+
+func (c *controller) runWorker(ctx context.Context) {
+	for c.processNextItem(ctx) {
+	}
+}
+
+func (c *controller) processNextItem(ctx context.Context) bool {
+	key, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.queue.Done(key)
+
+	if err := c.sync(ctx, key); err != nil {
+		log.Printf("Controller %s, key %s - error occurred, detail: %v, requeued", c.name, key, err)
+		c.queue.AddRateLimited(key)
+		return true
+	}
+	c.queue.Forget(key)
+
+	return true
+}
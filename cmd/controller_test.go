@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestControllerProcessNextItem(t *testing.T) {
+	tests := []struct {
+		name         string
+		syncErr      error
+		wantRequeues int
+	}{
+		{name: "successful sync forgets key"},
+		{name: "failed sync requeues key", syncErr: errors.New("conflict"), wantRequeues: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var synced []string
+			c := newController("test", func(_ context.Context, key string) error {
+				synced = append(synced, key)
+				return tt.syncErr
+			})
+			defer c.queue.ShutDown()
+
+			c.queue.Add("webhook/webhook-certs")
+			if !c.processNextItem(context.TODO()) {
+				t.Fatal("processNextItem() returned false, expected queue to be running")
+			}
+
+			if len(synced) != 1 || synced[0] != "webhook/webhook-certs" {
+				t.Errorf("Expected key 'webhook/webhook-certs' to be synced once, got %v", synced)
+			}
+			if requeues := c.queue.NumRequeues("webhook/webhook-certs"); requeues != tt.wantRequeues {
+				t.Errorf("Expected %d requeues, got %d", tt.wantRequeues, requeues)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+/*
+Copyright © 2024 Yevhen Lebid ealebed@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/tools/reference"
+)
+
+// Event reasons recorded on the Service, the Secret and the objects receiving the caBundle, next
+// to the certificator.EventReason* ones of the issuance
+const (
+	eventReasonCABundleInjected  = "CABundleInjected"
+	eventReasonWorkloadRestarted = "WorkloadRestarted"
+	eventReasonEndpointVerified  = "EndpointVerified"
+	eventReasonIssuanceFailed    = "IssuanceFailed"
+	eventReasonNearExpiry        = "NearExpiry"
+	eventReasonSecretConflict    = "SecretConflict"
+	eventReasonInvalidNotifier   = "InvalidNotifier"
+)
+
+const (
+	eventComponent = "certificator"
+	// eventTimeout bounds writing one Event, Events are best effort
+	eventTimeout = 10 * time.Second
+	// maxEventObjectNameLength leaves room for the hash suffix in Event names
+	maxEventObjectNameLength = 200
+)
+
+// eventRecorder is an EventRecorder writing Events synchronously, so a certify Job which exits
+// right after its last phase doesn't lose them. Events of the same object, reason and message
+// are aggregated into one with a count, like the client-go event correlator does.
+type eventRecorder struct {
+	client   kubernetes.Interface
+	instance string
+}
+
+// newEventRecorder returns an EventRecorder writing Events through cs
+func newEventRecorder(cs kubernetes.Interface) record.EventRecorder {
+	instance, _ := os.Hostname()
+	return &eventRecorder{client: cs, instance: instance}
+}
+
+// Event records an Event on object
+func (r *eventRecorder) Event(object runtime.Object, eventtype, reason, message string) {
+	r.record(object, nil, eventtype, reason, message)
+}
+
+// Eventf records an Event on object with a formatted message
+func (r *eventRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.record(object, nil, eventtype, reason, fmt.Sprintf(messageFmt, args...))
+}
+
+// AnnotatedEventf records an annotated Event on object with a formatted message
+func (r *eventRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason,
+	messageFmt string, args ...interface{}) {
+	r.record(object, annotations, eventtype, reason, fmt.Sprintf(messageFmt, args...))
+}
+
+func (r *eventRecorder) record(object runtime.Object, annotations map[string]string, eventtype, reason, message string) {
+	ref, err := reference.GetReference(scheme.Scheme, object)
+	if err != nil {
+		log.Printf("Event %s - error occurred, detail: %v", reason, err)
+		return
+	}
+	// Events of cluster-scoped objects live in the default namespace
+	namespace := ref.Namespace
+	if namespace == "" {
+		namespace = metav1.NamespaceDefault
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), eventTimeout)
+	defer cancel()
+
+	events := r.client.CoreV1().Events(namespace)
+	name := eventName(ref, reason, message)
+	now := metav1.Now()
+	if existing, err := events.Get(ctx, name, metav1.GetOptions{}); err == nil {
+		existing.Count++
+		existing.LastTimestamp = now
+		_, err = events.Update(ctx, existing, metav1.UpdateOptions{})
+		if err != nil {
+			log.Printf("Event %s - error occurred, detail: %v", reason, err)
+		}
+		return
+	} else if !apierrors.IsNotFound(err) {
+		log.Printf("Event %s - error occurred, detail: %v", reason, err)
+		return
+	}
+
+	_, err = events.Create(ctx, &corev1.Event{
+		ObjectMeta:          metav1.ObjectMeta{Name: name, Namespace: namespace, Annotations: annotations},
+		InvolvedObject:      *ref,
+		Reason:              reason,
+		Message:             message,
+		Type:                eventtype,
+		Source:              corev1.EventSource{Component: eventComponent, Host: r.instance},
+		FirstTimestamp:      now,
+		LastTimestamp:       now,
+		Count:               1,
+		ReportingController: webhookCertificateGroup + "/" + eventComponent,
+		ReportingInstance:   r.instance,
+	}, metav1.CreateOptions{})
+	if err != nil {
+		log.Printf("Event %s - error occurred, detail: %v", reason, err)
+	}
+}
+
+// eventName returns the same name for Events of one object, reason and message, so they aggregate
+func eventName(ref *corev1.ObjectReference, reason, message string) string {
+	sum := sha256.Sum256([]byte(string(ref.UID) + "\n" + ref.Kind + "\n" + ref.Name + "\n" + reason + "\n" + message))
+	name := ref.Name
+	if len(name) > maxEventObjectNameLength {
+		name = name[:maxEventObjectNameLength]
+	}
+
+	return name + "." + hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/ealebed/admission-webhook-certificator/pkg/certificator"
+)
+
+func TestEventRecorder(t *testing.T) {
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "webhook-certs", Namespace: "webhook", UID: "secret-uid"}}
+	config := &admissionv1.MutatingWebhookConfiguration{ObjectMeta: metav1.ObjectMeta{Name: "webhook-cfg", UID: "config-uid"}}
+
+	tests := []struct {
+		name      string
+		record    func(r *eventRecorder)
+		namespace string
+		wantCount []int32
+	}{
+		{
+			name: "aggregates repeated events",
+			record: func(r *eventRecorder) {
+				r.Event(secret, corev1.EventTypeWarning, eventReasonIssuanceFailed, "signer unavailable")
+				r.Event(secret, corev1.EventTypeWarning, eventReasonIssuanceFailed, "signer unavailable")
+			},
+			namespace: "webhook",
+			wantCount: []int32{2},
+		},
+		{
+			name: "keeps distinct messages apart",
+			record: func(r *eventRecorder) {
+				r.Eventf(secret, corev1.EventTypeNormal, certificator.EventReasonSecretUpdated, "Certificate of %s stored", "csr-a")
+				r.Eventf(secret, corev1.EventTypeNormal, certificator.EventReasonSecretUpdated, "Certificate of %s stored", "csr-b")
+			},
+			namespace: "webhook",
+			wantCount: []int32{1, 1},
+		},
+		{
+			name: "records cluster-scoped objects in default namespace",
+			record: func(r *eventRecorder) {
+				r.Event(config, corev1.EventTypeNormal, eventReasonCABundleInjected, "caBundle updated")
+			},
+			namespace: metav1.NamespaceDefault,
+			wantCount: []int32{1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := fake.NewClientset()
+			r := newEventRecorder(cs).(*eventRecorder)
+			tt.record(r)
+
+			events, err := cs.CoreV1().Events(tt.namespace).List(context.TODO(), metav1.ListOptions{})
+			if err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+			if len(events.Items) != len(tt.wantCount) {
+				t.Fatalf("Expected %d events, got %d", len(tt.wantCount), len(events.Items))
+			}
+			for i, event := range events.Items {
+				if event.Count != tt.wantCount[i] {
+					t.Errorf("Expected count %d, got %d", tt.wantCount[i], event.Count)
+				}
+				if event.InvolvedObject.UID == "" || event.Source.Component != eventComponent {
+					t.Errorf("Expected involved object with UID and certificator source, got %+v", event)
+				}
+			}
+		})
+	}
+}
+
+func TestEventRecorderUnknownObject(t *testing.T) {
+	cs := fake.NewClientset()
+	// objects unknown to the scheme can't be referenced and are skipped
+	newEventRecorder(cs).Event(&runtime.Unknown{}, corev1.EventTypeNormal, certificator.EventReasonCSRCreated, "skipped")
+
+	if len(cs.Actions()) != 0 {
+		t.Errorf("Expected no API calls, got %v", cs.Actions())
+	}
+}
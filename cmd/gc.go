@@ -0,0 +1,97 @@
+/*
+Copyright © 2024 Yevhen Lebid ealebed@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	certsv1 "k8s.io/client-go/kubernetes/typed/certificates/v1"
+
+	"github.com/ealebed/admission-webhook-certificator/pkg/certificator"
+)
+
+// GarbageCollectOptions represents options for garbage collect command
+type GarbageCollectOptions struct {
+	maxAge     time.Duration
+	dryRun     bool
+	kubeconfig string
+}
+
+// NewGarbageCollectCmd returns new garbage collect command
+func NewGarbageCollectCmd() *cobra.Command {
+	options := GarbageCollectOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Delete stale CertificateSigningRequests created by certificator.",
+		Long: "This command removes CertificateSigningRequests labeled as managed by certificator\n" +
+			"which are older than the given age, e.g. left behind by interrupted or --keep-csr runs.",
+		Example: "gc [--max-age=1h --dry-run]",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cs, err := initK8sClient(options.kubeconfig)
+			if err != nil {
+				return err
+			}
+			_, err = collectGarbageCSRs(cs.CertificatesV1().CertificateSigningRequests(), context.TODO(),
+				options.maxAge, options.dryRun)
+			return err
+		},
+	}
+
+	cmd.Flags().DurationVar(&options.maxAge, "max-age", time.Hour,
+		"Delete CertificateSigningRequests older than this age.")
+	cmd.Flags().BoolVar(&options.dryRun, "dry-run", false, "Only print CertificateSigningRequests which would be deleted.")
+	cmd.Flags().StringVarP(&options.kubeconfig, "kubeconfig", "k", "", "kubeconfig path")
+
+	return cmd
+}
+
+// collectGarbageCSRs deletes CSRs created by certificator which are older than maxAge
+// and returns the number of deleted objects
+func collectGarbageCSRs(csrClient certsv1.CertificateSigningRequestInterface, ctx context.Context,
+	maxAge time.Duration, dryRun bool) (int, error) {
+	csrs, err := csrClient.List(ctx, metav1.ListOptions{
+		LabelSelector: certificator.ManagedByLabel + "=" + certificator.ManagedByValue,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for i := range csrs.Items {
+		csr := &csrs.Items[i]
+		if time.Since(csr.CreationTimestamp.Time) < maxAge {
+			continue
+		}
+		if dryRun {
+			log.Printf("Certificate signing request %s, status: Stale, would be deleted", csr.Name)
+			continue
+		}
+		if err := csrClient.Delete(ctx, csr.Name, metav1.DeleteOptions{}); err != nil {
+			log.Printf("Delete CertificateSigningRequest %s - error occurred, detail: %v", csr.Name, err)
+			return deleted, err
+		}
+		log.Printf("Certificate signing request %s, status: Stale, deleted", csr.Name)
+		deleted++
+	}
+
+	return deleted, nil
+}
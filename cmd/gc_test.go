@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	certv1 "k8s.io/api/certificates/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/ealebed/admission-webhook-certificator/pkg/certificator"
+)
+
+func newTestCSR(name string, age time.Duration, labels map[string]string) *certv1.CertificateSigningRequest {
+	return &certv1.CertificateSigningRequest{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Labels:            labels,
+			CreationTimestamp: metav1.NewTime(time.Now().Add(-age)),
+		},
+	}
+}
+
+func TestCollectGarbageCSRs(t *testing.T) {
+	managed := map[string]string{certificator.ManagedByLabel: certificator.ManagedByValue}
+
+	tests := []struct {
+		name        string
+		dryRun      bool
+		wantDeleted int
+		wantLeft    []string
+	}{
+		{
+			name:        "deletes stale managed CSRs only",
+			wantDeleted: 1,
+			wantLeft:    []string{"fresh", "foreign"},
+		},
+		{
+			name:        "dry run keeps everything",
+			dryRun:      true,
+			wantDeleted: 0,
+			wantLeft:    []string{"stale", "fresh", "foreign"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := fake.NewClientset(
+				newTestCSR("stale", 2*time.Hour, managed),
+				newTestCSR("fresh", time.Minute, managed),
+				newTestCSR("foreign", 2*time.Hour, nil),
+			)
+			csrClient := cs.CertificatesV1().CertificateSigningRequests()
+
+			deleted, err := collectGarbageCSRs(csrClient, context.TODO(), time.Hour, tt.dryRun)
+			if err != nil {
+				t.Fatalf("collectGarbageCSRs() error = %v", err)
+			}
+			if deleted != tt.wantDeleted {
+				t.Errorf("Expected %d deleted CSRs, got %d", tt.wantDeleted, deleted)
+			}
+
+			left, _ := csrClient.List(context.TODO(), metav1.ListOptions{})
+			if len(left.Items) != len(tt.wantLeft) {
+				t.Fatalf("Expected %d CSRs left, got %d", len(tt.wantLeft), len(left.Items))
+			}
+			for _, name := range tt.wantLeft {
+				if _, err := csrClient.Get(context.TODO(), name, metav1.GetOptions{}); err != nil {
+					t.Errorf("Expected CSR '%s' to be kept, got error %v", name, err)
+				}
+			}
+		})
+	}
+}
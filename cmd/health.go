@@ -0,0 +1,172 @@
+/*
+Copyright © 2024 Yevhen Lebid ealebed@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	healthzPath = "/healthz"
+	readyzPath  = "/readyz"
+	pprofPath   = "/debug/pprof/"
+
+	// apiServerProbeInterval is how often the API server is contacted to check it is reachable
+	apiServerProbeInterval = 10 * time.Second
+)
+
+// healthChecker reports readiness of a long-running command: its API server is reachable, a
+// leader is elected when leader election is enabled, and the caches of running controllers are synced
+type healthChecker struct {
+	client      kubernetes.Interface
+	controllers []*controller
+	leaderElect bool
+	threshold   time.Duration
+	now         func() time.Time
+
+	mu          sync.RWMutex
+	lastContact time.Time
+	running     bool
+	leading     bool
+	leader      string
+}
+
+func newHealthChecker(cs kubernetes.Interface, options *ControllerOptions, controllers []*controller) *healthChecker {
+	return &healthChecker{
+		client:      cs,
+		controllers: controllers,
+		leaderElect: options.leaderElect,
+		threshold:   options.apiServerThreshold,
+		now:         time.Now,
+		lastContact: time.Now(),
+	}
+}
+
+// probeAPIServer records when the API server last answered until ctx is done
+func (h *healthChecker) probeAPIServer(ctx context.Context) {
+	wait.UntilWithContext(ctx, func(context.Context) {
+		if _, err := h.client.Discovery().ServerVersion(); err != nil {
+			log.Printf("API server - error occurred, detail: %v", err)
+			return
+		}
+		h.mu.Lock()
+		h.lastContact = h.now()
+		h.mu.Unlock()
+	}, apiServerProbeInterval)
+}
+
+// setRunning marks the controllers as started, by this leader or without leader election
+func (h *healthChecker) setRunning() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.running = true
+	h.leading = h.leaderElect
+}
+
+// setLeader records the identity of the current leader
+func (h *healthChecker) setLeader(leader string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.leader = leader
+}
+
+// checks returns the result of every readiness check by name, nil when it passes
+func (h *healthChecker) checks() ([]string, map[string]error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	results := map[string]error{}
+	names := []string{"apiserver"}
+	if unreachable := h.now().Sub(h.lastContact); unreachable > h.threshold {
+		results["apiserver"] = fmt.Errorf("unreachable for %s", unreachable.Round(time.Second))
+	}
+	if h.leaderElect {
+		names = append(names, "leader-election")
+		if !h.leading && h.leader == "" {
+			results["leader-election"] = fmt.Errorf("no leader elected yet")
+		}
+	}
+	// a standby replica runs no controllers, so it has no caches to wait for
+	names = append(names, "informer-sync")
+	if h.running || !h.leaderElect {
+		for _, c := range h.controllers {
+			if !c.hasSynced() {
+				results["informer-sync"] = fmt.Errorf("controller %s caches not synced", c.name)
+				break
+			}
+		}
+	}
+
+	return names, results
+}
+
+// readyz serves the readiness checks, listing each of them like the API server does
+func (h *healthChecker) readyz(w http.ResponseWriter, _ *http.Request) {
+	names, results := h.checks()
+
+	var body strings.Builder
+	status := http.StatusOK
+	for _, name := range names {
+		if err := results[name]; err != nil {
+			status = http.StatusServiceUnavailable
+			fmt.Fprintf(&body, "[-]%s failed: %v\n", name, err)
+		} else {
+			fmt.Fprintf(&body, "[+]%s ok\n", name)
+		}
+	}
+	if status == http.StatusOK {
+		body.WriteString("readyz check passed\n")
+	} else {
+		body.WriteString("readyz check failed\n")
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(body.String()))
+}
+
+// healthz reports the process is alive and serving
+func healthz(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write([]byte("ok"))
+}
+
+// handler returns the health endpoints, with pprof when enabled
+func (h *healthChecker) handler(enablePprof bool) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(healthzPath, healthz)
+	mux.HandleFunc(readyzPath, h.readyz)
+	if enablePprof {
+		mux.HandleFunc(pprofPath, pprof.Index)
+		mux.HandleFunc(pprofPath+"cmdline", pprof.Cmdline)
+		mux.HandleFunc(pprofPath+"profile", pprof.Profile)
+		mux.HandleFunc(pprofPath+"symbol", pprof.Symbol)
+		mux.HandleFunc(pprofPath+"trace", pprof.Trace)
+	}
+
+	return mux
+}
@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestHealthCheckerReadyz(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name        string
+		leaderElect bool
+		running     bool
+		leader      string
+		synced      bool
+		lastContact time.Time
+		wantStatus  int
+		wantFailed  string
+	}{
+		{
+			name:        "ready once controllers synced",
+			running:     true,
+			synced:      true,
+			lastContact: now,
+			wantStatus:  http.StatusOK,
+		},
+		{
+			name:        "not ready before caches sync",
+			running:     true,
+			lastContact: now,
+			wantStatus:  http.StatusServiceUnavailable,
+			wantFailed:  "informer-sync",
+		},
+		{
+			name:        "ready as standby of an elected leader",
+			leaderElect: true,
+			leader:      "other-replica",
+			lastContact: now,
+			wantStatus:  http.StatusOK,
+		},
+		{
+			name:        "not ready without a leader",
+			leaderElect: true,
+			lastContact: now,
+			wantStatus:  http.StatusServiceUnavailable,
+			wantFailed:  "leader-election",
+		},
+		{
+			name:        "not ready as leader before caches sync",
+			leaderElect: true,
+			running:     true,
+			lastContact: now,
+			wantStatus:  http.StatusServiceUnavailable,
+			wantFailed:  "informer-sync",
+		},
+		{
+			name:        "not ready when API server unreachable",
+			running:     true,
+			synced:      true,
+			lastContact: now.Add(-2 * time.Minute),
+			wantStatus:  http.StatusServiceUnavailable,
+			wantFailed:  "apiserver",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newController("health-test", nil)
+			defer c.queue.ShutDown()
+			c.synced = append(c.synced, func() bool { return tt.synced })
+
+			h := newHealthChecker(fake.NewClientset(), &ControllerOptions{leaderElect: tt.leaderElect, apiServerThreshold: time.Minute},
+				[]*controller{c})
+			h.now = func() time.Time { return now }
+			h.lastContact = tt.lastContact
+			h.setLeader(tt.leader)
+			if tt.running {
+				h.setRunning()
+			}
+
+			recorder := httptest.NewRecorder()
+			h.handler(false).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, readyzPath, nil))
+			if recorder.Code != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d: %s", tt.wantStatus, recorder.Code, recorder.Body)
+			}
+			if tt.wantFailed != "" && !strings.Contains(recorder.Body.String(), "[-]"+tt.wantFailed+" failed") {
+				t.Errorf("Expected %s check failed, got %s", tt.wantFailed, recorder.Body)
+			}
+		})
+	}
+}
+
+func TestHealthCheckerProbeAPIServer(t *testing.T) {
+	h := newHealthChecker(fake.NewClientset(), &ControllerOptions{apiServerThreshold: time.Minute}, nil)
+	h.lastContact = time.Time{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	h.now = func() time.Time {
+		cancel()
+		return time.Now()
+	}
+	h.probeAPIServer(ctx)
+
+	if _, results := h.checks(); results["apiserver"] != nil {
+		t.Errorf("Expected API server reachable, got %v", results["apiserver"])
+	}
+}
+
+func TestHealthHandlerPprof(t *testing.T) {
+	h := newHealthChecker(fake.NewClientset(), &ControllerOptions{apiServerThreshold: time.Minute}, nil)
+
+	for _, enabled := range []bool{false, true} {
+		recorder := httptest.NewRecorder()
+		h.handler(enabled).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, pprofPath, nil))
+		if (recorder.Code == http.StatusOK) != enabled {
+			t.Errorf("Expected pprof served %v, got status %d", enabled, recorder.Code)
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	h.handler(false).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, healthzPath, nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected healthz ok, got %d", recorder.Code)
+	}
+}
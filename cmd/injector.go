@@ -0,0 +1,271 @@
+/*
+Copyright © 2024 Yevhen Lebid ealebed@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	// injectCAFromAnnotation names the namespace/name of a Secret whose ca.crt is injected as caBundle
+	injectCAFromAnnotation = "certificator.ealebed.io/inject-ca-from"
+	// injectCAFromIndex indexes injection targets by the Secret they reference
+	injectCAFromIndex = "injectCAFrom"
+)
+
+// NewInjectorCmd returns new injector command
+func NewInjectorCmd() *cobra.Command {
+	options := ControllerOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "injector",
+		Short: "Run a controller which keeps caBundles in sync with the ca.crt of annotated Secrets.",
+		Long: "This command watches MutatingWebhookConfigurations, ValidatingWebhookConfigurations,\n" +
+			"CustomResourceDefinitions and APIServices annotated with " + injectCAFromAnnotation + ": namespace/name\n" +
+			"and writes the ca.crt of the referenced Secret into their caBundle, again whenever the Secret changes.",
+		Example: "injector [--leader-elect]",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runInjector(&options)
+		},
+	}
+
+	addControllerFlags(cmd, &options, "certificator-injector")
+
+	return cmd
+}
+
+func runInjector(options *ControllerOptions) error {
+	cs, err := initK8sClient(options.kubeconfig)
+	if err != nil {
+		return err
+	}
+	dyn, err := initDynamicClient(options.kubeconfig)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	factory := informers.NewSharedInformerFactory(cs, options.resync)
+	dynamicFactory := dynamicinformer.NewDynamicSharedInformerFactory(dyn, options.resync)
+	i := newInjector(cs, dyn, newEventRecorder(cs), factory, dynamicFactory)
+	registerCertificateExpiry(i.secretLister)
+	c, err := i.controller(factory)
+	if err != nil {
+		return err
+	}
+
+	return runLeaderElected(ctx, cs, options, []*controller{c}, func(ctx context.Context) {
+		factory.Start(ctx.Done())
+		dynamicFactory.Start(ctx.Done())
+		runControllers(ctx, options.workers, c)
+	})
+}
+
+// injectionTarget is a kind of object with a caBundle, whose objects are queued as kind/name
+type injectionTarget struct {
+	kind     string
+	informer cache.SharedIndexInformer
+	patch    func(ctx context.Context, name string, caBundle []byte) error
+}
+
+// injector writes the ca.crt of Secrets into the caBundle of objects referencing them
+type injector struct {
+	secretLister corelisters.SecretLister
+	targets      []injectionTarget
+}
+
+func newInjector(cs kubernetes.Interface, dyn dynamic.Interface, recorder record.EventRecorder, factory informers.SharedInformerFactory,
+	dynamicFactory dynamicinformer.DynamicSharedInformerFactory) *injector {
+	return &injector{
+		secretLister: factory.Core().V1().Secrets().Lister(),
+		targets: []injectionTarget{
+			{
+				kind:     "MutatingWebhookConfiguration",
+				informer: factory.Admissionregistration().V1().MutatingWebhookConfigurations().Informer(),
+				patch: func(ctx context.Context, name string, caBundle []byte) error {
+					return patchMutatingWebhookCABundle(ctx, cs, recorder, name, caBundle)
+				},
+			},
+			{
+				kind:     "ValidatingWebhookConfiguration",
+				informer: factory.Admissionregistration().V1().ValidatingWebhookConfigurations().Informer(),
+				patch: func(ctx context.Context, name string, caBundle []byte) error {
+					return patchValidatingWebhookCABundle(ctx, cs, recorder, name, caBundle)
+				},
+			},
+			{
+				kind:     "CustomResourceDefinition",
+				informer: dynamicFactory.ForResource(crdResource).Informer(),
+				patch: func(ctx context.Context, name string, caBundle []byte) error {
+					return patchCRDCABundle(ctx, dyn, recorder, name, caBundle)
+				},
+			},
+			{
+				kind:     "APIService",
+				informer: dynamicFactory.ForResource(apiServiceResource).Informer(),
+				patch: func(ctx context.Context, name string, caBundle []byte) error {
+					return patchAPIServiceCABundle(ctx, dyn, recorder, name, caBundle)
+				},
+			},
+		},
+	}
+}
+
+// controller returns a controller which syncs annotated objects when they or their Secret change
+func (i *injector) controller(factory informers.SharedInformerFactory) (*controller, error) {
+	c := newController("injector", i.sync)
+	for _, target := range i.targets {
+		if err := target.informer.AddIndexers(cache.Indexers{injectCAFromIndex: injectCAFromIndexFunc}); err != nil {
+			return nil, err
+		}
+		err := c.watch(target.informer, func(obj interface{}) []string {
+			if object, err := meta.Accessor(obj); err == nil && object.GetAnnotations()[injectCAFromAnnotation] != "" {
+				return []string{target.kind + "/" + object.GetName()}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := c.watch(factory.Core().V1().Secrets().Informer(), func(obj interface{}) []string {
+		if secret, ok := obj.(*corev1.Secret); ok {
+			return i.keysForSecret(secret.Namespace + "/" + secret.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// injectCAFromIndexFunc indexes objects by the value of their inject-ca-from annotation
+func injectCAFromIndexFunc(obj interface{}) ([]string, error) {
+	object, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, err
+	}
+	if ref := object.GetAnnotations()[injectCAFromAnnotation]; ref != "" {
+		return []string{ref}, nil
+	}
+
+	return nil, nil
+}
+
+// keysForSecret returns the keys of all objects injected from the namespace/name Secret
+func (i *injector) keysForSecret(ref string) []string {
+	var keys []string
+	for _, target := range i.targets {
+		objects, err := target.informer.GetIndexer().ByIndex(injectCAFromIndex, ref)
+		if err != nil {
+			continue
+		}
+		for _, obj := range objects {
+			if object, err := meta.Accessor(obj); err == nil {
+				keys = append(keys, target.kind+"/"+object.GetName())
+			}
+		}
+	}
+
+	return keys
+}
+
+func (i *injector) sync(ctx context.Context, key string) error {
+	kind, name, _ := strings.Cut(key, "/")
+	idx := slices.IndexFunc(i.targets, func(target injectionTarget) bool { return target.kind == kind })
+	if idx < 0 {
+		return fmt.Errorf("unknown injection target kind %q", kind)
+	}
+	target := i.targets[idx]
+
+	obj, exists, err := target.informer.GetIndexer().GetByKey(name)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		caBundleSyncLag.DeleteLabelValues(kind, name)
+		return nil
+	}
+	object, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+	ref := object.GetAnnotations()[injectCAFromAnnotation]
+	if ref == "" {
+		return nil
+	}
+	namespace, secretName, err := splitNamespacedName(ref)
+	if err != nil {
+		log.Printf("%s %s, status: Invalid %s annotation, %v", kind, name, injectCAFromAnnotation, err)
+		return nil
+	}
+
+	secret, err := i.secretLister.Secrets(namespace).Get(secretName)
+	if apierrors.IsNotFound(err) {
+		log.Printf("%s %s, status: Waiting for Secret %s", kind, name, ref)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	caBundle := secret.Data[caCertKey]
+	if len(caBundle) == 0 {
+		log.Printf("%s %s, status: Secret %s has no %s, waiting", kind, name, ref, caCertKey)
+		return nil
+	}
+
+	if err = target.patch(ctx, name, caBundle); err != nil {
+		caBundleSyncLag.WithLabelValues(kind, name).Set(time.Since(secretChangeTime(secret)).Seconds())
+		return err
+	}
+	caBundleSyncLag.WithLabelValues(kind, name).Set(0)
+
+	return nil
+}
+
+// secretChangeTime returns when a Secret was last written, by its newest managed fields entry
+func secretChangeTime(secret *corev1.Secret) time.Time {
+	changed := secret.CreationTimestamp.Time
+	for _, entry := range secret.ManagedFields {
+		if entry.Time != nil && entry.Time.After(changed) {
+			changed = entry.Time.Time
+		}
+	}
+
+	return changed
+}
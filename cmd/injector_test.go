@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestInjectorSync(t *testing.T) {
+	annotated := map[string]string{injectCAFromAnnotation: "webhook/webhook-certs"}
+	mutating := &admissionv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: "webhook-cfg", Annotations: annotated},
+		Webhooks:   []admissionv1.MutatingWebhook{{Name: "a.webhook.io"}},
+	}
+	unannotated := &admissionv1.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: "other-cfg"},
+		Webhooks:   []admissionv1.ValidatingWebhook{{Name: "b.webhook.io"}},
+	}
+	crd := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apiextensions.k8s.io/v1",
+		"kind":       "CustomResourceDefinition",
+		"metadata":   map[string]interface{}{"name": "widgets.example.io", "annotations": map[string]interface{}{injectCAFromAnnotation: "webhook/webhook-certs"}},
+		"spec":       map[string]interface{}{"conversion": map[string]interface{}{"strategy": "Webhook"}},
+	}}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "webhook-certs", Namespace: "webhook"},
+		Data:       map[string][]byte{caCertKey: []byte("ca")},
+	}
+
+	cs := fake.NewClientset(mutating, unannotated, secret)
+	dyn := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{crdResource: "CustomResourceDefinitionList", apiServiceResource: "APIServiceList"}, crd)
+	factory := informers.NewSharedInformerFactory(cs, time.Minute)
+	dynamicFactory := dynamicinformer.NewDynamicSharedInformerFactory(dyn, time.Minute)
+	i := newInjector(cs, dyn, record.NewFakeRecorder(10), factory, dynamicFactory)
+	if _, err := i.controller(factory); err != nil {
+		t.Fatalf("controller() error = %v", err)
+	}
+	_ = i.targets[0].informer.GetIndexer().Add(mutating)
+	_ = i.targets[1].informer.GetIndexer().Add(unannotated)
+	_ = i.targets[2].informer.GetIndexer().Add(crd)
+	_ = factory.Core().V1().Secrets().Informer().GetIndexer().Add(secret)
+
+	wantKeys := []string{"MutatingWebhookConfiguration/webhook-cfg", "CustomResourceDefinition/widgets.example.io"}
+	if keys := i.keysForSecret("webhook/webhook-certs"); !slices.Equal(keys, wantKeys) {
+		t.Errorf("Expected keys %v for Secret, got %v", wantKeys, keys)
+	}
+
+	tests := []struct {
+		name   string
+		key    string
+		caData func() []byte
+	}{
+		{
+			name: "injects into webhook configuration",
+			key:  "MutatingWebhookConfiguration/webhook-cfg",
+			caData: func() []byte {
+				config, _ := cs.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(context.TODO(), "webhook-cfg", metav1.GetOptions{})
+				return config.Webhooks[0].ClientConfig.CABundle
+			},
+		},
+		{
+			name: "injects into CRD",
+			key:  "CustomResourceDefinition/widgets.example.io",
+			caData: func() []byte {
+				obj, _ := dyn.Resource(crdResource).Get(context.TODO(), "widgets.example.io", metav1.GetOptions{})
+				caBundle, _, _ := unstructured.NestedString(obj.Object, "spec", "conversion", "webhook", "clientConfig", "caBundle")
+				return []byte(caBundle)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := i.sync(context.TODO(), tt.key); err != nil {
+				t.Fatalf("sync() error = %v", err)
+			}
+			if got := tt.caData(); len(got) == 0 {
+				t.Error("Expected caBundle to be injected")
+			}
+			kind, name, _ := strings.Cut(tt.key, "/")
+			if lag := testutil.ToFloat64(caBundleSyncLag.WithLabelValues(kind, name)); lag != 0 {
+				t.Errorf("Expected no caBundle sync lag once injected, got %f", lag)
+			}
+		})
+	}
+
+	if err := i.sync(context.TODO(), "ValidatingWebhookConfiguration/other-cfg"); err != nil {
+		t.Fatalf("sync() error = %v", err)
+	}
+	config, _ := cs.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(context.TODO(), "other-cfg", metav1.GetOptions{})
+	if len(config.Webhooks[0].ClientConfig.CABundle) > 0 {
+		t.Error("Expected no caBundle for configuration without annotation")
+	}
+}
@@ -0,0 +1,120 @@
+/*
+Copyright © 2024 Yevhen Lebid ealebed@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/ealebed/admission-webhook-certificator/pkg/issuer"
+)
+
+// IssuerOptions represents the backend signing the certificate requests
+type IssuerOptions struct {
+	kind    string
+	secret  string
+	command string
+}
+
+// addIssuerFlags registers flags selecting the issuer of the certificate
+func addIssuerFlags(cmd *cobra.Command, options *IssuerOptions) {
+	cmd.Flags().StringVar(&options.kind, "issuer", issuer.KindKubernetes,
+		"Issuer signing the certificate, one of: "+strings.Join(issuer.Kinds, ", ")+
+			". kubernetes uses the CertificateSigningRequest API and --signer-name.")
+	cmd.Flags().StringVar(&options.secret, "issuer-secret", "",
+		"Secret holding the CA certificate and key of the ca issuer, as namespace/name or a name in --namespace.")
+	cmd.Flags().StringVar(&options.command, "issuer-exec", "",
+		"Command of the exec issuer, it reads the PEM CSR on stdin and writes the PEM certificate chain to stdout.")
+}
+
+// validate checks that the issuer kind is known and configured
+func (o *IssuerOptions) validate() error {
+	switch o.kind {
+	case "", issuer.KindKubernetes, issuer.KindSelfSigned:
+	case issuer.KindSecretCA:
+		if o.secret == "" {
+			return fmt.Errorf("--issuer-secret is required by the %s issuer", issuer.KindSecretCA)
+		}
+	case issuer.KindExec:
+		if len(strings.Fields(o.command)) == 0 {
+			return fmt.Errorf("--issuer-exec is required by the %s issuer", issuer.KindExec)
+		}
+	default:
+		return fmt.Errorf("unsupported issuer %q, expected one of: %s", o.kind, strings.Join(issuer.Kinds, ", "))
+	}
+
+	return nil
+}
+
+// args returns command line arguments reproducing the options
+func (o *IssuerOptions) args() []string {
+	var args []string
+	if !o.kubernetes() {
+		args = append(args, "--issuer="+o.kind)
+	}
+	if o.secret != "" {
+		args = append(args, "--issuer-secret="+o.secret)
+	}
+	if o.command != "" {
+		args = append(args, "--issuer-exec="+o.command)
+	}
+
+	return args
+}
+
+// kubernetes reports whether certificates are signed through the CertificateSigningRequest API
+func (o *IssuerOptions) kubernetes() bool {
+	return o.kind == "" || o.kind == issuer.KindKubernetes
+}
+
+// backend returns the issuer signing the certificates of a Secret in namespace, nil for the
+// default CertificateSigningRequest API
+func (o *IssuerOptions) backend(cs kubernetes.Interface, namespace string, timeout time.Duration) (issuer.Issuer, error) {
+	switch o.kind {
+	case "", issuer.KindKubernetes:
+		return nil, nil
+	case issuer.KindSecretCA:
+		caNamespace, name := namespace, o.secret
+		if strings.Contains(o.secret, "/") {
+			var err error
+			if caNamespace, name, err = splitNamespacedName(o.secret); err != nil {
+				return nil, err
+			}
+		}
+		return issuer.NewSecretCA(cs, caNamespace, name), nil
+	case issuer.KindSelfSigned:
+		return issuer.SelfSigned{}, nil
+	case issuer.KindExec:
+		return issuer.NewExec(strings.Fields(o.command), timeout), nil
+	default:
+		return nil, fmt.Errorf("unsupported issuer %q, expected one of: %s", o.kind, strings.Join(issuer.Kinds, ", "))
+	}
+}
+
+// signerLabel returns the signer of the certificate in metrics, the signer name for the
+// CertificateSigningRequest API and the issuer kind otherwise
+func (o *CreateAndSignCertOptions) signerLabel() string {
+	if o.issuer.kubernetes() {
+		return o.signerName
+	}
+
+	return o.issuer.kind
+}
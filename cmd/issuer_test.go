@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/ealebed/admission-webhook-certificator/pkg/issuer"
+)
+
+func TestIssuerOptions(t *testing.T) {
+	tests := []struct {
+		name        string
+		options     IssuerOptions
+		wantErr     bool
+		wantArgs    []string
+		wantBackend string
+	}{
+		{name: "kubernetes by default", options: IssuerOptions{}},
+		{name: "kubernetes", options: IssuerOptions{kind: issuer.KindKubernetes}},
+		{
+			name:        "ca in the Secret namespace",
+			options:     IssuerOptions{kind: issuer.KindSecretCA, secret: "webhook-ca"},
+			wantArgs:    []string{"--issuer=ca", "--issuer-secret=webhook-ca"},
+			wantBackend: "CA of Secret webhook/webhook-ca",
+		},
+		{
+			name:        "ca in another namespace",
+			options:     IssuerOptions{kind: issuer.KindSecretCA, secret: "certificator/webhook-ca"},
+			wantArgs:    []string{"--issuer=ca", "--issuer-secret=certificator/webhook-ca"},
+			wantBackend: "CA of Secret certificator/webhook-ca",
+		},
+		{name: "ca without Secret", options: IssuerOptions{kind: issuer.KindSecretCA}, wantErr: true},
+		{
+			name:        "selfsigned",
+			options:     IssuerOptions{kind: issuer.KindSelfSigned},
+			wantArgs:    []string{"--issuer=selfsigned"},
+			wantBackend: "self-signed CA",
+		},
+		{
+			name:        "exec",
+			options:     IssuerOptions{kind: issuer.KindExec, command: "/bin/sign --profile webhook"},
+			wantArgs:    []string{"--issuer=exec", "--issuer-exec=/bin/sign --profile webhook"},
+			wantBackend: "exec /bin/sign",
+		},
+		{name: "exec without command", options: IssuerOptions{kind: issuer.KindExec, command: " "}, wantErr: true},
+		{name: "unknown", options: IssuerOptions{kind: "vault"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.options.validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := strings.Join(tt.options.args(), " "); got != strings.Join(tt.wantArgs, " ") {
+				t.Errorf("Expected args %v, got %s", tt.wantArgs, got)
+			}
+
+			backend, err := tt.options.backend(fake.NewClientset(), "webhook", time.Minute)
+			if err != nil {
+				t.Fatalf("backend() error = %v", err)
+			}
+			if tt.wantBackend == "" {
+				if backend != nil {
+					t.Errorf("Expected the CSR API, got %v", backend)
+				}
+				return
+			}
+			if backend.String() != tt.wantBackend {
+				t.Errorf("Expected backend %q, got %v", tt.wantBackend, backend)
+			}
+		})
+	}
+}
@@ -17,18 +17,19 @@ limitations under the License.
 package cmd
 
 import (
+	"fmt"
+	"strings"
+
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
 func initK8sClient(kubeconfig string) (*kubernetes.Clientset, error) {
-	var config *rest.Config
-
-	if kubeconfig == "" {
-		config, _ = initInClusterClient()
-	} else {
-		config, _ = initOutOfClusterClient(kubeconfig)
+	config, err := initClientConfig(kubeconfig)
+	if err != nil {
+		return nil, err
 	}
 
 	clientset, err := kubernetes.NewForConfig(config)
@@ -39,6 +40,29 @@ func initK8sClient(kubeconfig string) (*kubernetes.Clientset, error) {
 	return clientset, nil
 }
 
+func initDynamicClient(kubeconfig string) (dynamic.Interface, error) {
+	config, err := initClientConfig(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// initClientConfig returns the in-cluster config, or the config of kubeconfig when it is set
+func initClientConfig(kubeconfig string) (*rest.Config, error) {
+	if kubeconfig == "" {
+		return initInClusterClient()
+	}
+
+	return initOutOfClusterClient(kubeconfig)
+}
+
 func initInClusterClient() (*rest.Config, error) {
 	// creates the in-cluster config
 	config, err := rest.InClusterConfig()
@@ -46,7 +70,7 @@ func initInClusterClient() (*rest.Config, error) {
 		return nil, err
 	}
 
-	return config, nil
+	return traceTransport(config), nil
 }
 
 func initOutOfClusterClient(kubeconfig string) (*rest.Config, error) {
@@ -56,5 +80,15 @@ func initOutOfClusterClient(kubeconfig string) (*rest.Config, error) {
 		return nil, err
 	}
 
-	return config, nil
+	return traceTransport(config), nil
+}
+
+// splitNamespacedName parses a namespace/name reference
+func splitNamespacedName(value string) (namespace, name string, err error) {
+	namespace, name, ok := strings.Cut(value, "/")
+	if !ok || namespace == "" || name == "" {
+		return "", "", fmt.Errorf("invalid reference %q, expected namespace/name", value)
+	}
+
+	return namespace, name, nil
 }
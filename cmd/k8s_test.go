@@ -28,34 +28,22 @@ func TestInitK8sClient(t *testing.T) {
 	tests := []struct {
 		name       string
 		kubeconfig string
-		wantPanic  bool
 	}{
-		{
-			name:       "non-existent kubeconfig file",
-			kubeconfig: "/nonexistent/path/to/kubeconfig",
-			wantPanic:  true, // Will panic because config is nil and NewForConfig doesn't handle nil
-		},
+		{name: "non-existent kubeconfig file", kubeconfig: "/nonexistent/path/to/kubeconfig"},
+		{name: "in-cluster config outside a cluster"},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if tt.wantPanic {
-				defer func() {
-					if r := recover(); r == nil {
-						t.Error("initK8sClient() should have panicked with nil config")
-					}
-				}()
+			if tt.kubeconfig == "" {
+				t.Setenv("KUBERNETES_SERVICE_HOST", "")
 			}
 
-			clientset, err := initK8sClient(tt.kubeconfig)
-			if !tt.wantPanic {
-				if err != nil && clientset == nil {
-					// Error case is acceptable
-					return
-				}
-				if clientset == nil {
-					t.Error("initK8sClient() returned nil clientset, expected valid clientset")
-				}
+			if clientset, err := initK8sClient(tt.kubeconfig); err == nil || clientset != nil {
+				t.Errorf("initK8sClient() = %v, %v, expected an error", clientset, err)
+			}
+			if client, err := initDynamicClient(tt.kubeconfig); err == nil || client != nil {
+				t.Errorf("initDynamicClient() = %v, %v, expected an error", client, err)
 			}
 		})
 	}
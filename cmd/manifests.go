@@ -0,0 +1,422 @@
+/*
+Copyright © 2024 Yevhen Lebid ealebed@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/spf13/cobra"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/yaml"
+
+	"github.com/ealebed/admission-webhook-certificator/cmd/version"
+	"github.com/ealebed/admission-webhook-certificator/pkg/certificator"
+)
+
+const (
+	manifestKindJob        = "job"
+	manifestKindCronJob    = "cronjob"
+	manifestKindDeployment = "deployment"
+
+	// controllers run by --kind=deployment
+	manifestControllerOperator   = "operator"
+	manifestControllerReconciler = "reconciler"
+
+	// ports of the rendered Deployment, the defaults of --metrics-bind-address and --health-probe-bind-address
+	controllerMetricsPort = 8080
+	controllerHealthPort  = 8081
+	controllerReplicas    = 2
+
+	defaultImageRepository = "ealebed/certificator"
+)
+
+// ManifestsOptions represents options for manifests command
+type ManifestsOptions struct {
+	certify    CreateAndSignCertOptions
+	kind       string
+	controller string
+	name       string
+	image      string
+	schedule   string
+}
+
+// NewManifestsCmd returns new manifests command
+func NewManifestsCmd(out io.Writer) *cobra.Command {
+	options := ManifestsOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "manifests",
+		Short: "Render deployment manifests for running certify inside the cluster.",
+		Long: "This command renders ServiceAccount, RBAC and a Job or CronJob which runs certify\n" +
+			"with the given flags, or a Deployment running the operator or reconciler controller with the\n" +
+			"certify flags they share, as a multi-document YAML stream suitable for kustomize.",
+		Example: "manifests --service=webhook-svc --namespace=webhook [--kind=cronjob --schedule='0 0 1 * *']\n" +
+			"manifests --kind=deployment --controller=reconciler --namespace=certificator",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if options.kind != manifestKindDeployment && options.certify.service == "" {
+				return fmt.Errorf("--service is required with --kind=%s", options.kind)
+			}
+			if err := options.certify.validate(); err != nil {
+				return err
+			}
+			objects, err := renderManifests(&options)
+			if err != nil {
+				return err
+			}
+			return writeManifests(out, objects)
+		},
+	}
+
+	addCertifyFlags(cmd, &options.certify)
+	cmd.Flags().StringVar(&options.kind, "kind", manifestKindJob,
+		"Workload kind, one of: job, cronjob which run certify, deployment which runs --controller.")
+	cmd.Flags().StringVar(&options.controller, "controller", manifestControllerOperator,
+		"Controller run by --kind=deployment, one of: operator, reconciler.")
+	cmd.Flags().StringVar(&options.name, "name", "webhook-cert",
+		"Prefix for the names of rendered objects.")
+	cmd.Flags().StringVar(&options.image, "image", defaultImageRepository+":"+version.String(),
+		"Container image for the certify workload.")
+	cmd.Flags().StringVar(&options.schedule, "schedule", "0 0 1 * *",
+		"Cron schedule for periodic renewal, used with --kind=cronjob.")
+
+	return cmd
+}
+
+// renderManifests builds the objects needed to run certify in the cluster
+func renderManifests(options *ManifestsOptions) ([]runtime.Object, error) {
+	serviceAccount := options.name + "-sa"
+	labels := map[string]string{
+		"app.kubernetes.io/name":     "certificator",
+		"app.kubernetes.io/instance": options.name,
+		"app.kubernetes.io/version":  version.String(),
+	}
+
+	rules := certifyPolicyRules(&options.certify)
+	if options.kind == manifestKindDeployment {
+		rules = append(rules, controllerPolicyRules(options.controller)...)
+	}
+
+	objects := []runtime.Object{
+		&corev1.ServiceAccount{
+			TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "ServiceAccount"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      serviceAccount,
+				Namespace: options.certify.namespace,
+				Labels:    labels,
+			},
+		},
+		&rbacv1.ClusterRole{
+			TypeMeta: metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   serviceAccount + "-cluster-role",
+				Labels: labels,
+			},
+			Rules: rules,
+		},
+		&rbacv1.ClusterRoleBinding{
+			TypeMeta: metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRoleBinding"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   serviceAccount + "-role-binding",
+				Labels: labels,
+			},
+			RoleRef: rbacv1.RoleRef{
+				APIGroup: "rbac.authorization.k8s.io",
+				Kind:     "ClusterRole",
+				Name:     serviceAccount + "-cluster-role",
+			},
+			Subjects: []rbacv1.Subject{{
+				Kind:      "ServiceAccount",
+				Name:      serviceAccount,
+				Namespace: options.certify.namespace,
+			}},
+		},
+	}
+
+	podTemplate := corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{Labels: labels},
+		Spec: corev1.PodSpec{
+			ServiceAccountName: serviceAccount,
+			Containers: []corev1.Container{{
+				Name:            options.name + "-setup",
+				Image:           options.image,
+				Args:            append([]string{"certify"}, options.certify.args()...),
+				ImagePullPolicy: corev1.PullIfNotPresent,
+			}},
+			RestartPolicy: corev1.RestartPolicyNever,
+		},
+	}
+	backoffLimit := int32(0)
+	jobSpec := batchv1.JobSpec{
+		Template:     podTemplate,
+		BackoffLimit: &backoffLimit,
+	}
+
+	switch options.kind {
+	case manifestKindJob:
+		objects = append(objects, &batchv1.Job{
+			TypeMeta: metav1.TypeMeta{APIVersion: "batch/v1", Kind: "Job"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      options.name + "-setup",
+				Namespace: options.certify.namespace,
+				Labels:    labels,
+			},
+			Spec: jobSpec,
+		})
+	case manifestKindCronJob:
+		objects = append(objects, &batchv1.CronJob{
+			TypeMeta: metav1.TypeMeta{APIVersion: "batch/v1", Kind: "CronJob"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      options.name + "-renewal",
+				Namespace: options.certify.namespace,
+				Labels:    labels,
+			},
+			Spec: batchv1.CronJobSpec{
+				Schedule:          options.schedule,
+				ConcurrencyPolicy: batchv1.ForbidConcurrent,
+				JobTemplate: batchv1.JobTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{Labels: labels},
+					Spec:       jobSpec,
+				},
+			},
+		})
+	case manifestKindDeployment:
+		deployment, err := renderDeployment(options, labels, serviceAccount)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, deployment)
+	default:
+		return nil, fmt.Errorf("unsupported workload kind %q, expected one of: %s, %s, %s",
+			options.kind, manifestKindJob, manifestKindCronJob, manifestKindDeployment)
+	}
+
+	return objects, nil
+}
+
+// renderDeployment builds the Deployment running the controller, with leader election, probes and metrics
+func renderDeployment(options *ManifestsOptions, labels map[string]string, serviceAccount string) (*appsv1.Deployment, error) {
+	if options.controller != manifestControllerOperator && options.controller != manifestControllerReconciler {
+		return nil, fmt.Errorf("unsupported controller %q, expected one of: %s, %s",
+			options.controller, manifestControllerOperator, manifestControllerReconciler)
+	}
+
+	probe := func(path string) *corev1.Probe {
+		return &corev1.Probe{ProbeHandler: corev1.ProbeHandler{
+			HTTPGet: &corev1.HTTPGetAction{Path: path, Port: intstr.FromString("health")},
+		}}
+	}
+	replicas := int32(controllerReplicas)
+
+	return &appsv1.Deployment{
+		TypeMeta: metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      options.name + "-" + options.controller,
+			Namespace: options.certify.namespace,
+			Labels:    labels,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			// the version label changes on upgrades, the selector is immutable
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{
+				"app.kubernetes.io/name":     labels["app.kubernetes.io/name"],
+				"app.kubernetes.io/instance": labels["app.kubernetes.io/instance"],
+			}},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					ServiceAccountName: serviceAccount,
+					Containers: []corev1.Container{{
+						Name:            options.controller,
+						Image:           options.image,
+						Args:            options.controllerArgs(),
+						ImagePullPolicy: corev1.PullIfNotPresent,
+						Ports: []corev1.ContainerPort{
+							{Name: "metrics", ContainerPort: controllerMetricsPort},
+							{Name: "health", ContainerPort: controllerHealthPort},
+						},
+						LivenessProbe:  probe(healthzPath),
+						ReadinessProbe: probe(readyzPath),
+					}},
+				},
+			},
+		},
+	}, nil
+}
+
+// controllerArgs returns the command line of the controller, reproducing the certify options it shares
+func (o *ManifestsOptions) controllerArgs() []string {
+	certify := &o.certify
+	args := []string{o.controller, "--leader-elect", "--leader-elect-namespace=" + certify.namespace}
+	if o.controller == manifestControllerOperator && certify.duration != 0 && certify.duration != certificator.DefaultDuration {
+		args = append(args, "--duration="+certify.duration.String())
+	}
+	args = append(args, certify.csrArgs()...)
+	if o.controller == manifestControllerOperator {
+		args = append(args, certify.issuer.args()...)
+	}
+
+	return append(args, certify.notify.args()...)
+}
+
+// controllerPolicyRules returns RBAC rules the controller needs beyond the ones of certify
+func controllerPolicyRules(controller string) []rbacv1.PolicyRule {
+	rules := []rbacv1.PolicyRule{
+		{
+			APIGroups: []string{""},
+			Resources: []string{"secrets"},
+			Verbs:     []string{"list", "watch"},
+		},
+		{
+			APIGroups: []string{"coordination.k8s.io"},
+			Resources: []string{"leases"},
+			Verbs:     []string{"get", "create", "update"},
+		},
+	}
+	if controller != manifestControllerReconciler {
+		return append(rules, rbacv1.PolicyRule{
+			APIGroups: []string{""},
+			Resources: []string{"services"},
+			Verbs:     []string{"list", "watch"},
+		})
+	}
+
+	return append(rules,
+		rbacv1.PolicyRule{
+			APIGroups: []string{webhookCertificateGroup},
+			Resources: []string{webhookCertificateResource.Resource},
+			Verbs:     []string{"get", "list", "watch"},
+		},
+		rbacv1.PolicyRule{
+			APIGroups: []string{webhookCertificateGroup},
+			Resources: []string{webhookCertificateResource.Resource + "/status"},
+			Verbs:     []string{"update"},
+		},
+	)
+}
+
+// certifyPolicyRules returns RBAC rules required by certify, CSR permissions are granted
+// only with the kubernetes issuer and approval permissions only when certify approves its own CSR
+func certifyPolicyRules(options *CreateAndSignCertOptions) []rbacv1.PolicyRule {
+	approval, signerName, caBundle := options.approval, options.signerName, &options.caBundle
+	rules := []rbacv1.PolicyRule{
+		{
+			APIGroups: []string{"admissionregistration.k8s.io"},
+			Resources: []string{"mutatingwebhookconfigurations", "validatingwebhookconfigurations"},
+			Verbs:     []string{"get", "create", "patch", "update"},
+		},
+		{
+			APIGroups: []string{""},
+			Resources: []string{"secrets"},
+			Verbs:     []string{"create", "get", "patch", "update"},
+		},
+		{
+			APIGroups: []string{""},
+			Resources: []string{"configmaps", "services"},
+			Verbs:     []string{"get"},
+		},
+		{
+			APIGroups: []string{""},
+			Resources: []string{"events"},
+			Verbs:     []string{"create", "get", "update"},
+		},
+	}
+
+	if len(caBundle.crds) > 0 || caBundle.crdSelector != "" {
+		rules = append(rules, rbacv1.PolicyRule{
+			APIGroups: []string{"apiextensions.k8s.io"},
+			Resources: []string{"customresourcedefinitions"},
+			Verbs:     []string{"get", "list", "update"},
+		})
+	}
+	if len(caBundle.apiServices) > 0 {
+		rules = append(rules, rbacv1.PolicyRule{
+			APIGroups: []string{"apiregistration.k8s.io"},
+			Resources: []string{"apiservices"},
+			Verbs:     []string{"get", "update"},
+		})
+	}
+
+	if kinds := options.restart.kinds(); len(kinds) > 0 {
+		var resources []string
+		for _, kind := range kinds {
+			resources = append(resources, kind+"s")
+		}
+		rules = append(rules, rbacv1.PolicyRule{
+			APIGroups: []string{"apps"},
+			Resources: resources,
+			Verbs:     []string{"get", "patch"},
+		})
+	}
+
+	if !options.issuer.kubernetes() {
+		return rules
+	}
+	rules = append(rules, rbacv1.PolicyRule{
+		APIGroups: []string{"certificates.k8s.io"},
+		Resources: []string{"certificatesigningrequests"},
+		Verbs:     []string{"get", "create", "delete", "list", "watch"},
+	})
+	if approval == certificator.ApprovalExternal {
+		return rules
+	}
+	approvedSigners := []string{signerName}
+	if signerName == "" || strings.HasPrefix(signerName, "kubernetes.io/") {
+		approvedSigners = []string{"kubernetes.io/*"}
+	}
+
+	return append(rules,
+		rbacv1.PolicyRule{
+			APIGroups: []string{"certificates.k8s.io"},
+			Resources: []string{"certificatesigningrequests/approval"},
+			Verbs:     []string{"update"},
+		},
+		rbacv1.PolicyRule{
+			APIGroups:     []string{"certificates.k8s.io"},
+			Resources:     []string{"signers"},
+			ResourceNames: approvedSigners,
+			Verbs:         []string{"approve"},
+		},
+	)
+}
+
+// writeManifests writes objects as a multi-document YAML stream
+func writeManifests(out io.Writer, objects []runtime.Object) error {
+	for i, object := range objects {
+		data, err := yaml.Marshal(object)
+		if err != nil {
+			return fmt.Errorf("yaml.Marshal: %w", err)
+		}
+		if i > 0 {
+			if _, err := io.WriteString(out, "---\n"); err != nil {
+				return err
+			}
+		}
+		if _, err := out.Write(data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,305 @@
+package cmd
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/ealebed/admission-webhook-certificator/cmd/version"
+	"github.com/ealebed/admission-webhook-certificator/pkg/issuer"
+)
+
+func TestRenderManifests(t *testing.T) {
+	certify := CreateAndSignCertOptions{
+		service:   "webhook-svc",
+		namespace: "webhook",
+		secret:    "webhook-certs",
+	}
+	wantArgs := []string{"certify", "--service=webhook-svc", "--namespace=webhook", "--secret=webhook-certs"}
+
+	tests := []struct {
+		name     string
+		options  ManifestsOptions
+		wantErr  bool
+		validate func(t *testing.T, objects []runtime.Object)
+	}{
+		{
+			name:    "job",
+			options: ManifestsOptions{certify: certify, kind: "job", name: "webhook-cert", image: "certificator:test"},
+			validate: func(t *testing.T, objects []runtime.Object) {
+				if len(objects) != 4 {
+					t.Fatalf("Expected 4 objects, got %d", len(objects))
+				}
+				binding, ok := objects[2].(*rbacv1.ClusterRoleBinding)
+				if !ok {
+					t.Fatalf("Expected ClusterRoleBinding, got %T", objects[2])
+				}
+				if binding.Subjects[0].Name != "webhook-cert-sa" || binding.Subjects[0].Namespace != "webhook" {
+					t.Errorf("Unexpected binding subject %v", binding.Subjects[0])
+				}
+				job, ok := objects[3].(*batchv1.Job)
+				if !ok {
+					t.Fatalf("Expected Job, got %T", objects[3])
+				}
+				if job.Namespace != "webhook" {
+					t.Errorf("Expected Job namespace 'webhook', got '%s'", job.Namespace)
+				}
+				container := job.Spec.Template.Spec.Containers[0]
+				if container.Image != "certificator:test" {
+					t.Errorf("Expected image 'certificator:test', got '%s'", container.Image)
+				}
+				if strings.Join(container.Args, " ") != strings.Join(wantArgs, " ") {
+					t.Errorf("Expected args %v, got %v", wantArgs, container.Args)
+				}
+				if job.Spec.Template.Spec.ServiceAccountName != "webhook-cert-sa" {
+					t.Errorf("Expected service account 'webhook-cert-sa', got '%s'", job.Spec.Template.Spec.ServiceAccountName)
+				}
+			},
+		},
+		{
+			name: "cronjob",
+			options: ManifestsOptions{
+				certify: certify, kind: "cronjob", name: "renew", image: "certificator:test", schedule: "@weekly",
+			},
+			validate: func(t *testing.T, objects []runtime.Object) {
+				cronJob, ok := objects[len(objects)-1].(*batchv1.CronJob)
+				if !ok {
+					t.Fatalf("Expected CronJob, got %T", objects[len(objects)-1])
+				}
+				if cronJob.Spec.Schedule != "@weekly" {
+					t.Errorf("Expected schedule '@weekly', got '%s'", cronJob.Spec.Schedule)
+				}
+				if cronJob.Spec.ConcurrencyPolicy != batchv1.ForbidConcurrent {
+					t.Errorf("Expected concurrency policy Forbid, got '%s'", cronJob.Spec.ConcurrencyPolicy)
+				}
+				if cronJob.Spec.JobTemplate.Spec.Template.Spec.ServiceAccountName != "renew-sa" {
+					t.Error("Expected CronJob to run with 'renew-sa' service account")
+				}
+			},
+		},
+		{
+			name: "external approval",
+			options: ManifestsOptions{
+				certify: CreateAndSignCertOptions{service: "webhook-svc", namespace: "webhook", secret: "webhook-certs", approval: "external"},
+				kind:    "job", name: "webhook-cert",
+			},
+			validate: func(t *testing.T, objects []runtime.Object) {
+				role := objects[1].(*rbacv1.ClusterRole)
+				for _, rule := range role.Rules {
+					for _, resource := range rule.Resources {
+						if resource == "signers" || resource == "certificatesigningrequests/approval" {
+							t.Errorf("Expected no approval permissions in external mode, got rule %v", rule)
+						}
+					}
+				}
+				job := objects[3].(*batchv1.Job)
+				if args := strings.Join(job.Spec.Template.Spec.Containers[0].Args, " "); !strings.Contains(args, "--approval=external") {
+					t.Errorf("Expected certify args to contain '--approval=external', got '%s'", args)
+				}
+			},
+		},
+		{
+			name: "self-signed issuer",
+			options: ManifestsOptions{
+				certify: CreateAndSignCertOptions{
+					service: "webhook-svc", namespace: "webhook", secret: "webhook-certs",
+					issuer: IssuerOptions{kind: issuer.KindSelfSigned},
+				},
+				kind: "job", name: "webhook-cert",
+			},
+			validate: func(t *testing.T, objects []runtime.Object) {
+				for _, rule := range objects[1].(*rbacv1.ClusterRole).Rules {
+					for _, resource := range rule.Resources {
+						if strings.HasPrefix(resource, "certificatesigningrequests") || resource == "signers" {
+							t.Errorf("Expected no CSR permissions with the selfsigned issuer, got rule %v", rule)
+						}
+					}
+				}
+				job := objects[3].(*batchv1.Job)
+				if args := strings.Join(job.Spec.Template.Spec.Containers[0].Args, " "); !strings.Contains(args, "--issuer=selfsigned") {
+					t.Errorf("Expected certify args to contain '--issuer=selfsigned', got '%s'", args)
+				}
+			},
+		},
+		{
+			name: "caBundle targets",
+			options: ManifestsOptions{
+				certify: CreateAndSignCertOptions{
+					service: "webhook-svc", namespace: "webhook", secret: "webhook-certs",
+					caBundle: CABundleOptions{crdSelector: "app=widgets", apiServices: []string{"v1.widgets.example.io"}},
+				},
+				kind: "job", name: "webhook-cert",
+			},
+			validate: func(t *testing.T, objects []runtime.Object) {
+				granted := map[string]bool{}
+				for _, rule := range objects[1].(*rbacv1.ClusterRole).Rules {
+					for _, resource := range rule.Resources {
+						granted[resource] = true
+					}
+				}
+				if !granted["customresourcedefinitions"] || !granted["apiservices"] {
+					t.Errorf("Expected permissions on CRDs and APIServices, got %v", granted)
+				}
+				job := objects[3].(*batchv1.Job)
+				args := strings.Join(job.Spec.Template.Spec.Containers[0].Args, " ")
+				if !strings.Contains(args, "--crd-selector=app=widgets --apiservice=v1.widgets.example.io") {
+					t.Errorf("Expected certify args to contain caBundle targets, got '%s'", args)
+				}
+			},
+		},
+		{
+			name: "restarted workloads",
+			options: ManifestsOptions{
+				certify: CreateAndSignCertOptions{
+					service: "webhook-svc", namespace: "webhook", secret: "webhook-certs",
+					restart: RestartOptions{workloads: []string{"deployment/webhook", "ds/webhook-agent"}, wait: true},
+				},
+				kind: "job", name: "webhook-cert",
+			},
+			validate: func(t *testing.T, objects []runtime.Object) {
+				granted := map[string]bool{}
+				for _, rule := range objects[1].(*rbacv1.ClusterRole).Rules {
+					for _, resource := range rule.Resources {
+						granted[resource] = true
+					}
+				}
+				if !granted["deployments"] || !granted["daemonsets"] || granted["statefulsets"] {
+					t.Errorf("Expected permissions on the restarted workload kinds only, got %v", granted)
+				}
+				job := objects[3].(*batchv1.Job)
+				args := strings.Join(job.Spec.Template.Spec.Containers[0].Args, " ")
+				if !strings.Contains(args, "--restart=deployment/webhook --restart=ds/webhook-agent --restart-wait") {
+					t.Errorf("Expected certify args to contain restarted workloads, got '%s'", args)
+				}
+			},
+		},
+		{
+			name: "operator deployment",
+			options: ManifestsOptions{
+				certify: CreateAndSignCertOptions{
+					namespace: "certificator", duration: 720 * time.Hour, signerName: webhookServingSignerName,
+					issuer: IssuerOptions{kind: issuer.KindSelfSigned},
+				},
+				kind: "deployment", controller: "operator", name: "certificator", image: "certificator:test",
+			},
+			validate: func(t *testing.T, objects []runtime.Object) {
+				granted := map[string][]string{}
+				for _, rule := range objects[1].(*rbacv1.ClusterRole).Rules {
+					for _, resource := range rule.Resources {
+						granted[resource] = append(granted[resource], rule.Verbs...)
+					}
+				}
+				if !slices.Contains(granted["services"], "watch") || !slices.Contains(granted["leases"], "update") {
+					t.Errorf("Expected permissions to watch Services and hold the Lease, got %v", granted)
+				}
+				deployment, ok := objects[3].(*appsv1.Deployment)
+				if !ok {
+					t.Fatalf("Expected Deployment, got %T", objects[3])
+				}
+				if deployment.Name != "certificator-operator" || deployment.Namespace != "certificator" || *deployment.Spec.Replicas != 2 {
+					t.Errorf("Unexpected Deployment %s/%s with %d replicas", deployment.Namespace, deployment.Name, *deployment.Spec.Replicas)
+				}
+				container := deployment.Spec.Template.Spec.Containers[0]
+				wantArgs := "operator --leader-elect --leader-elect-namespace=certificator --duration=720h0m0s " +
+					"--signer-name=" + webhookServingSignerName + " --issuer=selfsigned"
+				if args := strings.Join(container.Args, " "); args != wantArgs {
+					t.Errorf("Expected args '%s', got '%s'", wantArgs, args)
+				}
+				if len(container.Ports) != 2 || container.LivenessProbe.HTTPGet.Path != healthzPath || container.ReadinessProbe.HTTPGet.Path != readyzPath {
+					t.Errorf("Expected metrics and health ports and probes, got %+v", container)
+				}
+				if _, ok := deployment.Spec.Selector.MatchLabels["app.kubernetes.io/version"]; ok {
+					t.Error("Expected a selector without the version label")
+				}
+			},
+		},
+		{
+			name: "reconciler deployment",
+			options: ManifestsOptions{
+				certify: CreateAndSignCertOptions{namespace: "certificator", duration: 720 * time.Hour, approval: "external"},
+				kind:    "deployment", controller: "reconciler", name: "certificator",
+			},
+			validate: func(t *testing.T, objects []runtime.Object) {
+				granted := map[string]bool{}
+				for _, rule := range objects[1].(*rbacv1.ClusterRole).Rules {
+					for _, resource := range rule.Resources {
+						granted[resource] = true
+					}
+				}
+				if !granted["webhookcertificates"] || !granted["webhookcertificates/status"] || granted["signers"] {
+					t.Errorf("Expected permissions on WebhookCertificates without approval, got %v", granted)
+				}
+				container := objects[3].(*appsv1.Deployment).Spec.Template.Spec.Containers[0]
+				wantArgs := "reconciler --leader-elect --leader-elect-namespace=certificator --approval=external"
+				if args := strings.Join(container.Args, " "); args != wantArgs {
+					t.Errorf("Expected args '%s', got '%s'", wantArgs, args)
+				}
+			},
+		},
+		{
+			name:    "unsupported controller",
+			options: ManifestsOptions{certify: certify, kind: "deployment", controller: "approver", name: "certificator"},
+			wantErr: true,
+		},
+		{
+			name:    "unsupported kind",
+			options: ManifestsOptions{certify: certify, kind: "pod", name: "webhook-cert"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objects, err := renderManifests(&tt.options)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("renderManifests() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && tt.validate != nil {
+				tt.validate(t, objects)
+			}
+		})
+	}
+}
+
+func TestManifestsCmdOutput(t *testing.T) {
+	out := bytes.NewBufferString("")
+	cmd := NewCmdRoot(out)
+	cmd.SetArgs([]string{"manifests", "--service=webhook-svc"})
+
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+
+	documents := strings.Split(out.String(), "---\n")
+	if len(documents) != 4 {
+		t.Fatalf("Expected 4 YAML documents, got %d", len(documents))
+	}
+	for _, kind := range []string{"ServiceAccount", "ClusterRole", "ClusterRoleBinding", "Job"} {
+		if !strings.Contains(out.String(), "kind: "+kind+"\n") {
+			t.Errorf("Expected output to contain %s", kind)
+		}
+	}
+	if !strings.Contains(out.String(), "image: ealebed/certificator:"+version.String()) {
+		t.Error("Expected image tag to be pinned to the current version")
+	}
+
+	cmd = NewCmdRoot(out)
+	cmd.SetArgs([]string{"manifests"})
+	if err := cmd.Execute(); err == nil || !strings.Contains(err.Error(), "--service is required") {
+		t.Errorf("Expected --service required with the default kind, got %v", err)
+	}
+	out.Reset()
+	cmd = NewCmdRoot(out)
+	cmd.SetArgs([]string{"manifests", "--kind=deployment"})
+	if err := cmd.Execute(); err != nil || !strings.Contains(out.String(), "kind: Deployment\n") {
+		t.Errorf("Expected a Deployment without --service, got %v", err)
+	}
+}
@@ -0,0 +1,225 @@
+/*
+Copyright © 2024 Yevhen Lebid ealebed@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/util/workqueue"
+
+	"github.com/ealebed/admission-webhook-certificator/pkg/certificator"
+)
+
+const (
+	metricsNamespace = "certificator"
+	// metricsPath serves the Prometheus metrics of long-running commands
+	metricsPath = "/metrics"
+	// serverShutdownTimeout bounds draining in-flight requests on exit
+	serverShutdownTimeout = 5 * time.Second
+)
+
+// metricsRegistry holds all certificator metrics, the Go runtime and process metrics included
+var metricsRegistry = prometheus.NewRegistry()
+
+var (
+	issuanceAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "issuance_attempts_total",
+		Help:      "Number of certificate issuance attempts, by signer.",
+	}, []string{"signer"})
+	issuanceFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "issuance_failures_total",
+		Help:      "Number of failed certificate issuance attempts, by signer and failure reason.",
+	}, []string{"signer", "reason"})
+	csrWaitDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "csr_wait_duration_seconds",
+		Help:      "Time from creating a CertificateSigningRequest until its certificate is issued, by signer.",
+		Buckets:   prometheus.ExponentialBuckets(0.25, 2, 12),
+	}, []string{"signer"})
+	caBundleSyncLag = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "ca_bundle_sync_lag_seconds",
+		Help:      "Time since the source Secret changed while the caBundle of an injection target isn't updated yet, 0 once in sync.",
+	}, []string{"kind", "name"})
+
+	certificateNotAfterDesc = prometheus.NewDesc(metricsNamespace+"_certificate_not_after_seconds",
+		"Expiry of the certificate stored in a Secret managed by certificator, in seconds since the epoch.",
+		[]string{"namespace", "secret"}, nil)
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		issuanceAttempts,
+		issuanceFailures,
+		csrWaitDuration,
+		caBundleSyncLag,
+	)
+	workqueueMetrics.register(metricsRegistry)
+}
+
+// serveMetrics serves metricsPath on address until ctx is done, an empty address or 0 disables it
+func serveMetrics(ctx context.Context, address string) {
+	mux := http.NewServeMux()
+	mux.Handle(metricsPath, promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}))
+	serveHTTP(ctx, "Metrics", address, mux)
+}
+
+// serveHTTP serves handler on address until ctx is done, an empty address or 0 disables it
+func serveHTTP(ctx context.Context, name, address string, handler http.Handler) {
+	if address == "" || address == "0" {
+		return
+	}
+	server := &http.Server{Addr: address, Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		log.Printf("%s, status: Serving on %s", name, address)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("%s - error occurred, detail: %v", name, err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+}
+
+// certificateExpiryCollector reports the expiry of certificates in Secrets managed by certificator,
+// read from an informer cache on every scrape, so it covers Secrets issued before a restart
+type certificateExpiryCollector struct {
+	secretLister corelisters.SecretLister
+}
+
+// registerCertificateExpiry reports the certificates of Secrets from secretLister, the lister
+// may cache any Secrets, only the ones managed by certificator are reported
+func registerCertificateExpiry(secretLister corelisters.SecretLister) {
+	metricsRegistry.MustRegister(&certificateExpiryCollector{secretLister: secretLister})
+}
+
+// Describe sends the descriptor of the expiry metric
+func (c *certificateExpiryCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- certificateNotAfterDesc
+}
+
+// Collect sends the expiry of every managed Secret holding a parsable certificate
+func (c *certificateExpiryCollector) Collect(ch chan<- prometheus.Metric) {
+	secrets, err := c.secretLister.List(labels.SelectorFromSet(labels.Set{certificator.ManagedByLabel: certificator.ManagedByValue}))
+	if err != nil {
+		log.Printf("Metrics - error occurred, detail: %v", err)
+		return
+	}
+	for _, secret := range secrets {
+		cert, err := certificator.LeafCertificate(secret.Data[corev1.TLSCertKey])
+		if err != nil {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(certificateNotAfterDesc, prometheus.GaugeValue,
+			float64(cert.NotAfter.Unix()), secret.Namespace, secret.Name)
+	}
+}
+
+// workqueueMetrics reports the depth, latency and retries of controller queues, labeled by queue name
+var workqueueMetrics = &workqueueMetricsProvider{
+	depth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace, Subsystem: "workqueue", Name: "depth",
+		Help: "Current depth of a controller queue.",
+	}, []string{"name"}),
+	adds: prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace, Subsystem: "workqueue", Name: "adds_total",
+		Help: "Number of adds handled by a controller queue.",
+	}, []string{"name"}),
+	latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace, Subsystem: "workqueue", Name: "queue_duration_seconds",
+		Help:    "How long an item stays in a controller queue before it is processed.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 10, 7),
+	}, []string{"name"}),
+	workDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace, Subsystem: "workqueue", Name: "work_duration_seconds",
+		Help:    "How long processing an item from a controller queue takes.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 10, 7),
+	}, []string{"name"}),
+	unfinished: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace, Subsystem: "workqueue", Name: "unfinished_work_seconds",
+		Help: "Seconds of work in progress which hasn't been observed by work_duration yet.",
+	}, []string{"name"}),
+	longestRunning: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace, Subsystem: "workqueue", Name: "longest_running_processor_seconds",
+		Help: "Seconds the longest running processor of a controller queue has been running.",
+	}, []string{"name"}),
+	retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace, Subsystem: "workqueue", Name: "retries_total",
+		Help: "Number of retries handled by a controller queue.",
+	}, []string{"name"}),
+}
+
+// workqueueMetricsProvider is the workqueue.MetricsProvider of controller queues
+type workqueueMetricsProvider struct {
+	depth          *prometheus.GaugeVec
+	adds           *prometheus.CounterVec
+	latency        *prometheus.HistogramVec
+	workDuration   *prometheus.HistogramVec
+	unfinished     *prometheus.GaugeVec
+	longestRunning *prometheus.GaugeVec
+	retries        *prometheus.CounterVec
+}
+
+func (p *workqueueMetricsProvider) register(registry prometheus.Registerer) {
+	registry.MustRegister(p.depth, p.adds, p.latency, p.workDuration, p.unfinished, p.longestRunning, p.retries)
+}
+
+func (p *workqueueMetricsProvider) NewDepthMetric(name string) workqueue.GaugeMetric {
+	return p.depth.WithLabelValues(name)
+}
+
+func (p *workqueueMetricsProvider) NewAddsMetric(name string) workqueue.CounterMetric {
+	return p.adds.WithLabelValues(name)
+}
+
+func (p *workqueueMetricsProvider) NewLatencyMetric(name string) workqueue.HistogramMetric {
+	return p.latency.WithLabelValues(name)
+}
+
+func (p *workqueueMetricsProvider) NewWorkDurationMetric(name string) workqueue.HistogramMetric {
+	return p.workDuration.WithLabelValues(name)
+}
+
+func (p *workqueueMetricsProvider) NewUnfinishedWorkSecondsMetric(name string) workqueue.SettableGaugeMetric {
+	return p.unfinished.WithLabelValues(name)
+}
+
+func (p *workqueueMetricsProvider) NewLongestRunningProcessorSecondsMetric(name string) workqueue.SettableGaugeMetric {
+	return p.longestRunning.WithLabelValues(name)
+}
+
+func (p *workqueueMetricsProvider) NewRetriesMetric(name string) workqueue.CounterMetric {
+	return p.retries.WithLabelValues(name)
+}
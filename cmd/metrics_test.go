@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/ealebed/admission-webhook-certificator/pkg/certificator"
+)
+
+func TestCertificateExpiryCollector(t *testing.T) {
+	ca := newTestCA(t, 24*time.Hour)
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "webhook-svc", Namespace: "webhook"}}
+	managed := newIssuedSecret(t, ca, service, "webhook-certs", 12*time.Hour)
+	foreign := newIssuedSecret(t, ca, service, "foreign-certs", 6*time.Hour)
+	delete(foreign.Labels, certificator.ManagedByLabel)
+	broken := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "broken", Namespace: "webhook", Labels: map[string]string{certificator.ManagedByLabel: certificator.ManagedByValue}},
+		Data:       map[string][]byte{corev1.TLSCertKey: []byte("not a certificate")},
+	}
+
+	secrets := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, secret := range []*corev1.Secret{managed, foreign, broken} {
+		_ = secrets.Add(secret)
+	}
+	collector := &certificateExpiryCollector{secretLister: corelisters.NewSecretLister(secrets)}
+
+	if count := testutil.CollectAndCount(collector); count != 1 {
+		t.Fatalf("Expected only the managed Secret reported, got %d metrics", count)
+	}
+	cert, err := certificator.LeafCertificate(managed.Data[corev1.TLSCertKey])
+	if err != nil {
+		t.Fatalf("leafCertificate() error = %v", err)
+	}
+	if got := testutil.ToFloat64(collector); got != float64(cert.NotAfter.Unix()) {
+		t.Errorf("Expected not after %d, got %f", cert.NotAfter.Unix(), got)
+	}
+}
+
+func TestIssuanceFailureReason(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{
+			name: "reports attached reason",
+			err:  certificator.Failure(certificator.ReasonSecret, errors.New("conflict")),
+			want: certificator.ReasonSecret,
+		},
+		{
+			name: "keeps the more specific reason",
+			err:  certificator.Failure(certificator.ReasonCSRWait, fmt.Errorf("retrieve: %w", certificator.Failure(certificator.ReasonDenied, errors.New("denied")))),
+			want: certificator.ReasonDenied,
+		},
+		{
+			name: "reports unknown without reason",
+			err:  errors.New("boom"),
+			want: certificator.ReasonUnknown,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := certificator.FailureReason(tt.err); got != tt.want {
+				t.Errorf("issuanceFailureReason() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWorkqueueMetrics(t *testing.T) {
+	c := newController("metrics-test", nil)
+	c.queue.Add("webhook/webhook-svc")
+	c.queue.Add("webhook/other-svc")
+
+	if depth := testutil.ToFloat64(workqueueMetrics.depth.WithLabelValues("metrics-test")); depth != 2 {
+		t.Errorf("Expected queue depth 2, got %f", depth)
+	}
+	key, _ := c.queue.Get()
+	c.queue.Done(key)
+	if depth := testutil.ToFloat64(workqueueMetrics.depth.WithLabelValues("metrics-test")); depth != 1 {
+		t.Errorf("Expected queue depth 1, got %f", depth)
+	}
+	c.queue.ShutDown()
+}
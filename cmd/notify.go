@@ -0,0 +1,345 @@
+/*
+Copyright © 2024 Yevhen Lebid ealebed@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// notification events
+	notificationIssued  = "Issued"
+	notificationRenewed = "Renewed"
+	notificationFailed  = "Failed"
+
+	// notifierTimeout bounds one delivery attempt
+	notifierTimeout = 10 * time.Second
+	// defaultNotifyRetryInterval is the first delay between delivery attempts, doubled after each
+	defaultNotifyRetryInterval = 2 * time.Second
+	defaultNotifyRetries       = 3
+)
+
+// NotifierOptions selects who is notified about the issuance results of a certificate
+type NotifierOptions struct {
+	webhooks      []string
+	slackWebhooks []string
+	commands      []string
+	retries       int
+	// allowedHosts are the hosts which URLs given per certificate may point to
+	allowedHosts []string
+	// retryInterval is the first delay between delivery attempts, defaultNotifyRetryInterval when zero
+	retryInterval time.Duration
+}
+
+// addNotifierFlags registers flags selecting who is notified about issuance results
+func addNotifierFlags(cmd *cobra.Command, options *NotifierOptions) {
+	cmd.Flags().StringSliceVar(&options.webhooks, "notify-webhook", nil,
+		"URL receiving the issuance result as JSON POST, may be repeated.")
+	cmd.Flags().StringSliceVar(&options.slackWebhooks, "notify-slack", nil,
+		"Slack-compatible incoming webhook URL, e.g. of Slack or Teams, receiving a message about the issuance result, may be repeated.")
+	cmd.Flags().StringArrayVar(&options.commands, "notify-exec", nil,
+		"Command, arguments separated by spaces, run with the issuance result as JSON on stdin, may be repeated.")
+	cmd.Flags().IntVar(&options.retries, "notify-retries", defaultNotifyRetries,
+		"How often a failed notification is retried, with exponential backoff.")
+}
+
+// addNotifyAllowedHostFlag registers the flag allowing URLs given per certificate, by controllers
+// taking them from objects which namespace users write
+func addNotifyAllowedHostFlag(cmd *cobra.Command, options *NotifierOptions) {
+	cmd.Flags().StringArrayVar(&options.allowedHosts, "notify-allowed-host", nil,
+		"Host which webhook and Slack URLs given per certificate may point to, may be repeated. Other URLs are rejected.")
+}
+
+// enabled reports whether anybody is notified
+func (o *NotifierOptions) enabled() bool {
+	return len(o.webhooks) > 0 || len(o.slackWebhooks) > 0 || len(o.commands) > 0
+}
+
+// args returns command line arguments reproducing the options
+func (o *NotifierOptions) args() []string {
+	var args []string
+	for _, webhook := range o.webhooks {
+		args = append(args, "--notify-webhook="+webhook)
+	}
+	for _, webhook := range o.slackWebhooks {
+		args = append(args, "--notify-slack="+webhook)
+	}
+	for _, command := range o.commands {
+		args = append(args, "--notify-exec="+command)
+	}
+	if o.enabled() && o.retries != defaultNotifyRetries {
+		args = append(args, "--notify-retries="+strconv.Itoa(o.retries))
+	}
+
+	return args
+}
+
+// withTargets returns the options notifying webhooks and slackWebhooks as well, given per certificate.
+// Their URLs have to point to allowedHosts, so namespace users can't make the controller send
+// requests to endpoints of their choice.
+func (o NotifierOptions) withTargets(webhooks, slackWebhooks []string) (NotifierOptions, error) {
+	for _, target := range slices.Concat(webhooks, slackWebhooks) {
+		u, err := url.Parse(target)
+		if err != nil {
+			return o, fmt.Errorf("notify URL %q: %w", target, err)
+		}
+		if (u.Scheme != "https" && u.Scheme != "http") ||
+			!slices.ContainsFunc(o.allowedHosts, func(host string) bool { return strings.EqualFold(host, u.Hostname()) }) {
+			return o, fmt.Errorf("notify URL %s is not an http(s) URL of a host allowed by --notify-allowed-host", u.Redacted())
+		}
+	}
+	o.webhooks = slices.Concat(o.webhooks, webhooks)
+	o.slackWebhooks = slices.Concat(o.slackWebhooks, slackWebhooks)
+
+	return o, nil
+}
+
+// notifiers returns a notifier for every configured target
+func (o *NotifierOptions) notifiers() []notifier {
+	client := &http.Client{Timeout: notifierTimeout}
+	var notifiers []notifier
+	for _, webhook := range o.webhooks {
+		notifiers = append(notifiers, &webhookNotifier{url: webhook, client: client})
+	}
+	for _, webhook := range o.slackWebhooks {
+		notifiers = append(notifiers, &slackNotifier{url: webhook, client: client})
+	}
+	for _, command := range o.commands {
+		if args := strings.Fields(command); len(args) > 0 {
+			notifiers = append(notifiers, &execNotifier{args: args})
+		}
+	}
+
+	return notifiers
+}
+
+// notification is the payload describing an issuance result, the fields of the run result report
+type notification struct {
+	Event           string     `json:"event"`
+	Namespace       string     `json:"namespace"`
+	Service         string     `json:"service"`
+	Secret          string     `json:"secret"`
+	Success         bool       `json:"success"`
+	DurationSeconds float64    `json:"durationSeconds"`
+	Finished        time.Time  `json:"finished"`
+	NotAfter        *time.Time `json:"notAfter,omitempty"`
+	Serial          string     `json:"serial,omitempty"`
+	Error           string     `json:"error,omitempty"`
+}
+
+// newNotification returns the notification of a run result, renewal tells a replaced certificate from a new one
+func newNotification(result *runResult, service string, renewal bool) *notification {
+	n := &notification{
+		Event:           notificationIssued,
+		Namespace:       result.namespace,
+		Service:         service,
+		Secret:          result.secret,
+		Success:         result.success,
+		DurationSeconds: result.duration.Seconds(),
+		Finished:        result.finished.UTC(),
+	}
+	switch {
+	case !result.success:
+		n.Event = notificationFailed
+		if result.err != nil {
+			n.Error = result.err.Error()
+		}
+	case renewal:
+		n.Event = notificationRenewed
+	}
+	if result.certificate != nil {
+		notAfter := result.certificate.NotAfter.UTC()
+		n.NotAfter = &notAfter
+		n.Serial = result.certificate.SerialNumber.Text(16)
+	}
+
+	return n
+}
+
+// notifyIssuance notifies about the outcome err of an issuance started at start
+func notifyIssuance(ctx context.Context, cs kubernetes.Interface, options *CreateAndSignCertOptions, renewal bool,
+	start time.Time, err error) {
+	result := &runResult{
+		namespace: options.namespace,
+		secret:    options.secret,
+		success:   err == nil,
+		err:       err,
+		duration:  time.Since(start),
+		finished:  time.Now(),
+	}
+	if result.success {
+		result.certificate = storedCertificate(ctx, cs, options)
+	}
+
+	notifyAll(ctx, &options.notify, newNotification(result, options.service, renewal))
+}
+
+// notifier delivers a notification to one target
+type notifier interface {
+	fmt.Stringer
+	notify(ctx context.Context, n *notification) error
+}
+
+// notifyAll delivers the notification to every notifier of options, retrying failed deliveries.
+// Failures are logged only, the certificate is issued or not regardless of them.
+func notifyAll(ctx context.Context, options *NotifierOptions, n *notification) {
+	interval := options.retryInterval
+	if interval <= 0 {
+		interval = defaultNotifyRetryInterval
+	}
+
+	for _, target := range options.notifiers() {
+		backoff := wait.Backoff{Duration: interval, Factor: 2, Jitter: 0.1, Steps: max(options.retries, 0) + 1}
+		var lastErr error
+		err := wait.ExponentialBackoffWithContext(ctx, backoff, func(ctx context.Context) (bool, error) {
+			if lastErr = target.notify(ctx, n); lastErr != nil {
+				log.Printf("Notifier %s - error occurred, detail: %v", target, lastErr)
+				return false, nil
+			}
+			return true, nil
+		})
+		if err != nil {
+			log.Printf("Notifier %s - error occurred, detail: %v, giving up after %d attempts", target, lastErr, backoff.Steps)
+			continue
+		}
+		log.Printf("Notifier %s, status: Notified %s of %s/%s", target, n.Event, n.Namespace, n.Secret)
+	}
+}
+
+// webhookNotifier POSTs the notification as JSON
+type webhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func (w *webhookNotifier) String() string { return "webhook " + urlHost(w.url) }
+
+func (w *webhookNotifier) notify(ctx context.Context, n *notification) error {
+	return postJSON(ctx, w.client, w.url, n)
+}
+
+// slackNotifier POSTs a message in the incoming webhook format of Slack, which Teams understands too
+type slackNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func (s *slackNotifier) String() string { return "slack " + urlHost(s.url) }
+
+func (s *slackNotifier) notify(ctx context.Context, n *notification) error {
+	return postJSON(ctx, s.client, s.url, map[string]string{"text": slackText(n)})
+}
+
+// slackText returns the message text of a notification
+func slackText(n *notification) string {
+	target := fmt.Sprintf("Secret %s/%s of Service %s", n.Namespace, n.Secret, n.Service)
+	if !n.Success {
+		return fmt.Sprintf(":x: Issuing the certificate of %s failed: %s", target, n.Error)
+	}
+
+	text := fmt.Sprintf(":white_check_mark: Certificate of %s %s", target, strings.ToLower(n.Event))
+	if n.NotAfter != nil {
+		text += fmt.Sprintf(", valid until %s, serial %s", n.NotAfter.Format(time.RFC3339), n.Serial)
+	}
+
+	return text
+}
+
+// execNotifier runs a local command with the notification as JSON on stdin
+type execNotifier struct {
+	args []string
+}
+
+func (e *execNotifier) String() string { return "exec " + e.args[0] }
+
+func (e *execNotifier) notify(ctx context.Context, n *notification) error {
+	payload, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, notifierTimeout)
+	defer cancel()
+	//nolint:gosec // commands are given on the certificator command line only, never by a certificate
+	cmd := exec.CommandContext(ctx, e.args[0], e.args[1:]...)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Env = append(os.Environ(),
+		"CERTIFICATOR_EVENT="+n.Event,
+		"CERTIFICATOR_NAMESPACE="+n.Namespace,
+		"CERTIFICATOR_SECRET="+n.Secret,
+	)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%w, output: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
+
+// urlHost returns the host of a notifier URL for logs, its path often carries a token
+func urlHost(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return "(invalid URL)"
+	}
+
+	return u.Host
+}
+
+// postJSON POSTs body as JSON and fails on a non-2xx response
+func postJSON(ctx context.Context, client *http.Client, target string, body interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := client.Do(request)
+	if err != nil {
+		// the URL isn't part of the error, its path often carries a token
+		if urlErr := (*url.Error)(nil); errors.As(err, &urlErr) {
+			return urlErr.Err
+		}
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("unexpected response status %s", response.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,183 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestNewNotification(t *testing.T) {
+	ca := newTestCA(t, 24*time.Hour)
+	secret := newIssuedSecret(t, ca, &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "webhook-svc", Namespace: "webhook"}},
+		"webhook-certs", 12*time.Hour)
+	cert := storedCertificate(context.TODO(), fake.NewClientset(secret), &CreateAndSignCertOptions{namespace: "webhook", secret: "webhook-certs"})
+
+	tests := []struct {
+		name      string
+		result    *runResult
+		renewal   bool
+		wantEvent string
+		wantError string
+	}{
+		{
+			name:      "issued",
+			result:    &runResult{namespace: "webhook", secret: "webhook-certs", success: true, certificate: cert},
+			wantEvent: notificationIssued,
+		},
+		{
+			name:      "renewed",
+			result:    &runResult{namespace: "webhook", secret: "webhook-certs", success: true, certificate: cert},
+			renewal:   true,
+			wantEvent: notificationRenewed,
+		},
+		{
+			name:      "failed",
+			result:    &runResult{namespace: "webhook", secret: "webhook-certs", err: errors.New("signer failed")},
+			renewal:   true,
+			wantEvent: notificationFailed,
+			wantError: "signer failed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := newNotification(tt.result, "webhook-svc", tt.renewal)
+			if n.Event != tt.wantEvent || n.Error != tt.wantError || n.Service != "webhook-svc" {
+				t.Errorf("Unexpected notification %+v", n)
+			}
+			if tt.result.success && (n.NotAfter == nil || n.Serial != cert.SerialNumber.Text(16)) {
+				t.Errorf("Expected certificate expiry and serial, got %+v", n)
+			}
+		})
+	}
+}
+
+func TestNotifyAll(t *testing.T) {
+	n := &notification{
+		Event: notificationRenewed, Namespace: "webhook", Service: "webhook-svc", Secret: "webhook-certs",
+		Success: true, DurationSeconds: 1.5, Finished: time.Unix(1700000000, 0).UTC(),
+	}
+
+	var mu sync.Mutex
+	requests := map[string][][]byte{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		requests[r.URL.Path] = append(requests[r.URL.Path], body)
+		// the first delivery to /flaky fails and is retried
+		if r.URL.Path == "/flaky" && len(requests[r.URL.Path]) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	// the command stores its stdin and environment in output
+	output := filepath.Join(t.TempDir(), "notification.json")
+	script := filepath.Join(t.TempDir(), "notify.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\ncat > $1\necho \"$CERTIFICATOR_EVENT\" >> $1\n"), 0o700); err != nil {
+		t.Fatalf("os.WriteFile() error = %v", err)
+	}
+	options := &NotifierOptions{
+		webhooks:      []string{server.URL + "/webhook", server.URL + "/flaky"},
+		slackWebhooks: []string{server.URL + "/slack"},
+		commands:      []string{script + " " + output},
+		retries:       2,
+		retryInterval: time.Millisecond,
+	}
+
+	notifyAll(context.TODO(), options, n)
+
+	for _, path := range []string{"/webhook", "/flaky"} {
+		bodies := requests[path]
+		if len(bodies) == 0 {
+			t.Fatalf("Expected notification POSTed to %s", path)
+		}
+		var got notification
+		if err := json.Unmarshal(bodies[len(bodies)-1], &got); err != nil {
+			t.Fatalf("json.Unmarshal() error = %v", err)
+		}
+		if got != *n {
+			t.Errorf("Expected %s to receive %+v, got %+v", path, *n, got)
+		}
+	}
+	if len(requests["/flaky"]) != 2 {
+		t.Errorf("Expected failed delivery retried once, got %d requests", len(requests["/flaky"]))
+	}
+
+	var message map[string]string
+	if len(requests["/slack"]) != 1 || json.Unmarshal(requests["/slack"][0], &message) != nil ||
+		!strings.Contains(message["text"], "webhook/webhook-certs") || !strings.Contains(message["text"], "renewed") {
+		t.Errorf("Unexpected Slack message %q", requests["/slack"])
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("os.ReadFile() error = %v", err)
+	}
+	if !strings.Contains(string(data), `"event":"Renewed"`) || !strings.HasSuffix(string(data), "Renewed\n") {
+		t.Errorf("Expected command to receive the notification on stdin and in its environment, got %q", data)
+	}
+}
+
+func TestNotifierOptionsArgs(t *testing.T) {
+	options := NotifierOptions{retries: defaultNotifyRetries, allowedHosts: []string{"hooks.example.io", "hooks.slack.com"}}
+	if args := options.args(); len(args) != 0 {
+		t.Errorf("Expected no args without notifiers, got %v", args)
+	}
+
+	options, err := options.withTargets([]string{"https://hooks.example.io/certs"}, []string{"https://hooks.slack.com/services/T/B/X"})
+	if err != nil {
+		t.Fatalf("withTargets() error = %v", err)
+	}
+	options.retries = 5
+	want := "--notify-webhook=https://hooks.example.io/certs --notify-slack=https://hooks.slack.com/services/T/B/X --notify-retries=5"
+	if got := strings.Join(options.args(), " "); got != want {
+		t.Errorf("Expected args '%s', got '%s'", want, got)
+	}
+}
+
+func TestNotifierOptionsWithTargets(t *testing.T) {
+	options := NotifierOptions{webhooks: []string{"http://10.0.0.1/flag"}, allowedHosts: []string{"hooks.slack.com"}}
+
+	tests := []struct {
+		name    string
+		url     string
+		wantErr bool
+	}{
+		{name: "allowed host", url: "https://hooks.slack.com/services/T/B/X"},
+		{name: "allowed host in other case", url: "https://Hooks.Slack.com/services/T/B/X"},
+		{name: "cluster-internal address", url: "http://10.0.0.1:8080/", wantErr: true},
+		{name: "cluster-internal Service", url: "http://kubernetes.default.svc/api", wantErr: true},
+		{name: "allowed host as user info", url: "https://hooks.slack.com@169.254.169.254/", wantErr: true},
+		{name: "other scheme", url: "file://hooks.slack.com/etc/passwd", wantErr: true},
+		{name: "invalid URL", url: "://hooks.slack.com", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := options.withTargets(nil, []string{tt.url})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("withTargets() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (len(got.webhooks) != 1 || len(got.slackWebhooks) != 1) {
+				t.Errorf("Expected flag and per certificate targets, got %v and %v", got.webhooks, got.slackWebhooks)
+			}
+		})
+	}
+}
@@ -0,0 +1,313 @@
+/*
+Copyright © 2024 Yevhen Lebid ealebed@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/ealebed/admission-webhook-certificator/pkg/certificator"
+)
+
+const (
+	// secretNameAnnotation opts a Service in, its value names the Secret receiving the certificate
+	secretNameAnnotation = "certificator.ealebed.io/secret-name"
+	// annotationPrefix is shared by all certificator annotations of a Service
+	annotationPrefix = "certificator.ealebed.io/"
+	// specHashLabel records the Service and annotations a Secret was issued for
+	specHashLabel  = "certificator.ealebed.io/spec-hash"
+	specHashLength = 16
+	// notifyWebhookAnnotation and notifySlackAnnotation list comma-separated URLs notified about the certificate
+	notifyWebhookAnnotation = "certificator.ealebed.io/notify-webhook"
+	notifySlackAnnotation   = "certificator.ealebed.io/notify-slack"
+)
+
+// OperatorOptions represents options for operator command
+type OperatorOptions struct {
+	controller    ControllerOptions
+	certify       CreateAndSignCertOptions
+	deleteSecrets bool
+}
+
+// NewOperatorCmd returns new operator command
+func NewOperatorCmd() *cobra.Command {
+	options := OperatorOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "operator",
+		Short: "Run a controller which provisions certificates for Services annotated with " + secretNameAnnotation + ".",
+		Long: "This command watches Services annotated with " + secretNameAnnotation + " and runs the certify\n" +
+			"pipeline for each of them. Certificates are re-issued when the Service is renamed, its certificator\n" +
+			"annotations change or a third of the certificate lifetime remains. Secrets of deleted or opted-out\n" +
+			"Services are released, or deleted with --delete-secrets.",
+		Example: "operator [--leader-elect --signer-name=certificator.ealebed.io/webhook-serving --delete-secrets]",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := options.certify.validate(); err != nil {
+				return err
+			}
+			return runOperator(&options)
+		},
+	}
+
+	addControllerFlags(cmd, &options.controller, "certificator-operator")
+	cmd.Flags().DurationVar(&options.certify.duration, "duration", certificator.DefaultDuration,
+		"Requested certificate lifetime, at least 10m. Signers may cap it with their own maximum.")
+	cmd.Flags().StringVar(&options.certify.approval, "approval", certificator.ApprovalSelf,
+		"Who approves the CSRs, one of: self, external. External mode waits for a human or an approver controller.")
+	cmd.Flags().DurationVar(&options.certify.timeout, "timeout", certificator.DefaultTimeout,
+		"How long to wait for a CSR to be approved and issued.")
+	cmd.Flags().StringVar(&options.certify.signerName, "signer-name", certificator.KubeAPIServerClientSignerName,
+		"Signer of the CSRs, e.g. "+webhookServingSignerName+" served by certificator signer.")
+	addIssuerFlags(cmd, &options.certify.issuer)
+	cmd.Flags().BoolVar(&options.deleteSecrets, "delete-secrets", false,
+		"Delete the Secret of a deleted or opted-out Service instead of releasing it.")
+	addNotifierFlags(cmd, &options.certify.notify)
+	addNotifyAllowedHostFlag(cmd, &options.certify.notify)
+
+	return cmd
+}
+
+func runOperator(options *OperatorOptions) error {
+	cs, err := initK8sClient(options.controller.kubeconfig)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	factory := informers.NewSharedInformerFactory(cs, options.controller.resync)
+	managedFactory := newManagedInformerFactory(cs, &options.controller)
+	o := &operator{
+		client:        cs,
+		recorder:      newEventRecorder(cs),
+		serviceLister: factory.Core().V1().Services().Lister(),
+		secretLister:  managedFactory.Core().V1().Secrets().Lister(),
+		template:      options.certify,
+		deleteSecrets: options.deleteSecrets,
+	}
+	registerCertificateExpiry(o.secretLister)
+	c, err := o.controller(factory, managedFactory)
+	if err != nil {
+		return err
+	}
+
+	return runLeaderElected(ctx, cs, &options.controller, []*controller{c}, func(ctx context.Context) {
+		factory.Start(ctx.Done())
+		managedFactory.Start(ctx.Done())
+		runControllers(ctx, options.controller.workers, c)
+	})
+}
+
+// operator provisions certificates for annotated Services
+type operator struct {
+	client        kubernetes.Interface
+	recorder      record.EventRecorder
+	serviceLister corelisters.ServiceLister
+	secretLister  corelisters.SecretLister
+	template      CreateAndSignCertOptions
+	deleteSecrets bool
+}
+
+// controller returns a controller keyed by Service, which also follows the managed Secrets
+func (o *operator) controller(factory, managedFactory informers.SharedInformerFactory) (*controller, error) {
+	c := newController("operator", o.sync)
+	// all Services are watched, so removing the annotation releases the Secret
+	err := c.watch(factory.Core().V1().Services().Informer(), func(obj interface{}) []string {
+		if service, ok := obj.(*corev1.Service); ok {
+			return []string{service.Namespace + "/" + service.Name}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = c.watch(managedFactory.Core().V1().Secrets().Informer(), func(obj interface{}) []string {
+		secret, ok := obj.(*corev1.Secret)
+		if !ok || secret.Labels[certificator.ServiceLabel] == "" || secret.Labels[certificator.NamespaceLabel] != secret.Namespace {
+			return nil
+		}
+		return []string{secret.Namespace + "/" + secret.Labels[certificator.ServiceLabel]}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (o *operator) sync(ctx context.Context, key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+	service, err := o.serviceLister.Services(namespace).Get(name)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	secretName := ""
+	if service != nil {
+		secretName = service.Annotations[secretNameAnnotation]
+	}
+
+	// Secrets issued for this Service under another name, or for a deleted or opted-out Service
+	issued, err := o.secretLister.Secrets(namespace).List(labels.SelectorFromSet(labels.Set{
+		certificator.ServiceLabel:   name,
+		certificator.NamespaceLabel: namespace,
+	}))
+	if err != nil {
+		return err
+	}
+	for _, secret := range issued {
+		if secret.Name != secretName {
+			if err = o.cleanup(ctx, secret); err != nil {
+				return err
+			}
+		}
+	}
+	if secretName == "" {
+		return nil
+	}
+
+	hash := specHash(service)
+	// the Secret is read live, the lister holds managed Secrets only and would overwrite others
+	existing, err := o.client.CoreV1().Secrets(namespace).Get(ctx, secretName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		existing = nil
+	} else if err != nil {
+		return err
+	}
+	if existing != nil && existing.Labels[certificator.ManagedByLabel] != certificator.ManagedByValue {
+		log.Printf("Service %s, status: Secret %s is not managed by certificator, skipping", key, secretName)
+		o.recorder.Eventf(service, corev1.EventTypeWarning, eventReasonSecretConflict,
+			"Secret %s exists and is not managed by certificator, it isn't overwritten", secretName)
+		return nil
+	}
+	if existing != nil && existing.Labels[certificator.ServiceLabel] == name && existing.Labels[specHashLabel] == hash {
+		if !dueForRenewal(existing.Data[corev1.TLSCertKey], time.Now()) {
+			return nil
+		}
+		o.recorder.Event(existing, corev1.EventTypeNormal, eventReasonNearExpiry, "Less than a third of the certificate lifetime remains, renewing")
+	}
+
+	secretLabels, err := certificator.OwnerLabels(name, namespace, secretName)
+	if err != nil {
+		log.Printf("Service %s, status: Invalid, %v", key, err)
+		return nil
+	}
+	secretLabels[specHashLabel] = hash
+	options := o.template
+	options.service, options.namespace, options.secret = name, namespace, secretName
+	options.notify, err = o.template.notify.withTargets(annotationList(service, notifyWebhookAnnotation),
+		annotationList(service, notifySlackAnnotation))
+	if err != nil {
+		log.Printf("Service %s, status: Invalid, %v", key, err)
+		o.recorder.Eventf(service, corev1.EventTypeWarning, eventReasonInvalidNotifier, "Not issuing certificate: %v", err)
+		return nil
+	}
+
+	log.Printf("Service %s, status: Issuing certificate into Secret %s", key, secretName)
+	if err = issueCertificate(ctx, o.client, o.recorder, &options, secretLabels); err != nil {
+		return err
+	}
+	log.Printf("Service %s, status: Certificate issued", key)
+
+	return nil
+}
+
+// cleanup deletes a Secret no longer used by its Service, or releases it by removing the
+// managed-by label, so certificator stops managing it
+func (o *operator) cleanup(ctx context.Context, secret *corev1.Secret) error {
+	if o.deleteSecrets {
+		log.Printf("Secret %s/%s, status: No longer used by its Service, deleting", secret.Namespace, secret.Name)
+		err := o.client.CoreV1().Secrets(secret.Namespace).Delete(ctx, secret.Name, metav1.DeleteOptions{
+			Preconditions: &metav1.Preconditions{UID: &secret.UID},
+		})
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	log.Printf("Secret %s/%s, status: No longer used by its Service, releasing", secret.Namespace, secret.Name)
+	secret = secret.DeepCopy()
+	delete(secret.Labels, certificator.ManagedByLabel)
+	_, err := o.client.CoreV1().Secrets(secret.Namespace).Update(ctx, secret, metav1.UpdateOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+
+	return err
+}
+
+// specHash identifies the Service name and certificator annotations a certificate is issued for
+func specHash(service *corev1.Service) string {
+	var keys []string
+	for key := range service.Annotations {
+		// notifications don't change the certificate
+		if strings.HasPrefix(key, annotationPrefix) && key != notifyWebhookAnnotation && key != notifySlackAnnotation {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	h := sha256.New()
+	h.Write([]byte(service.Namespace + "/" + service.Name + "\n"))
+	for _, key := range keys {
+		h.Write([]byte(key + "=" + service.Annotations[key] + "\n"))
+	}
+
+	return hex.EncodeToString(h.Sum(nil))[:specHashLength]
+}
+
+// annotationList returns the comma-separated values of an annotation of the Service
+func annotationList(service *corev1.Service, key string) []string {
+	var values []string
+	for _, value := range strings.Split(service.Annotations[key], ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+
+	return values
+}
+
+// dueForRenewal reports whether less than a third of the certificate lifetime remains
+func dueForRenewal(certPEM []byte, now time.Time) bool {
+	cert, err := certificator.LeafCertificate(certPEM)
+	if err != nil {
+		return true
+	}
+
+	return !now.Before(certificator.RenewalTime(cert, 0))
+}
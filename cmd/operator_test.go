@@ -0,0 +1,258 @@
+package cmd
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	certv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/ealebed/admission-webhook-certificator/pkg/certificator"
+	"github.com/ealebed/admission-webhook-certificator/pkg/issuer"
+)
+
+// newIssuedSecret returns a managed Secret holding a certificate for the Service, signed by ca
+func newIssuedSecret(t *testing.T, ca *issuer.CertificateAuthority, service *corev1.Service, secretName string, validity time.Duration) *corev1.Secret {
+	t.Helper()
+
+	requestPEM, keyPEM, _, err := certificator.GenerateRequest(service.Name, service.Namespace, nil)
+	if err != nil {
+		t.Fatalf("GenerateRequest() error = %v", err)
+	}
+	block, _ := pem.Decode(requestPEM)
+	request, _ := x509.ParseCertificateRequest(block.Bytes)
+	certPEM, err := ca.Sign(request, issuer.ServerUsages, validity)
+	if err != nil {
+		t.Fatalf("sign() error = %v", err)
+	}
+	labels, _ := certificator.OwnerLabels(service.Name, service.Namespace, secretName)
+	labels[specHashLabel] = specHash(service)
+
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: secretName, Namespace: service.Namespace, UID: types.UID("uid-" + secretName), Labels: labels},
+		Data:       map[string][]byte{corev1.TLSCertKey: certPEM, corev1.TLSPrivateKeyKey: keyPEM},
+	}
+}
+
+// signCSRsOnCreate makes the fake clientset issue CSRs with ca as they are created, for an hour
+func signCSRsOnCreate(cs *fake.Clientset, ca *issuer.CertificateAuthority) {
+	cs.PrependReactor("create", "certificatesigningrequests", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		csr := action.(k8stesting.CreateAction).GetObject().(*certv1.CertificateSigningRequest)
+		block, _ := pem.Decode(csr.Spec.Request)
+		request, err := x509.ParseCertificateRequest(block.Bytes)
+		if err != nil {
+			return true, nil, err
+		}
+		certPEM, err := ca.Sign(request, csr.Spec.Usages, time.Hour)
+		if err != nil {
+			return true, nil, err
+		}
+		csr.Status.Certificate = append(certPEM, ca.CertificatePEM...)
+		return false, nil, nil
+	})
+}
+
+func TestOperatorSync(t *testing.T) {
+	ca := newTestCA(t, 24*time.Hour)
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{
+		Name: "webhook-svc", Namespace: "webhook", Annotations: map[string]string{secretNameAnnotation: "webhook-certs"},
+	}}
+	renamed := service.DeepCopy()
+	renamed.Annotations[secretNameAnnotation] = "renamed-certs"
+	internalNotifier := service.DeepCopy()
+	internalNotifier.Annotations[notifyWebhookAnnotation] = "http://10.0.0.1:8080/"
+	upToDate := newIssuedSecret(t, ca, service, "webhook-certs", 12*time.Hour)
+	expiring := newIssuedSecret(t, ca, service, "webhook-certs", time.Minute)
+	unmanaged := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "webhook-certs", Namespace: "webhook"},
+		Data:       map[string][]byte{corev1.TLSCertKey: []byte("other app")},
+	}
+
+	tests := []struct {
+		name          string
+		service       *corev1.Service
+		secret        *corev1.Secret
+		deleteSecrets bool
+		wantIssued    bool
+		wantEvents    []string
+		check         func(t *testing.T, secret *corev1.Secret, err error)
+	}{
+		{
+			name:       "issues certificate for annotated Service",
+			service:    service,
+			wantIssued: true,
+			wantEvents: []string{certificator.EventReasonCSRCreated, certificator.EventReasonCSRApproved,
+				certificator.EventReasonCertificateIssued, certificator.EventReasonSecretUpdated},
+		},
+		{
+			name:    "skips up to date Secret",
+			service: service,
+			secret:  upToDate,
+		},
+		{
+			name:       "renews expiring certificate",
+			service:    service,
+			secret:     expiring,
+			wantIssued: true,
+			wantEvents: []string{eventReasonNearExpiry, certificator.EventReasonCSRCreated, certificator.EventReasonCSRApproved,
+				certificator.EventReasonCertificateIssued, certificator.EventReasonSecretUpdated},
+		},
+		{
+			name:       "refuses to overwrite a Secret not managed by certificator",
+			service:    service,
+			secret:     unmanaged,
+			wantEvents: []string{eventReasonSecretConflict},
+			check: func(t *testing.T, secret *corev1.Secret, err error) {
+				if err != nil || string(secret.Data[corev1.TLSCertKey]) != "other app" {
+					t.Errorf("Expected Secret kept, got %v, err = %v", secret, err)
+				}
+			},
+		},
+		{
+			name:       "refuses a notify URL of a host which isn't allowed",
+			service:    internalNotifier,
+			wantEvents: []string{eventReasonInvalidNotifier},
+		},
+		{
+			name:       "releases Secret when the annotation names another Secret",
+			service:    renamed,
+			secret:     upToDate,
+			wantIssued: true,
+			check: func(t *testing.T, secret *corev1.Secret, err error) {
+				if err != nil || secret.Labels[certificator.ManagedByLabel] != "" {
+					t.Errorf("Expected Secret released, got %v, err = %v", secret, err)
+				}
+			},
+		},
+		{
+			name:   "releases Secret of deleted Service",
+			secret: upToDate,
+			check: func(t *testing.T, secret *corev1.Secret, err error) {
+				if err != nil || secret.Labels[certificator.ManagedByLabel] != "" {
+					t.Errorf("Expected Secret released, got %v, err = %v", secret, err)
+				}
+			},
+		},
+		{
+			name:          "deletes Secret of deleted Service",
+			secret:        upToDate,
+			deleteSecrets: true,
+			check: func(t *testing.T, _ *corev1.Secret, err error) {
+				if !apierrors.IsNotFound(err) {
+					t.Errorf("Expected Secret deleted, got err = %v", err)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := fake.NewClientset()
+			services := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+			secrets := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+			if tt.service != nil {
+				_ = services.Add(tt.service)
+				_ = cs.Tracker().Add(tt.service)
+			}
+			if tt.secret != nil {
+				// the lister holds managed Secrets only, like the informer of the operator
+				if tt.secret.Labels[certificator.ManagedByLabel] == certificator.ManagedByValue {
+					_ = secrets.Add(tt.secret)
+				}
+				_ = cs.Tracker().Add(tt.secret.DeepCopy())
+			}
+			signCSRsOnCreate(cs, ca)
+
+			recorder := record.NewFakeRecorder(100)
+			o := &operator{
+				client:        cs,
+				recorder:      recorder,
+				serviceLister: corelisters.NewServiceLister(services),
+				secretLister:  corelisters.NewSecretLister(secrets),
+				template: CreateAndSignCertOptions{
+					duration: time.Hour, approval: certificator.ApprovalSelf, timeout: time.Second, signerName: webhookServingSignerName,
+				},
+				deleteSecrets: tt.deleteSecrets,
+			}
+			if err := o.sync(context.TODO(), "webhook/webhook-svc"); err != nil {
+				t.Fatalf("sync() error = %v", err)
+			}
+
+			issued := false
+			for _, action := range cs.Actions() {
+				if action.Matches("create", "certificatesigningrequests") {
+					issued = true
+				}
+			}
+			if issued != tt.wantIssued {
+				t.Errorf("Expected issued %v, got %v", tt.wantIssued, issued)
+			}
+			close(recorder.Events)
+			var reasons []string
+			for event := range recorder.Events {
+				reasons = append(reasons, strings.Fields(event)[1])
+			}
+			if tt.wantEvents != nil && !slices.Equal(reasons, tt.wantEvents) {
+				t.Errorf("Expected events %v, got %v", tt.wantEvents, reasons)
+			}
+			if tt.wantIssued {
+				name := tt.service.Annotations[secretNameAnnotation]
+				secret, err := cs.CoreV1().Secrets("webhook").Get(context.TODO(), name, metav1.GetOptions{})
+				if err != nil {
+					t.Fatalf("Expected Secret %s, got err = %v", name, err)
+				}
+				if secret.Labels[specHashLabel] != specHash(tt.service) || secret.Labels[certificator.ServiceLabel] != "webhook-svc" {
+					t.Errorf("Expected Secret labeled for the Service, got %v", secret.Labels)
+				}
+			}
+			if tt.check != nil {
+				secret, err := cs.CoreV1().Secrets("webhook").Get(context.TODO(), tt.secret.Name, metav1.GetOptions{})
+				tt.check(t, secret, err)
+			}
+		})
+	}
+}
+
+func TestSpecHash(t *testing.T) {
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{
+		Name: "webhook-svc", Namespace: "webhook", Annotations: map[string]string{secretNameAnnotation: "webhook-certs"},
+	}}
+	unrelated := service.DeepCopy()
+	unrelated.Annotations["example.io/owner"] = "team"
+	changed := service.DeepCopy()
+	changed.Annotations[secretNameAnnotation] = "other-certs"
+	renamed := service.DeepCopy()
+	renamed.Name = "other-svc"
+	notified := service.DeepCopy()
+	notified.Annotations[notifyWebhookAnnotation] = "https://hooks.example.io/certs"
+
+	if specHash(unrelated) != specHash(service) {
+		t.Error("Expected unrelated annotations not to change the spec hash")
+	}
+	if specHash(notified) != specHash(service) {
+		t.Error("Expected notifier annotations not to change the spec hash")
+	}
+	if specHash(changed) == specHash(service) {
+		t.Error("Expected certificator annotations to change the spec hash")
+	}
+	if specHash(renamed) == specHash(service) {
+		t.Error("Expected the Service name to change the spec hash")
+	}
+	if len(specHash(service)) != specHashLength {
+		t.Errorf("Expected spec hash of %d characters, got %q", specHashLength, specHash(service))
+	}
+}
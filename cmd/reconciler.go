@@ -0,0 +1,573 @@
+/*
+Copyright © 2024 Yevhen Lebid ealebed@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/ealebed/admission-webhook-certificator/pkg/certificator"
+	"github.com/ealebed/admission-webhook-certificator/pkg/issuer"
+)
+
+// webhookCertificateSecretIndex indexes WebhookCertificates by the namespace/name of their Secret
+const webhookCertificateSecretIndex = "secret"
+
+// ReconcilerOptions represents options for reconciler command
+type ReconcilerOptions struct {
+	controller ControllerOptions
+	certify    CreateAndSignCertOptions
+	caSecrets  []string
+	dnsDomains []string
+	ipRanges   []net.IPNet
+	signers    []string
+}
+
+// NewReconcilerCmd returns new reconciler command
+func NewReconcilerCmd() *cobra.Command {
+	options := ReconcilerOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "reconciler",
+		Short: "Run a controller which issues certificates declared by WebhookCertificate objects.",
+		Long: "This command watches WebhookCertificate objects and keeps the certificate they declare issued\n" +
+			"in their Secret, using the certify pipeline. Certificates are re-issued when the spec changes, the\n" +
+			"Secret is lost or renewBefore is reached, and their CA is written into the listed webhook configurations.",
+		Example: "reconciler [--leader-elect --approval=external]",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := certificator.ValidateApproval(options.certify.approval); err != nil {
+				return err
+			}
+			return runReconciler(&options)
+		},
+	}
+
+	addControllerFlags(cmd, &options.controller, "certificator-reconciler")
+	cmd.Flags().StringVar(&options.certify.approval, "approval", certificator.ApprovalSelf,
+		"Who approves the CSRs, one of: self, external. External mode waits for a human or an approver controller.")
+	cmd.Flags().DurationVar(&options.certify.timeout, "timeout", certificator.DefaultTimeout,
+		"How long to wait for a CSR to be approved and issued.")
+	cmd.Flags().StringVar(&options.certify.signerName, "signer-name", certificator.KubeAPIServerClientSignerName,
+		"Signer of the CSRs of WebhookCertificates without issuerRef.")
+	cmd.Flags().StringArrayVar(&options.caSecrets, "allowed-ca-secret", nil,
+		"CA Secret, as namespace/name, which CA issuerRefs of WebhookCertificates in any namespace may name, may be repeated. "+
+			"Otherwise CA issuerRefs name Secrets in the namespace of the WebhookCertificate only.")
+	cmd.Flags().StringArrayVar(&options.dnsDomains, "allowed-dns-domain", nil,
+		"DNS domain whose names WebhookCertificates in any namespace may request, e.g. example.io, may be repeated. "+
+			"Otherwise dnsNames have to be names of Services in the namespace of the WebhookCertificate.")
+	cmd.Flags().IPNetSliceVar(&options.ipRanges, "allowed-ip-range", nil,
+		"IP range, as CIDR, whose addresses WebhookCertificates in any namespace may request, may be repeated. "+
+			"Otherwise ipAddresses have to be cluster IPs of the Service of the WebhookCertificate.")
+	cmd.Flags().StringArrayVar(&options.signers, "allowed-signer", nil,
+		"Signer which Signer issuerRefs of WebhookCertificates may name besides --signer-name, may be repeated.")
+	addNotifierFlags(cmd, &options.certify.notify)
+	addNotifyAllowedHostFlag(cmd, &options.certify.notify)
+
+	return cmd
+}
+
+func runReconciler(options *ReconcilerOptions) error {
+	cs, err := initK8sClient(options.controller.kubeconfig)
+	if err != nil {
+		return err
+	}
+	dyn, err := initDynamicClient(options.controller.kubeconfig)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	dynamicFactory := dynamicinformer.NewDynamicSharedInformerFactory(dyn, options.controller.resync)
+	managedFactory := newManagedInformerFactory(cs, &options.controller)
+	r := &reconciler{
+		client:       cs,
+		recorder:     newEventRecorder(cs),
+		dynamic:      dyn,
+		informer:     dynamicFactory.ForResource(webhookCertificateResource).Informer(),
+		secretLister: managedFactory.Core().V1().Secrets().Lister(),
+		template:     options.certify,
+		caSecrets:    options.caSecrets,
+		dnsDomains:   options.dnsDomains,
+		ipRanges:     options.ipRanges,
+		signers:      append([]string{options.certify.signerName}, options.signers...),
+	}
+	registerCertificateExpiry(r.secretLister)
+	c, err := r.controller(managedFactory)
+	if err != nil {
+		return err
+	}
+
+	return runLeaderElected(ctx, cs, &options.controller, []*controller{c}, func(ctx context.Context) {
+		dynamicFactory.Start(ctx.Done())
+		managedFactory.Start(ctx.Done())
+		runControllers(ctx, options.controller.workers, c)
+	})
+}
+
+// reconciler keeps the certificates declared by WebhookCertificates issued
+type reconciler struct {
+	client       kubernetes.Interface
+	recorder     record.EventRecorder
+	dynamic      dynamic.Interface
+	informer     cache.SharedIndexInformer
+	secretLister corelisters.SecretLister
+	template     CreateAndSignCertOptions
+	// caSecrets are the namespace/name of CA Secrets shared with WebhookCertificates of all namespaces
+	caSecrets []string
+	// dnsDomains are the DNS domains whose names WebhookCertificates of all namespaces may request
+	dnsDomains []string
+	// ipRanges hold the IP addresses WebhookCertificates of all namespaces may request
+	ipRanges []net.IPNet
+	// signers are the signers Signer issuerRefs may name, the reconciler approves their CSRs
+	signers []string
+	// enqueueAfter schedules the renewal of a WebhookCertificate
+	enqueueAfter func(key string, after time.Duration)
+}
+
+// controller returns a controller keyed by WebhookCertificate, which also follows the managed Secrets
+func (r *reconciler) controller(managedFactory informers.SharedInformerFactory) (*controller, error) {
+	c := newController("reconciler", r.sync)
+	r.enqueueAfter = c.queue.AddAfter
+
+	if err := r.informer.AddIndexers(cache.Indexers{webhookCertificateSecretIndex: webhookCertificateSecretIndexFunc}); err != nil {
+		return nil, err
+	}
+	err := c.watch(r.informer, func(obj interface{}) []string {
+		if key, err := cache.MetaNamespaceKeyFunc(obj); err == nil {
+			return []string{key}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = c.watch(managedFactory.Core().V1().Secrets().Informer(), func(obj interface{}) []string {
+		secret, ok := obj.(*corev1.Secret)
+		if !ok {
+			return nil
+		}
+		objects, err := r.informer.GetIndexer().ByIndex(webhookCertificateSecretIndex, secret.Namespace+"/"+secret.Name)
+		if err != nil {
+			return nil
+		}
+		var keys []string
+		for _, object := range objects {
+			if key, err := cache.MetaNamespaceKeyFunc(object); err == nil {
+				keys = append(keys, key)
+			}
+		}
+		return keys
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// webhookCertificateSecretIndexFunc indexes WebhookCertificates by the namespace/name of their Secret
+func webhookCertificateSecretIndexFunc(obj interface{}) ([]string, error) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, nil
+	}
+	wc, err := toWebhookCertificate(u)
+	if err != nil || wc.Spec.SecretName == "" {
+		return nil, nil
+	}
+
+	return []string{wc.secretNamespace() + "/" + wc.Spec.SecretName}, nil
+}
+
+func (r *reconciler) sync(ctx context.Context, key string) error {
+	obj, exists, err := r.informer.GetIndexer().GetByKey(key)
+	if err != nil || !exists {
+		return err
+	}
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return fmt.Errorf("unexpected object %T for %s", obj, key)
+	}
+	wc, err := toWebhookCertificate(u)
+	if err != nil {
+		return err
+	}
+	status := *wc.Status.DeepCopy()
+
+	options, invalid := r.certifyOptions(wc)
+	if invalid == nil {
+		if invalid, err = r.authorize(ctx, wc, options); err != nil {
+			return err
+		}
+	}
+	if invalid != nil {
+		// the spec has to change first, retrying doesn't help
+		log.Printf("WebhookCertificate %s, status: Invalid, %v", key, invalid)
+		setWebhookCertificateConditions(&status, wc.Generation, "Invalid", invalid.Error(), conditionFailed)
+		_, err = r.updateStatus(ctx, u, &status)
+		return err
+	}
+
+	hash := webhookCertificateSpecHash(wc)
+	secret, err := r.secretLister.Secrets(options.namespace).Get(options.secret)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	if secret != nil && secret.Labels[specHashLabel] == hash {
+		if cert, parseErr := certificator.LeafCertificate(secret.Data[corev1.TLSCertKey]); parseErr == nil {
+			if renewAt := certificator.RenewalTime(cert, wc.renewBefore()); time.Now().Before(renewAt) {
+				r.enqueueAfter(key, time.Until(renewAt))
+				setWebhookCertificateIssued(&status, wc.Generation, cert)
+				_, err = r.updateStatus(ctx, u, &status)
+				return err
+			}
+			r.recorder.Eventf(secret, corev1.EventTypeNormal, eventReasonNearExpiry, "Certificate expires at %s, renewing",
+				cert.NotAfter.UTC().Format(time.RFC3339))
+		}
+	}
+
+	secretLabels, err := certificator.OwnerLabels(options.service, options.namespace, options.secret)
+	if err != nil {
+		return err
+	}
+	secretLabels[specHashLabel] = hash
+
+	log.Printf("WebhookCertificate %s, status: Issuing certificate into Secret %s/%s", key, options.namespace, options.secret)
+	setWebhookCertificateConditions(&status, wc.Generation, "Issuing", "Certificate is being issued", conditionIssuing)
+	if u, err = r.updateStatus(ctx, u, &status); err != nil {
+		return err
+	}
+
+	return r.issue(ctx, key, u, wc, options, secretLabels, &status)
+}
+
+// issue runs the certify pipeline for the WebhookCertificate and reports the result in its status
+func (r *reconciler) issue(ctx context.Context, key string, u *unstructured.Unstructured, wc *WebhookCertificate,
+	options *CreateAndSignCertOptions, secretLabels map[string]string, status *WebhookCertificateStatus) error {
+	if err := issueCertificate(ctx, r.client, r.recorder, options, secretLabels); err != nil {
+		setWebhookCertificateConditions(status, wc.Generation, "IssuanceFailed", err.Error(), conditionFailed)
+		if _, statusErr := r.updateStatus(ctx, u, status); statusErr != nil {
+			log.Printf("WebhookCertificate %s - error occurred, detail: %v", key, statusErr)
+		}
+		return err
+	}
+
+	secret, err := r.client.CoreV1().Secrets(options.namespace).Get(ctx, options.secret, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	cert, err := certificator.LeafCertificate(secret.Data[corev1.TLSCertKey])
+	if err != nil {
+		return err
+	}
+	now := metav1.Now()
+	status.LastRenewalTime = &now
+	setWebhookCertificateIssued(status, wc.Generation, cert)
+	r.enqueueAfter(key, time.Until(certificator.RenewalTime(cert, wc.renewBefore())))
+	log.Printf("WebhookCertificate %s, status: Certificate issued, valid until %s", key, cert.NotAfter.UTC().Format(time.RFC3339))
+	_, err = r.updateStatus(ctx, u, status)
+
+	return err
+}
+
+// setIssuerRef selects the issuer named by the issuerRef of wc in options, exec issuers aren't
+// available to WebhookCertificates. Signers are limited to --signer-name and --allowed-signer, as
+// the reconciler approves their CSRs. CA Secrets are resolved in the namespace of wc, other
+// namespaces are allowed by --allowed-ca-secret only, so tenants can't sign with CAs they can't read.
+func (r *reconciler) setIssuerRef(options *CreateAndSignCertOptions, wc *WebhookCertificate) error {
+	ref := wc.Spec.IssuerRef
+	switch ref.Kind {
+	case "", issuerKindSigner:
+		if ref.Name != "" {
+			if !slices.Contains(r.signers, ref.Name) {
+				return fmt.Errorf("signer %s is not allowed by --signer-name or --allowed-signer", ref.Name)
+			}
+			options.signerName = ref.Name
+		}
+	case issuerKindCA:
+		if ref.Name == "" {
+			return fmt.Errorf("issuerRef of kind %s requires the name of the CA Secret", issuerKindCA)
+		}
+		secret := ref.Name
+		if !strings.Contains(secret, "/") {
+			secret = wc.Namespace + "/" + secret
+		}
+		namespace, _, err := splitNamespacedName(secret)
+		if err != nil {
+			return err
+		}
+		if namespace != wc.Namespace && !slices.Contains(r.caSecrets, secret) {
+			return fmt.Errorf("CA Secret %s is outside the namespace %s and not allowed by --allowed-ca-secret", secret, wc.Namespace)
+		}
+		options.issuer = IssuerOptions{kind: issuer.KindSecretCA, secret: secret}
+	case issuerKindSelfSigned:
+		options.issuer = IssuerOptions{kind: issuer.KindSelfSigned}
+	default:
+		return fmt.Errorf("unsupported issuerRef kind %q, expected one of: %s", ref.Kind,
+			strings.Join(webhookCertificateEnums["spec.issuerRef.kind"], ", "))
+	}
+
+	return nil
+}
+
+// certifyOptions returns the certify options issuing the certificate of the WebhookCertificate
+func (r *reconciler) certifyOptions(wc *WebhookCertificate) (*CreateAndSignCertOptions, error) {
+	if wc.Spec.Namespace != "" && wc.Spec.Namespace != wc.Namespace {
+		return nil, fmt.Errorf("namespace %s differs from the namespace %s of the WebhookCertificate", wc.Spec.Namespace, wc.Namespace)
+	}
+
+	options := r.template
+	options.service = wc.Spec.Service
+	options.namespace = wc.secretNamespace()
+	options.secret = wc.Spec.SecretName
+	options.duration = certificator.DefaultDuration
+	if wc.Spec.Duration != nil {
+		options.duration = wc.Spec.Duration.Duration
+	}
+	if err := certificator.ValidateDuration(options.duration); err != nil {
+		return nil, err
+	}
+
+	if err := r.setIssuerRef(&options, wc); err != nil {
+		return nil, err
+	}
+
+	options.request = certificator.Request{DNSNames: wc.Spec.DNSNames, KeyAlgorithm: wc.Spec.KeyAlgorithm}
+	for _, address := range wc.Spec.IPAddresses {
+		ip := net.ParseIP(address)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address %q", address)
+		}
+		options.request.IPAddresses = append(options.request.IPAddresses, ip)
+	}
+
+	if wc.Spec.Notifiers != nil {
+		notify, err := r.template.notify.withTargets(wc.Spec.Notifiers.Webhooks, wc.Spec.Notifiers.SlackWebhooks)
+		if err != nil {
+			return nil, err
+		}
+		options.notify = notify
+	}
+
+	options.caBundle = CABundleOptions{}
+	for _, ref := range wc.Spec.WebhookConfigurations {
+		switch ref.Kind {
+		case "MutatingWebhookConfiguration":
+			options.caBundle.mutatingWebhooks = append(options.caBundle.mutatingWebhooks, ref.Name)
+		case "ValidatingWebhookConfiguration":
+			options.caBundle.validatingWebhooks = append(options.caBundle.validatingWebhooks, ref.Name)
+		default:
+			return nil, fmt.Errorf("unsupported webhook configuration kind %q", ref.Kind)
+		}
+	}
+
+	return &options, nil
+}
+
+// authorize returns why the certificate of wc may not be issued as options describe it, so
+// tenants allowed to create WebhookCertificates in their namespace can't reach beyond it: the
+// DNS names have to be names of Services of the namespace or of --allowed-dns-domain, the IP
+// addresses cluster IPs of the Service or of --allowed-ip-range, and the webhook configurations
+// have to opt in with the inject-ca-from annotation naming the Secret. err is set when the API fails.
+func (r *reconciler) authorize(ctx context.Context, wc *WebhookCertificate,
+	options *CreateAndSignCertOptions) (invalid, err error) {
+	for _, name := range wc.Spec.DNSNames {
+		if slices.ContainsFunc(r.dnsDomains, func(domain string) bool { return name == domain || strings.HasSuffix(name, "."+domain) }) {
+			continue
+		}
+		service, _, _ := strings.Cut(name, ".")
+		_, err = r.client.CoreV1().Services(options.namespace).Get(ctx, service, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) || (err == nil && !isServiceDNSName(name, service, options.namespace)) {
+			return fmt.Errorf("DNS name %s is not a name of a Service in the namespace %s", name, options.namespace), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if invalid, err = r.authorizeIPAddresses(ctx, options); invalid != nil || err != nil {
+		return invalid, err
+	}
+
+	secret := options.namespace + "/" + options.secret
+	for _, ref := range wc.Spec.WebhookConfigurations {
+		var object metav1.Object
+		switch ref.Kind {
+		case "MutatingWebhookConfiguration":
+			object, err = r.client.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(ctx, ref.Name, metav1.GetOptions{})
+		default:
+			object, err = r.client.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(ctx, ref.Name, metav1.GetOptions{})
+		}
+		if apierrors.IsNotFound(err) || (err == nil && object.GetAnnotations()[injectCAFromAnnotation] != secret) {
+			return fmt.Errorf("%s %s doesn't opt in with the annotation %s: %s", ref.Kind, ref.Name, injectCAFromAnnotation, secret), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
+
+// authorizeIPAddresses returns why the IP addresses of options may not be requested, they have to
+// be cluster IPs of the Service or in --allowed-ip-range
+func (r *reconciler) authorizeIPAddresses(ctx context.Context, options *CreateAndSignCertOptions) (invalid, err error) {
+	var service *corev1.Service
+	for _, ip := range options.request.IPAddresses {
+		if slices.ContainsFunc(r.ipRanges, func(ipRange net.IPNet) bool { return ipRange.Contains(ip) }) {
+			continue
+		}
+		if service == nil {
+			service, err = r.client.CoreV1().Services(options.namespace).Get(ctx, options.service, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				return fmt.Errorf("IP address %s requires the Service %s/%s", ip, options.namespace, options.service), nil
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		if !slices.ContainsFunc(service.Spec.ClusterIPs, func(clusterIP string) bool { return ip.Equal(net.ParseIP(clusterIP)) }) {
+			return fmt.Errorf("IP address %s is not a cluster IP of the Service %s/%s", ip, options.namespace, options.service), nil
+		}
+	}
+
+	return nil, nil
+}
+
+// isServiceDNSName reports whether name is a DNS name of the Service in namespace, the Service
+// name alone or qualified by the namespace, svc and the cluster domain
+func isServiceDNSName(name, service, namespace string) bool {
+	qualified := service + "." + namespace + ".svc"
+	return name == service || name == service+"."+namespace || name == qualified || strings.HasPrefix(name, qualified+".")
+}
+
+// updateStatus writes status unless the object already has it and returns the updated object
+func (r *reconciler) updateStatus(ctx context.Context, u *unstructured.Unstructured,
+	status *WebhookCertificateStatus) (*unstructured.Unstructured, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(status)
+	if err != nil {
+		return nil, err
+	}
+	if current, _, _ := unstructured.NestedMap(u.Object, "status"); reflect.DeepEqual(current, content) {
+		return u, nil
+	}
+
+	u = u.DeepCopy()
+	u.Object["status"] = content
+
+	return r.dynamic.Resource(webhookCertificateResource).Namespace(u.GetNamespace()).UpdateStatus(ctx, u, metav1.UpdateOptions{})
+}
+
+// toWebhookCertificate converts an object of the dynamic client
+func toWebhookCertificate(u *unstructured.Unstructured) (*WebhookCertificate, error) {
+	wc := &WebhookCertificate{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, wc); err != nil {
+		return nil, fmt.Errorf("convert %s %s/%s: %w", webhookCertificateKind, u.GetNamespace(), u.GetName(), err)
+	}
+
+	return wc, nil
+}
+
+// secretNamespace returns the namespace of the Service and the Secret, the one of the WebhookCertificate
+// as other values of spec.namespace are rejected
+func (wc *WebhookCertificate) secretNamespace() string {
+	return wc.Namespace
+}
+
+// renewBefore returns spec.renewBefore, zero selects a third of the certificate lifetime
+func (wc *WebhookCertificate) renewBefore() time.Duration {
+	if wc.Spec.RenewBefore == nil {
+		return 0
+	}
+	return wc.Spec.RenewBefore.Duration
+}
+
+// DeepCopy returns a copy of the status, conditions and times included
+func (in *WebhookCertificateStatus) DeepCopy() *WebhookCertificateStatus {
+	out := *in
+	out.Conditions = append([]metav1.Condition(nil), in.Conditions...)
+	out.NotAfter = in.NotAfter.DeepCopy()
+	out.LastRenewalTime = in.LastRenewalTime.DeepCopy()
+
+	return &out
+}
+
+// webhookCertificateSpecHash identifies the spec a certificate was issued for
+func webhookCertificateSpecHash(wc *WebhookCertificate) string {
+	spec := wc.Spec
+	spec.Namespace = wc.secretNamespace()
+	// notifications don't change the certificate
+	spec.Notifiers = nil
+	data, _ := json.Marshal(spec)
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:])[:specHashLength]
+}
+
+// setWebhookCertificateConditions sets the Ready, Issuing and Failed conditions, those listed
+// in active are true and the others false
+func setWebhookCertificateConditions(status *WebhookCertificateStatus, generation int64, reason, message string,
+	active ...string) {
+	for _, conditionType := range []string{conditionReady, conditionIssuing, conditionFailed} {
+		conditionStatus := metav1.ConditionFalse
+		if slices.Contains(active, conditionType) {
+			conditionStatus = metav1.ConditionTrue
+		}
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               conditionType,
+			Status:             conditionStatus,
+			ObservedGeneration: generation,
+			Reason:             reason,
+			Message:            message,
+		})
+	}
+}
+
+// setWebhookCertificateIssued marks the certificate issued and reports it
+func setWebhookCertificateIssued(status *WebhookCertificateStatus, generation int64, cert *x509.Certificate) {
+	setWebhookCertificateConditions(status, generation, "Issued", "Certificate is up to date", conditionReady)
+	notAfter := metav1.NewTime(cert.NotAfter)
+	status.NotAfter = &notAfter
+	status.Serial = cert.SerialNumber.Text(16)
+}
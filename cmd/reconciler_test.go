@@ -0,0 +1,426 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/yaml"
+
+	"github.com/ealebed/admission-webhook-certificator/pkg/certificator"
+	"github.com/ealebed/admission-webhook-certificator/pkg/issuer"
+)
+
+// newWebhookCertificate returns a WebhookCertificate as the dynamic client serves it
+func newWebhookCertificate(t *testing.T, spec WebhookCertificateSpec) *unstructured.Unstructured {
+	t.Helper()
+
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&WebhookCertificate{
+		TypeMeta:   metav1.TypeMeta{APIVersion: webhookCertificateGroup + "/" + webhookCertificateVersion, Kind: webhookCertificateKind},
+		ObjectMeta: metav1.ObjectMeta{Name: "webhook", Namespace: "webhook", Generation: 1},
+		Spec:       spec,
+	})
+	if err != nil {
+		t.Fatalf("ToUnstructured() error = %v", err)
+	}
+
+	return &unstructured.Unstructured{Object: content}
+}
+
+func TestReconcilerSync(t *testing.T) {
+	ca := newTestCA(t, 24*time.Hour)
+	spec := WebhookCertificateSpec{
+		Service:               "webhook-svc",
+		SecretName:            "webhook-certs",
+		DNSNames:              []string{"webhook-svc.webhook.svc.cluster.local"},
+		IPAddresses:           []string{"10.0.0.1"},
+		Duration:              &metav1.Duration{Duration: time.Hour},
+		KeyAlgorithm:          certificator.KeyAlgorithmECDSA,
+		IssuerRef:             IssuerReference{Kind: issuerKindSigner, Name: webhookServingSignerName},
+		WebhookConfigurations: []WebhookConfigurationReference{{Kind: "MutatingWebhookConfiguration", Name: "webhook-cfg"}},
+	}
+	invalid := spec
+	invalid.IssuerRef = IssuerReference{Kind: "ClusterIssuer", Name: "vault"}
+	selfSigned := spec
+	selfSigned.IssuerRef = IssuerReference{Kind: issuerKindSelfSigned}
+	secretCA := spec
+	secretCA.IssuerRef = IssuerReference{Kind: issuerKindCA, Name: "webhook-ca"}
+	foreignCA := spec
+	foreignCA.IssuerRef = IssuerReference{Kind: issuerKindCA, Name: "certificator/certificator-ca"}
+	foreignNamespace := spec
+	foreignNamespace.Namespace = "kube-system"
+	foreignDNSName := spec
+	foreignDNSName.DNSNames = []string{"kubernetes.default.svc"}
+	foreignSigner := spec
+	foreignSigner.IssuerRef = IssuerReference{Kind: issuerKindSigner, Name: certificator.KubeAPIServerClientSignerName}
+	foreignIP := spec
+	foreignIP.IPAddresses = []string{"10.0.0.2"}
+	internalNotifier := spec
+	internalNotifier.Notifiers = &NotifierSpec{Webhooks: []string{"http://10.0.0.1:8080/"}}
+	notOptedIn := spec
+	notOptedIn.WebhookConfigurations = []WebhookConfigurationReference{{Kind: "ValidatingWebhookConfiguration", Name: "policy"}}
+	caCertPEM, caKeyPEM, err := issuer.GenerateCA("webhook-ca", time.Hour)
+	if err != nil {
+		t.Fatalf("issuer.GenerateCA() error = %v", err)
+	}
+	caSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "webhook-ca", Namespace: "webhook"},
+		Data:       map[string][]byte{corev1.TLSCertKey: caCertPEM, corev1.TLSPrivateKeyKey: caKeyPEM},
+	}
+	upToDate := newIssuedSecret(t, ca, &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "webhook-svc", Namespace: "webhook"}},
+		"webhook-certs", 12*time.Hour)
+	upToDate.Labels[specHashLabel] = webhookCertificateSpecHash(&WebhookCertificate{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "webhook"}, Spec: spec,
+	})
+
+	tests := []struct {
+		name         string
+		spec         WebhookCertificateSpec
+		secret       *corev1.Secret
+		failCreate   bool
+		wantErr      bool
+		wantIssued   bool
+		wantReason   string
+		wantActive   string
+		wantCABundle bool
+	}{
+		{
+			name:         "issues certificate and injects CA",
+			spec:         spec,
+			wantIssued:   true,
+			wantReason:   "Issued",
+			wantActive:   conditionReady,
+			wantCABundle: true,
+		},
+		{
+			name:       "reports up to date Secret",
+			spec:       spec,
+			secret:     upToDate,
+			wantReason: "Issued",
+			wantActive: conditionReady,
+		},
+		{
+			name:         "issues self-signed certificate without CSR",
+			spec:         selfSigned,
+			wantReason:   "Issued",
+			wantActive:   conditionReady,
+			wantCABundle: true,
+		},
+		{
+			name:         "issues from a CA Secret of its namespace",
+			spec:         secretCA,
+			wantReason:   "Issued",
+			wantActive:   conditionReady,
+			wantCABundle: true,
+		},
+		{
+			name:       "rejects a CA Secret of another namespace",
+			spec:       foreignCA,
+			wantReason: "Invalid",
+			wantActive: conditionFailed,
+		},
+		{
+			name:       "rejects a Secret of another namespace",
+			spec:       foreignNamespace,
+			wantReason: "Invalid",
+			wantActive: conditionFailed,
+		},
+		{
+			name:       "rejects a DNS name of no Service of its namespace",
+			spec:       foreignDNSName,
+			wantReason: "Invalid",
+			wantActive: conditionFailed,
+		},
+		{
+			name:       "rejects a signer which isn't allowed",
+			spec:       foreignSigner,
+			wantReason: "Invalid",
+			wantActive: conditionFailed,
+		},
+		{
+			name:       "rejects an IP address which isn't a cluster IP of the Service",
+			spec:       foreignIP,
+			wantReason: "Invalid",
+			wantActive: conditionFailed,
+		},
+		{
+			name:       "rejects a notify URL of a host which isn't allowed",
+			spec:       internalNotifier,
+			wantReason: "Invalid",
+			wantActive: conditionFailed,
+		},
+		{
+			name:       "rejects a webhook configuration which doesn't opt in",
+			spec:       notOptedIn,
+			wantReason: "Invalid",
+			wantActive: conditionFailed,
+		},
+		{
+			name:       "rejects unsupported issuer",
+			spec:       invalid,
+			wantReason: "Invalid",
+			wantActive: conditionFailed,
+		},
+		{
+			name:       "reports failed issuance",
+			spec:       spec,
+			failCreate: true,
+			wantErr:    true,
+			wantIssued: true,
+			wantReason: "IssuanceFailed",
+			wantActive: conditionFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wc := newWebhookCertificate(t, tt.spec)
+			cs := fake.NewClientset(&admissionv1.MutatingWebhookConfiguration{
+				ObjectMeta: metav1.ObjectMeta{Name: "webhook-cfg", Annotations: map[string]string{injectCAFromAnnotation: "webhook/webhook-certs"}},
+				Webhooks:   []admissionv1.MutatingWebhook{{Name: "a.webhook.io"}},
+			}, &admissionv1.ValidatingWebhookConfiguration{
+				ObjectMeta: metav1.ObjectMeta{Name: "policy"},
+			}, &corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "webhook-svc", Namespace: "webhook"},
+				Spec:       corev1.ServiceSpec{ClusterIP: "10.0.0.1", ClusterIPs: []string{"10.0.0.1"}},
+			}, caSecret)
+			signCSRsOnCreate(cs, ca)
+			if tt.failCreate {
+				cs.PrependReactor("create", "certificatesigningrequests", func(k8stesting.Action) (bool, runtime.Object, error) {
+					return true, nil, errors.New("signer unavailable")
+				})
+			}
+			secrets := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+			if tt.secret != nil {
+				_ = secrets.Add(tt.secret)
+				_ = cs.Tracker().Add(tt.secret.DeepCopy())
+			}
+			dyn := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+				map[schema.GroupVersionResource]string{webhookCertificateResource: webhookCertificateKind + "List"}, wc)
+
+			dynamicFactory := dynamicinformer.NewDynamicSharedInformerFactory(dyn, time.Minute)
+			r := &reconciler{
+				client:       cs,
+				recorder:     record.NewFakeRecorder(100),
+				dynamic:      dyn,
+				informer:     dynamicFactory.ForResource(webhookCertificateResource).Informer(),
+				secretLister: corelisters.NewSecretLister(secrets),
+				template:     CreateAndSignCertOptions{approval: certificator.ApprovalSelf, timeout: time.Second, signerName: webhookServingSignerName},
+				signers:      []string{webhookServingSignerName},
+			}
+			if _, err := r.controller(informers.NewSharedInformerFactory(cs, time.Minute)); err != nil {
+				t.Fatalf("controller() error = %v", err)
+			}
+			var requeued time.Duration
+			r.enqueueAfter = func(_ string, after time.Duration) { requeued = after }
+			_ = r.informer.GetIndexer().Add(wc)
+
+			if keys, _ := r.informer.GetIndexer().IndexKeys(webhookCertificateSecretIndex, "webhook/webhook-certs"); len(keys) != 1 {
+				t.Errorf("Expected WebhookCertificate indexed by its Secret, got %v", keys)
+			}
+
+			err := r.sync(context.TODO(), "webhook/webhook")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("sync() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			issued := false
+			for _, action := range cs.Actions() {
+				if action.Matches("create", "certificatesigningrequests") {
+					issued = true
+				}
+			}
+			if issued != tt.wantIssued {
+				t.Errorf("Expected issued %v, got %v", tt.wantIssued, issued)
+			}
+
+			obj, _ := dyn.Resource(webhookCertificateResource).Namespace("webhook").Get(context.TODO(), "webhook", metav1.GetOptions{})
+			updated, err := toWebhookCertificate(obj)
+			if err != nil {
+				t.Fatalf("toWebhookCertificate() error = %v", err)
+			}
+			for _, conditionType := range []string{conditionReady, conditionIssuing, conditionFailed} {
+				condition := meta.FindStatusCondition(updated.Status.Conditions, conditionType)
+				if condition == nil || condition.Reason != tt.wantReason || (condition.Status == metav1.ConditionTrue) != (conditionType == tt.wantActive) {
+					t.Errorf("Unexpected %s condition %+v", conditionType, condition)
+				}
+			}
+			if tt.wantActive == conditionReady {
+				if updated.Status.NotAfter == nil || updated.Status.Serial == "" || requeued <= 0 {
+					t.Errorf("Expected issued certificate reported and renewal scheduled, got %+v, requeued %s", updated.Status, requeued)
+				}
+			}
+
+			config, _ := cs.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(context.TODO(), "webhook-cfg", metav1.GetOptions{})
+			if injected := len(config.Webhooks[0].ClientConfig.CABundle) > 0; injected != tt.wantCABundle {
+				t.Errorf("Expected caBundle injected %v, got %v", tt.wantCABundle, injected)
+			}
+		})
+	}
+}
+
+func TestWebhookCertificateCRD(t *testing.T) {
+	data, err := yaml.Marshal(webhookCertificateCRD())
+	if err != nil {
+		t.Fatalf("yaml.Marshal() error = %v", err)
+	}
+	manifest, err := os.ReadFile("../manifests/webhookcertificate-crd.yaml")
+	if err != nil || !bytes.Equal(manifest, data) {
+		t.Errorf("manifests/webhookcertificate-crd.yaml is stale, regenerate it with: certificator crd, err = %v", err)
+	}
+
+	crd := map[string]interface{}{}
+	if err = yaml.Unmarshal(data, &crd); err != nil {
+		t.Fatalf("yaml.Unmarshal() error = %v", err)
+	}
+	versions, _, _ := unstructured.NestedSlice(crd, "spec", "versions")
+	if len(versions) != 1 {
+		t.Fatalf("Expected one version, got %d", len(versions))
+	}
+	version := versions[0].(map[string]interface{})
+
+	required, _, _ := unstructured.NestedStringSlice(version, "schema", "openAPIV3Schema", "properties", "spec", "required")
+	if len(required) != 2 || required[0] != "service" || required[1] != "secretName" {
+		t.Errorf("Expected service and secretName required, got %v", required)
+	}
+	enum, _, _ := unstructured.NestedStringSlice(version, "schema", "openAPIV3Schema", "properties", "spec", "properties", "keyAlgorithm", "enum")
+	if len(enum) != 2 {
+		t.Errorf("Expected keyAlgorithm enum, got %v", enum)
+	}
+	format, _, _ := unstructured.NestedString(version, "schema", "openAPIV3Schema", "properties", "status", "properties", "notAfter", "format")
+	if format != "date-time" {
+		t.Errorf("Expected notAfter date-time format, got %q", format)
+	}
+}
+
+func TestReconcilerSetIssuerRef(t *testing.T) {
+	r := &reconciler{caSecrets: []string{"certificator/certificator-ca"}, signers: []string{webhookServingSignerName}}
+
+	tests := []struct {
+		name    string
+		ref     IssuerReference
+		want    IssuerOptions
+		wantErr bool
+	}{
+		{name: "signer", ref: IssuerReference{Kind: issuerKindSigner, Name: webhookServingSignerName}},
+		{name: "signer of the reconciler", ref: IssuerReference{Kind: issuerKindSigner}},
+		{name: "signer which isn't allowed", ref: IssuerReference{Kind: issuerKindSigner, Name: certificator.KubeAPIServerClientSignerName}, wantErr: true},
+		{name: "CA of its namespace", ref: IssuerReference{Kind: issuerKindCA, Name: "webhook-ca"},
+			want: IssuerOptions{kind: issuer.KindSecretCA, secret: "webhook/webhook-ca"}},
+		{name: "CA of its namespace by namespace/name", ref: IssuerReference{Kind: issuerKindCA, Name: "webhook/webhook-ca"},
+			want: IssuerOptions{kind: issuer.KindSecretCA, secret: "webhook/webhook-ca"}},
+		{name: "allowed CA of another namespace", ref: IssuerReference{Kind: issuerKindCA, Name: "certificator/certificator-ca"},
+			want: IssuerOptions{kind: issuer.KindSecretCA, secret: "certificator/certificator-ca"}},
+		{name: "CA of another namespace", ref: IssuerReference{Kind: issuerKindCA, Name: "kube-system/cluster-ca"}, wantErr: true},
+		{name: "CA without name", ref: IssuerReference{Kind: issuerKindCA}, wantErr: true},
+		{name: "self-signed", ref: IssuerReference{Kind: issuerKindSelfSigned}, want: IssuerOptions{kind: issuer.KindSelfSigned}},
+		{name: "exec", ref: IssuerReference{Kind: "Exec", Name: "/bin/sh"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wc := &WebhookCertificate{ObjectMeta: metav1.ObjectMeta{Namespace: "webhook"}, Spec: WebhookCertificateSpec{IssuerRef: tt.ref}}
+			options := CreateAndSignCertOptions{}
+			err := r.setIssuerRef(&options, wc)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("setIssuerRef() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && options.issuer != tt.want {
+				t.Errorf("Expected issuer %+v, got %+v", tt.want, options.issuer)
+			}
+		})
+	}
+}
+
+func TestReconcilerAuthorizeDNSNames(t *testing.T) {
+	cs := fake.NewClientset(&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "webhook-svc", Namespace: "webhook"}})
+	r := &reconciler{client: cs, dnsDomains: []string{"example.io"}}
+
+	tests := []struct {
+		name        string
+		wantInvalid bool
+	}{
+		{name: "webhook-svc"},
+		{name: "webhook-svc.webhook"},
+		{name: "webhook-svc.webhook.svc.cluster.local"},
+		{name: "webhook.example.io"},
+		{name: "example.io"},
+		{name: "webhook-svc.kube-system.svc", wantInvalid: true},
+		{name: "webhook-svc.example.com", wantInvalid: true},
+		{name: "kubernetes.default.svc", wantInvalid: true},
+		{name: "notexample.io", wantInvalid: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wc := &WebhookCertificate{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "webhook"},
+				Spec:       WebhookCertificateSpec{Service: "webhook-svc", SecretName: "webhook-certs", DNSNames: []string{tt.name}},
+			}
+			invalid, err := r.authorize(context.TODO(), wc, &CreateAndSignCertOptions{namespace: "webhook", secret: "webhook-certs"})
+			if err != nil {
+				t.Fatalf("authorize() error = %v", err)
+			}
+			if (invalid != nil) != tt.wantInvalid {
+				t.Errorf("authorize() invalid = %v, wantInvalid %v", invalid, tt.wantInvalid)
+			}
+		})
+	}
+}
+
+func TestReconcilerAuthorizeIPAddresses(t *testing.T) {
+	cs := fake.NewClientset(&corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "webhook-svc", Namespace: "webhook"},
+		Spec:       corev1.ServiceSpec{ClusterIP: "10.0.0.1", ClusterIPs: []string{"10.0.0.1", "fd00::1"}},
+	})
+	_, ipRange, _ := net.ParseCIDR("192.168.0.0/16")
+	r := &reconciler{client: cs, ipRanges: []net.IPNet{*ipRange}}
+
+	tests := []struct {
+		name        string
+		service     string
+		ip          string
+		wantInvalid bool
+	}{
+		{name: "cluster IP", service: "webhook-svc", ip: "10.0.0.1"},
+		{name: "IPv6 cluster IP", service: "webhook-svc", ip: "fd00::1"},
+		{name: "allowed range", service: "webhook-svc", ip: "192.168.1.10"},
+		{name: "allowed range without Service", service: "missing", ip: "192.168.1.10"},
+		{name: "cluster IP of another Service", service: "webhook-svc", ip: "10.0.0.2", wantInvalid: true},
+		{name: "API server", service: "webhook-svc", ip: "10.96.0.1", wantInvalid: true},
+		{name: "missing Service", service: "missing", ip: "10.0.0.1", wantInvalid: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := &CreateAndSignCertOptions{service: tt.service, namespace: "webhook", secret: "webhook-certs"}
+			options.request.IPAddresses = []net.IP{net.ParseIP(tt.ip)}
+			invalid, err := r.authorizeIPAddresses(context.TODO(), options)
+			if err != nil {
+				t.Fatalf("authorizeIPAddresses() error = %v", err)
+			}
+			if (invalid != nil) != tt.wantInvalid {
+				t.Errorf("authorizeIPAddresses() invalid = %v, wantInvalid %v", invalid, tt.wantInvalid)
+			}
+		})
+	}
+}
@@ -0,0 +1,305 @@
+/*
+Copyright © 2024 Yevhen Lebid ealebed@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/ealebed/admission-webhook-certificator/pkg/certificator"
+)
+
+const (
+	// certificateFingerprintAnnotation on a pod template rolls the workload out when the certificate changes
+	certificateFingerprintAnnotation = "certificator.ealebed.io/certificate-fingerprint"
+
+	defaultRestartTimeout = 5 * time.Minute
+	rolloutPollInterval   = 2 * time.Second
+)
+
+// workload kinds restarted with --restart
+const (
+	workloadDeployment  = "deployment"
+	workloadStatefulSet = "statefulset"
+	workloadDaemonSet   = "daemonset"
+)
+
+// workloadKindAliases maps the kinds accepted by --restart, like kubectl does, to workload kinds
+var workloadKindAliases = map[string]string{
+	"deployment": workloadDeployment, "deployments": workloadDeployment, "deploy": workloadDeployment,
+	"statefulset": workloadStatefulSet, "statefulsets": workloadStatefulSet, "sts": workloadStatefulSet,
+	"daemonset": workloadDaemonSet, "daemonsets": workloadDaemonSet, "ds": workloadDaemonSet,
+}
+
+// RestartOptions represents workloads rolled out when their certificate changes
+type RestartOptions struct {
+	workloads []string
+	wait      bool
+	timeout   time.Duration
+}
+
+// addRestartFlags registers flags restarting workloads after the Secret is updated
+func addRestartFlags(cmd *cobra.Command, options *RestartOptions) {
+	cmd.Flags().StringArrayVar(&options.workloads, "restart", nil,
+		"Workload in the Secret namespace rolled out when the certificate changes, e.g. deployment/webhook, "+
+			"also statefulset and daemonset, may be repeated.")
+	cmd.Flags().BoolVar(&options.wait, "restart-wait", false,
+		"Wait for the restarted workloads to complete their rollout.")
+	cmd.Flags().DurationVar(&options.timeout, "restart-timeout", defaultRestartTimeout,
+		"How long to wait for the rollout of the restarted workloads.")
+}
+
+// enabled reports whether any workload is restarted
+func (o *RestartOptions) enabled() bool {
+	return len(o.workloads) > 0
+}
+
+// validate checks the workload references
+func (o *RestartOptions) validate() error {
+	for _, workload := range o.workloads {
+		if _, err := parseWorkload(workload); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// args returns command line arguments reproducing the options
+func (o *RestartOptions) args() []string {
+	var args []string
+	for _, workload := range o.workloads {
+		args = append(args, "--restart="+workload)
+	}
+	if o.enabled() && o.wait {
+		args = append(args, "--restart-wait")
+	}
+	if o.enabled() && o.timeout != 0 && o.timeout != defaultRestartTimeout {
+		args = append(args, "--restart-timeout="+o.timeout.String())
+	}
+
+	return args
+}
+
+// kinds returns the workload kinds restarted
+func (o *RestartOptions) kinds() []string {
+	var kinds []string
+	for _, workload := range o.workloads {
+		if ref, err := parseWorkload(workload); err == nil && !slices.Contains(kinds, ref.kind) {
+			kinds = append(kinds, ref.kind)
+		}
+	}
+
+	return kinds
+}
+
+// workloadReference names a workload in the Secret namespace
+type workloadReference struct {
+	kind string
+	name string
+}
+
+func (r workloadReference) String() string { return r.kind + "/" + r.name }
+
+// parseWorkload parses kind/name
+func parseWorkload(value string) (workloadReference, error) {
+	kind, name, ok := strings.Cut(value, "/")
+	if !ok || name == "" || strings.Contains(name, "/") {
+		return workloadReference{}, fmt.Errorf("invalid workload %q, expected kind/name", value)
+	}
+	ref := workloadReference{kind: workloadKindAliases[strings.ToLower(kind)], name: name}
+	if ref.kind == "" {
+		return workloadReference{}, fmt.Errorf("unsupported workload kind %q, expected one of: %s, %s, %s",
+			kind, workloadDeployment, workloadStatefulSet, workloadDaemonSet)
+	}
+
+	return ref, nil
+}
+
+// restartWorkloads rolls out the workloads of options whose pod template doesn't carry the
+// fingerprint of certPEM yet, and waits for their rollout when asked to
+func restartWorkloads(ctx context.Context, cs kubernetes.Interface, options *CreateAndSignCertOptions, certPEM []byte,
+	serviceEvent func(eventtype, reason, messageFmt string, args ...interface{})) error {
+	ctx, span := certificator.StartSpan(ctx, "restartWorkloads", certificator.AttrNamespace.String(options.namespace))
+	defer span.End()
+
+	cert, err := certificator.LeafCertificate(certPEM)
+	if err != nil {
+		return certificator.SpanError(span, err)
+	}
+	fingerprint := certificateFingerprint(cert.Raw)
+
+	var restarted []workloadReference
+	for _, workload := range options.restart.workloads {
+		var ref workloadReference
+		if ref, err = parseWorkload(workload); err != nil {
+			return certificator.SpanError(span, err)
+		}
+		var template *corev1.PodTemplateSpec
+		if template, err = workloadTemplate(ctx, cs, options.namespace, ref); err != nil {
+			return certificator.SpanError(span, fmt.Errorf("get %s: %w", ref, err))
+		}
+		if template.Annotations[certificateFingerprintAnnotation] == fingerprint {
+			log.Printf("Workload %s/%s, status: Already runs the certificate, restart skipped", options.namespace, ref)
+			continue
+		}
+		if err = patchWorkloadFingerprint(ctx, cs, options.namespace, ref, fingerprint); err != nil {
+			return certificator.SpanError(span, fmt.Errorf("restart %s: %w", ref, err))
+		}
+		log.Printf("Workload %s/%s, status: Restarted", options.namespace, ref)
+		serviceEvent(corev1.EventTypeNormal, eventReasonWorkloadRestarted, "Restarted %s for the certificate of Secret %s", ref, options.secret)
+		restarted = append(restarted, ref)
+	}
+
+	if !options.restart.wait {
+		return nil
+	}
+	for _, ref := range restarted {
+		if err = waitForRollout(ctx, cs, options.namespace, ref, options.restart.timeout); err != nil {
+			return certificator.SpanError(span, err)
+		}
+	}
+
+	return nil
+}
+
+// waitForRollout waits until all replicas of the workload run its current pod template
+func waitForRollout(ctx context.Context, cs kubernetes.Interface, namespace string, ref workloadReference, timeout time.Duration) error {
+	if timeout <= 0 {
+		timeout = defaultRestartTimeout
+	}
+
+	var lastErr error
+	err := wait.PollUntilContextTimeout(ctx, rolloutPollInterval, timeout, true, func(ctx context.Context) (bool, error) {
+		var done bool
+		done, lastErr = workloadRolledOut(ctx, cs, namespace, ref)
+		return done, nil
+	})
+	if err != nil {
+		if lastErr != nil {
+			return fmt.Errorf("rollout of %s: %w", ref, lastErr)
+		}
+		return fmt.Errorf("rollout of %s not complete after %s", ref, timeout)
+	}
+	log.Printf("Workload %s/%s, status: Rolled out", namespace, ref)
+
+	return nil
+}
+
+// workloadTemplate returns the pod template of the workload
+func workloadTemplate(ctx context.Context, cs kubernetes.Interface, namespace string, ref workloadReference) (*corev1.PodTemplateSpec, error) {
+	apps := cs.AppsV1()
+	switch ref.kind {
+	case workloadDeployment:
+		deployment, err := apps.Deployments(namespace).Get(ctx, ref.name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return &deployment.Spec.Template, nil
+	case workloadStatefulSet:
+		statefulSet, err := apps.StatefulSets(namespace).Get(ctx, ref.name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return &statefulSet.Spec.Template, nil
+	default:
+		daemonSet, err := apps.DaemonSets(namespace).Get(ctx, ref.name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return &daemonSet.Spec.Template, nil
+	}
+}
+
+// patchWorkloadFingerprint sets the fingerprint annotation of the pod template, which rolls the
+// workload out like kubectl rollout restart does
+func patchWorkloadFingerprint(ctx context.Context, cs kubernetes.Interface, namespace string, ref workloadReference, fingerprint string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"annotations": map[string]string{certificateFingerprintAnnotation: fingerprint},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	apps := cs.AppsV1()
+	switch ref.kind {
+	case workloadDeployment:
+		_, err = apps.Deployments(namespace).Patch(ctx, ref.name, types.StrategicMergePatchType, patch, metav1.PatchOptions{})
+	case workloadStatefulSet:
+		_, err = apps.StatefulSets(namespace).Patch(ctx, ref.name, types.StrategicMergePatchType, patch, metav1.PatchOptions{})
+	default:
+		_, err = apps.DaemonSets(namespace).Patch(ctx, ref.name, types.StrategicMergePatchType, patch, metav1.PatchOptions{})
+	}
+
+	return err
+}
+
+// workloadRolledOut reports whether the rollout of the workload is complete, the checks of kubectl rollout status
+func workloadRolledOut(ctx context.Context, cs kubernetes.Interface, namespace string, ref workloadReference) (bool, error) {
+	apps := cs.AppsV1()
+	switch ref.kind {
+	case workloadDeployment:
+		d, err := apps.Deployments(namespace).Get(ctx, ref.name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		replicas := ptrValue(d.Spec.Replicas, 1)
+		return d.Status.ObservedGeneration >= d.Generation && d.Status.UpdatedReplicas == replicas &&
+			d.Status.Replicas == replicas && d.Status.AvailableReplicas == replicas, nil
+	case workloadStatefulSet:
+		s, err := apps.StatefulSets(namespace).Get(ctx, ref.name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		replicas := ptrValue(s.Spec.Replicas, 1)
+		return s.Status.ObservedGeneration >= s.Generation && s.Status.UpdatedReplicas == replicas &&
+			s.Status.ReadyReplicas == replicas && s.Status.CurrentRevision == s.Status.UpdateRevision, nil
+	default:
+		ds, err := apps.DaemonSets(namespace).Get(ctx, ref.name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		return ds.Status.ObservedGeneration >= ds.Generation && ds.Status.UpdatedNumberScheduled == ds.Status.DesiredNumberScheduled &&
+			ds.Status.NumberAvailable == ds.Status.DesiredNumberScheduled, nil
+	}
+}
+
+// ptrValue returns *p, or def when p is nil
+func ptrValue[T any](p *T, def T) T {
+	if p == nil {
+		return def
+	}
+	return *p
+}
@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/ealebed/admission-webhook-certificator/pkg/certificator"
+)
+
+func TestParseWorkload(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    workloadReference
+		wantErr bool
+	}{
+		{value: "deployment/webhook", want: workloadReference{kind: workloadDeployment, name: "webhook"}},
+		{value: "deploy/webhook", want: workloadReference{kind: workloadDeployment, name: "webhook"}},
+		{value: "StatefulSets/webhook", want: workloadReference{kind: workloadStatefulSet, name: "webhook"}},
+		{value: "ds/webhook", want: workloadReference{kind: workloadDaemonSet, name: "webhook"}},
+		{value: "pod/webhook", wantErr: true},
+		{value: "webhook", wantErr: true},
+		{value: "deployment/webhook/extra", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseWorkload(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseWorkload() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRestartWorkloads(t *testing.T) {
+	ca := newTestCA(t, 24*time.Hour)
+	secret := newIssuedSecret(t, ca, &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "webhook-svc", Namespace: "webhook"}},
+		"webhook-certs", time.Hour)
+	certPEM := secret.Data[corev1.TLSCertKey]
+	cert, _ := certificator.LeafCertificate(certPEM)
+	fingerprint := certificateFingerprint(cert.Raw)
+
+	replicas := int32(1)
+	template := func(annotations map[string]string) corev1.PodTemplateSpec {
+		return corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Annotations: annotations}}
+	}
+	newObjects := func() (*appsv1.Deployment, *appsv1.StatefulSet, *appsv1.DaemonSet) {
+		return &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Name: "webhook", Namespace: "webhook"},
+				Spec:       appsv1.DeploymentSpec{Replicas: &replicas, Template: template(map[string]string{"team": "webhooks"})},
+				Status:     appsv1.DeploymentStatus{Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1},
+			},
+			&appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{Name: "webhook", Namespace: "webhook"},
+				Spec:       appsv1.StatefulSetSpec{Replicas: &replicas, Template: template(map[string]string{certificateFingerprintAnnotation: fingerprint})},
+			},
+			&appsv1.DaemonSet{
+				ObjectMeta: metav1.ObjectMeta{Name: "webhook", Namespace: "webhook"},
+				Status:     appsv1.DaemonSetStatus{DesiredNumberScheduled: 2, UpdatedNumberScheduled: 1, NumberAvailable: 2},
+			}
+	}
+
+	tests := []struct {
+		name          string
+		restart       RestartOptions
+		wantRestarted []string
+		wantErr       string
+	}{
+		{
+			name:          "restarts workloads running another certificate",
+			restart:       RestartOptions{workloads: []string{"deployment/webhook", "statefulset/webhook"}, wait: true},
+			wantRestarted: []string{"deployment/webhook"},
+		},
+		{
+			name:          "fails when rollout doesn't complete",
+			restart:       RestartOptions{workloads: []string{"daemonset/webhook"}, wait: true, timeout: 10 * time.Millisecond},
+			wantRestarted: []string{"daemonset/webhook"},
+			wantErr:       "rollout of daemonset/webhook not complete",
+		},
+		{
+			name:    "fails for missing workload",
+			restart: RestartOptions{workloads: []string{"deployment/missing"}},
+			wantErr: "get deployment/missing",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deployment, statefulSet, daemonSet := newObjects()
+			cs := fake.NewClientset(deployment, statefulSet, daemonSet)
+			recorder := record.NewFakeRecorder(10)
+			serviceEvent := func(eventtype, reason, messageFmt string, args ...interface{}) {
+				recorder.Eventf(&corev1.Service{}, eventtype, reason, messageFmt, args...)
+			}
+			options := &CreateAndSignCertOptions{namespace: "webhook", secret: "webhook-certs", restart: tt.restart}
+
+			err := restartWorkloads(context.TODO(), cs, options, certPEM, serviceEvent)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("restartWorkloads() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+
+			var restarted []string
+			for _, ref := range []workloadReference{{workloadDeployment, "webhook"}, {workloadStatefulSet, "webhook"}, {workloadDaemonSet, "webhook"}} {
+				got, _ := workloadTemplate(context.TODO(), cs, "webhook", ref)
+				want, _ := workloadTemplate(context.TODO(), fake.NewClientset(deployment, statefulSet, daemonSet), "webhook", ref)
+				if got.Annotations[certificateFingerprintAnnotation] != want.Annotations[certificateFingerprintAnnotation] {
+					if got.Annotations[certificateFingerprintAnnotation] != fingerprint {
+						t.Errorf("Expected %s restarted with fingerprint %s, got %v", ref, fingerprint, got.Annotations)
+					}
+					restarted = append(restarted, ref.String())
+				}
+			}
+			if strings.Join(restarted, ",") != strings.Join(tt.wantRestarted, ",") {
+				t.Errorf("Expected restarted %v, got %v", tt.wantRestarted, restarted)
+			}
+			if len(recorder.Events) != len(tt.wantRestarted) {
+				t.Errorf("Expected %d WorkloadRestarted Events, got %d", len(tt.wantRestarted), len(recorder.Events))
+			}
+			if got, _ := workloadTemplate(context.TODO(), cs, "webhook", workloadReference{workloadDeployment, "webhook"}); got.Annotations["team"] != "webhooks" {
+				t.Errorf("Expected restart to keep pod template annotations, got %v", got.Annotations)
+			}
+		})
+	}
+}
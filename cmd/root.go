@@ -19,38 +19,69 @@ package cmd
 import (
 	"fmt"
 	"io"
+	"time"
 
 	"github.com/spf13/cobra"
 
 	"github.com/ealebed/admission-webhook-certificator/cmd/version"
+	"github.com/ealebed/admission-webhook-certificator/pkg/certificator"
 )
 
 // Execute adds all child commands to the root command and sets flags appropriately
 func Execute(out io.Writer) error {
 	cmd := NewCmdRoot(out)
+	defer shutdownTracing()
 	return cmd.Execute()
 }
 
 // NewCmdRoot returns new root command
 func NewCmdRoot(out io.Writer) *cobra.Command {
+	tracing := TracingOptions{}
+
 	cmd := &cobra.Command{
 		SilenceUsage:  true,
 		SilenceErrors: true,
 		Version:       version.String(),
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return startTracing(cmd.Context(), &tracing)
+		},
 	}
+	addTracingFlags(cmd, &tracing)
 
 	// create subcommands
 	cmd.AddCommand(NewCreateAndSignCertCmd())
+	cmd.AddCommand(NewManifestsCmd(out))
+	cmd.AddCommand(NewGarbageCollectCmd())
+	cmd.AddCommand(NewApproverCmd())
+	cmd.AddCommand(NewSignerCmd())
+	cmd.AddCommand(NewRotateCACmd())
+	cmd.AddCommand(NewInjectorCmd())
+	cmd.AddCommand(NewOperatorCmd())
+	cmd.AddCommand(NewReconcilerCmd())
+	cmd.AddCommand(NewCRDCmd(out))
 
 	return cmd
 }
 
 // CreateAndSignCertOptions represents options for create and sign certificate command
 type CreateAndSignCertOptions struct {
-	service    string
-	namespace  string
-	secret     string
-	kubeconfig string
+	service        string
+	namespace      string
+	secret         string
+	kubeconfig     string
+	keepCSR        bool
+	duration       time.Duration
+	strictDuration bool
+	approval       string
+	timeout        time.Duration
+	signerName     string
+	issuer         IssuerOptions
+	caBundle       CABundleOptions
+	request        certificator.Request
+	runMetrics     RunMetricsOptions
+	notify         NotifierOptions
+	verify         VerifyEndpointOptions
+	restart        RestartOptions
 }
 
 // NewDockerhubDeleteRepositoryCmd returns new docker delete repository command
@@ -65,20 +96,103 @@ func NewCreateAndSignCertCmd() *cobra.Command {
 			"Certificate is signed by k8s CA using CertificateSigningRequest API",
 		Example: "certify [--service=webhook-svc --namespace=webhook --secret=webhook-certs]",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return createAndSignCert(options.service, options.namespace, options.secret, options.kubeconfig)
+			if err := options.validate(); err != nil {
+				return err
+			}
+			return createAndSignCert(&options)
 		},
 	}
 
+	addCertifyFlags(cmd, &options)
+	cmd.Flags().StringVarP(&options.kubeconfig, "kubeconfig", "k", "", "kubeconfig path")
+	addRunMetricsFlags(cmd, &options.runMetrics)
+
+	if err := cmd.MarkFlagRequired("service"); err != nil {
+		fmt.Println("`service` flag is required")
+	}
+
+	return cmd
+}
+
+// addCertifyFlags registers flags which describe the certificate to issue,
+// shared between certify and commands that run certify on the user's behalf
+func addCertifyFlags(cmd *cobra.Command, options *CreateAndSignCertOptions) {
 	cmd.Flags().StringVarP(&options.service, "service", "s", "", "Webhook service name.")
 	cmd.Flags().StringVarP(&options.namespace, "namespace", "n", "webhook",
 		"Namespace where webhook service and secret reside.")
 	cmd.Flags().StringVarP(&options.secret, "secret", "t", "webhook-certs",
 		"Secret name for CA certificate and server certificate/key pair.")
-	cmd.Flags().StringVarP(&options.kubeconfig, "kubeconfig", "k", "", "kubeconfig path")
+	cmd.Flags().BoolVar(&options.keepCSR, "keep-csr", false,
+		"Keep the CertificateSigningRequest after the certificate has been stored.")
+	cmd.Flags().DurationVar(&options.duration, "duration", certificator.DefaultDuration,
+		"Requested certificate lifetime, at least 10m. Signers may cap it with their own maximum.")
+	cmd.Flags().BoolVar(&options.strictDuration, "strict-duration", false,
+		"Fail instead of warning when the issued certificate lifetime differs from --duration.")
+	cmd.Flags().StringVar(&options.approval, "approval", certificator.ApprovalSelf,
+		"Who approves the CSR, one of: self, external. External mode waits for a human or an approver controller.")
+	cmd.Flags().DurationVar(&options.timeout, "timeout", certificator.DefaultTimeout,
+		"How long to wait for the CSR to be approved and issued.")
+	cmd.Flags().StringVar(&options.signerName, "signer-name", certificator.KubeAPIServerClientSignerName,
+		"Signer of the CSR, e.g. "+webhookServingSignerName+" served by certificator signer.")
+	addIssuerFlags(cmd, &options.issuer)
+	addCABundleFlags(cmd, &options.caBundle)
+	addNotifierFlags(cmd, &options.notify)
+	addVerifyEndpointFlags(cmd, &options.verify)
+	addRestartFlags(cmd, &options.restart)
+}
 
-	if err := cmd.MarkFlagRequired("service"); err != nil {
-		fmt.Println("`service` flag is required")
+// validate checks options shared by certify and commands that run certify
+func (o *CreateAndSignCertOptions) validate() error {
+	if err := certificator.ValidateDuration(o.duration); err != nil {
+		return err
+	}
+	if err := o.issuer.validate(); err != nil {
+		return err
+	}
+	if err := o.restart.validate(); err != nil {
+		return err
 	}
 
-	return cmd
+	return certificator.ValidateApproval(o.approval)
+}
+
+// csrArgs returns the arguments reproducing how CSRs are signed, shared by certify and the controllers
+func (o *CreateAndSignCertOptions) csrArgs() []string {
+	var args []string
+	if o.approval != "" && o.approval != certificator.ApprovalSelf {
+		args = append(args, "--approval="+o.approval)
+	}
+	if o.timeout != 0 && o.timeout != certificator.DefaultTimeout {
+		args = append(args, "--timeout="+o.timeout.String())
+	}
+	if o.signerName != "" && o.signerName != certificator.KubeAPIServerClientSignerName {
+		args = append(args, "--signer-name="+o.signerName)
+	}
+
+	return args
+}
+
+// args returns certify command line arguments reproducing the options
+func (o *CreateAndSignCertOptions) args() []string {
+	args := []string{
+		"--service=" + o.service,
+		"--namespace=" + o.namespace,
+		"--secret=" + o.secret,
+	}
+	if o.keepCSR {
+		args = append(args, "--keep-csr")
+	}
+	if o.duration != 0 && o.duration != certificator.DefaultDuration {
+		args = append(args, "--duration="+o.duration.String())
+	}
+	if o.strictDuration {
+		args = append(args, "--strict-duration")
+	}
+	args = append(args, o.csrArgs()...)
+	args = append(args, o.issuer.args()...)
+	args = append(args, o.caBundle.args()...)
+	args = append(args, o.notify.args()...)
+	args = append(args, o.verify.args()...)
+
+	return append(args, o.restart.args()...)
 }
@@ -4,8 +4,11 @@ import (
 	"bytes"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/spf13/cobra"
+
+	"github.com/ealebed/admission-webhook-certificator/pkg/certificator"
 )
 
 func TestNewCmdRoot(t *testing.T) {
@@ -176,3 +179,54 @@ func TestNewCmdRootCommandStructure(t *testing.T) {
 		t.Error("Expected certify command short description to mention 'K8S Secret'")
 	}
 }
+
+func TestCreateAndSignCertOptionsArgs(t *testing.T) {
+	options := CreateAndSignCertOptions{
+		service:        "webhook-svc",
+		namespace:      "webhook",
+		secret:         "webhook-certs",
+		keepCSR:        true,
+		duration:       24 * time.Hour,
+		strictDuration: true,
+	}
+
+	want := "--service=webhook-svc --namespace=webhook --secret=webhook-certs --keep-csr --duration=24h0m0s --strict-duration"
+	if got := strings.Join(options.args(), " "); got != want {
+		t.Errorf("Expected args '%s', got '%s'", want, got)
+	}
+}
+
+func TestCreateAndSignCertOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		options CreateAndSignCertOptions
+		wantErr bool
+	}{
+		{
+			name:    "self approval",
+			options: CreateAndSignCertOptions{duration: certificator.DefaultDuration, approval: "self"},
+		},
+		{
+			name:    "external approval",
+			options: CreateAndSignCertOptions{duration: certificator.DefaultDuration, approval: "external"},
+		},
+		{
+			name:    "unknown approval mode",
+			options: CreateAndSignCertOptions{duration: certificator.DefaultDuration, approval: "auto"},
+			wantErr: true,
+		},
+		{
+			name:    "duration below minimum",
+			options: CreateAndSignCertOptions{duration: time.Minute, approval: "self"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.options.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,437 @@
+/*
+Copyright © 2024 Yevhen Lebid ealebed@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/ealebed/admission-webhook-certificator/pkg/certificator"
+	"github.com/ealebed/admission-webhook-certificator/pkg/issuer"
+)
+
+const (
+	// caRotationAnnotation persists the progress of a CA rotation on the CA Secret
+	caRotationAnnotation = "certificator.ealebed.io/ca-rotation"
+
+	// CA Secret keys holding the next CA while it is staged and the previous CA while it is retired
+	nextCACertKey     = "next.crt"
+	nextCAKeyKey      = "next.key"
+	previousCACertKey = "previous.crt"
+
+	// rotationStaged: the next CA is trusted next to the current one, which still signs
+	rotationStaged = "Staged"
+	// rotationIssuing: the next CA signs, leaf certificates of the previous CA are re-issued
+	rotationIssuing = "Issuing"
+	// rotationRetiring: all leaf certificates are re-issued, the previous CA is trusted until the grace period ends
+	rotationRetiring = "Retiring"
+
+	defaultPropagationDelay = 2 * time.Minute
+	defaultRotationGrace    = 24 * time.Hour
+)
+
+// caRotation is the progress of a CA rotation, stored as JSON in caRotationAnnotation
+type caRotation struct {
+	Phase string    `json:"phase"`
+	Since time.Time `json:"since"`
+}
+
+// RotateCAOptions represents options for rotate-ca command
+type RotateCAOptions struct {
+	kubeconfig       string
+	caSecret         string
+	caBundle         CABundleOptions
+	validity         time.Duration
+	propagationDelay time.Duration
+	gracePeriod      time.Duration
+	wait             bool
+}
+
+// NewRotateCACmd returns new rotate-ca command
+func NewRotateCACmd() *cobra.Command {
+	options := RotateCAOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "rotate-ca",
+		Short: "Replace the signer CA in stages, without breaking webhooks which trust the current CA.",
+		Long: "This command rotates the CA stored in the signer CA Secret in stages. First a new CA is generated\n" +
+			"and trusted next to the current one in caBundles and trust targets. After --propagation-delay the new\n" +
+			"CA starts signing and leaf certificates of the current CA are re-issued. After --grace-period the\n" +
+			"previous CA is no longer trusted. Progress is stored in an annotation on the CA Secret, so every run\n" +
+			"continues an interrupted rotation and runs the steps which are due.",
+		Example: "rotate-ca [--ca-secret=webhook/certificator-ca --mutating-webhook-configuration=webhook-cfg --wait]",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runRotateCA(&options)
+		},
+	}
+
+	cmd.Flags().StringVarP(&options.kubeconfig, "kubeconfig", "k", "", "kubeconfig path")
+	cmd.Flags().StringVar(&options.caSecret, "ca-secret", "webhook/certificator-ca",
+		"Secret with CA certificate and key (tls.crt, tls.key), as namespace/name.")
+	addCABundleFlags(cmd, &options.caBundle)
+	cmd.Flags().DurationVar(&options.validity, "ca-validity", defaultCAValidity, "Lifetime of the new CA.")
+	cmd.Flags().DurationVar(&options.propagationDelay, "propagation-delay", defaultPropagationDelay,
+		"How long both CAs are trusted before the new CA starts signing, so trust targets catch up.")
+	cmd.Flags().DurationVar(&options.gracePeriod, "grace-period", defaultRotationGrace,
+		"How long the previous CA stays trusted after all leaf certificates were re-issued.")
+	cmd.Flags().BoolVar(&options.wait, "wait", false, "Wait for the rotation to complete instead of exiting when the next step is not due.")
+
+	return cmd
+}
+
+func runRotateCA(options *RotateCAOptions) error {
+	namespace, name, err := splitNamespacedName(options.caSecret)
+	if err != nil {
+		return err
+	}
+	cs, err := initK8sClient(options.kubeconfig)
+	if err != nil {
+		return err
+	}
+	dyn, err := initDynamicClient(options.kubeconfig)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	r := &caRotator{
+		client:           cs,
+		dynamic:          dyn,
+		recorder:         newEventRecorder(cs),
+		namespace:        namespace,
+		name:             name,
+		caBundle:         &options.caBundle,
+		validity:         options.validity,
+		propagationDelay: options.propagationDelay,
+		gracePeriod:      options.gracePeriod,
+	}
+	for {
+		next, err := r.step(ctx, time.Now())
+		if err != nil {
+			return err
+		}
+		if next == 0 {
+			log.Printf("CA secret %s/%s, status: Rotation completed", namespace, name)
+			return nil
+		}
+		if !options.wait {
+			log.Printf("CA secret %s/%s, status: Next rotation step is due at %s, run rotate-ca again then",
+				namespace, name, time.Now().Add(next).Format(time.RFC3339))
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(next):
+		}
+	}
+}
+
+// caRotator moves the CA Secret through the rotation phases
+type caRotator struct {
+	client           kubernetes.Interface
+	dynamic          dynamic.Interface
+	recorder         record.EventRecorder
+	namespace        string
+	name             string
+	caBundle         *CABundleOptions
+	validity         time.Duration
+	propagationDelay time.Duration
+	gracePeriod      time.Duration
+}
+
+// step runs the rotation steps which are due and returns how long to wait for the next one,
+// or zero when the rotation is completed
+func (r *caRotator) step(ctx context.Context, now time.Time) (time.Duration, error) {
+	secret, err := r.client.CoreV1().Secrets(r.namespace).Get(ctx, r.name, metav1.GetOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("get CA secret %s/%s: %w", r.namespace, r.name, err)
+	}
+
+	value, ok := secret.Annotations[caRotationAnnotation]
+	if !ok {
+		return r.stage(ctx, secret, now)
+	}
+	var rotation caRotation
+	if err := json.Unmarshal([]byte(value), &rotation); err != nil {
+		return 0, fmt.Errorf("CA secret %s/%s annotation %s: %w", r.namespace, r.name, caRotationAnnotation, err)
+	}
+
+	// an interrupted run may have stored the Secret without patching caBundles
+	if err := patchCABundles(ctx, r.client, r.dynamic, r.recorder, r.caBundle, caBundleFromSecret(secret)); err != nil {
+		return 0, err
+	}
+
+	switch rotation.Phase {
+	case rotationStaged:
+		if wait := rotation.Since.Add(r.propagationDelay).Sub(now); wait > 0 {
+			return wait, nil
+		}
+		return r.issue(ctx, secret, now)
+	case rotationIssuing:
+		return r.issue(ctx, secret, now)
+	case rotationRetiring:
+		if wait := rotation.Since.Add(r.gracePeriod).Sub(now); wait > 0 {
+			return wait, nil
+		}
+		return r.retire(ctx, secret)
+	default:
+		return 0, fmt.Errorf("CA secret %s/%s: unknown rotation phase %q", r.namespace, r.name, rotation.Phase)
+	}
+}
+
+// stage generates the next CA and trusts it next to the current one
+func (r *caRotator) stage(ctx context.Context, secret *corev1.Secret, now time.Time) (time.Duration, error) {
+	current, err := issuer.ParseCA(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		return 0, fmt.Errorf("CA secret %s/%s: %w", r.namespace, r.name, err)
+	}
+	certPEM, keyPEM, err := issuer.GenerateCA(current.Certificate.Subject.CommonName, r.validity)
+	if err != nil {
+		return 0, err
+	}
+
+	secret = secret.DeepCopy()
+	secret.Data[nextCACertKey] = certPEM
+	secret.Data[nextCAKeyKey] = keyPEM
+	secret.Data[caCertKey] = append(append([]byte{}, certPEM...), current.CertificatePEM...)
+	if err := r.update(ctx, secret, &caRotation{Phase: rotationStaged, Since: now}); err != nil {
+		return 0, err
+	}
+	log.Printf("CA secret %s/%s, status: Next CA staged, trusted next to the current CA", r.namespace, r.name)
+
+	if err := patchCABundles(ctx, r.client, r.dynamic, r.recorder, r.caBundle, secret.Data[caCertKey]); err != nil {
+		return 0, err
+	}
+
+	return r.propagationDelay, nil
+}
+
+// issue makes the next CA sign and re-issues leaf certificates of the previous CA
+func (r *caRotator) issue(ctx context.Context, secret *corev1.Secret, now time.Time) (time.Duration, error) {
+	secret = secret.DeepCopy()
+	if next, ok := secret.Data[nextCACertKey]; ok {
+		secret.Data[previousCACertKey] = secret.Data[corev1.TLSCertKey]
+		secret.Data[corev1.TLSCertKey] = next
+		secret.Data[corev1.TLSPrivateKeyKey] = secret.Data[nextCAKeyKey]
+		delete(secret.Data, nextCACertKey)
+		delete(secret.Data, nextCAKeyKey)
+		if err := r.update(ctx, secret, &caRotation{Phase: rotationIssuing, Since: now}); err != nil {
+			return 0, err
+		}
+		log.Printf("CA secret %s/%s, status: Next CA is signing", r.namespace, r.name)
+	}
+
+	ca, err := issuer.ParseCA(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		return 0, fmt.Errorf("CA secret %s/%s: %w", r.namespace, r.name, err)
+	}
+	previous, err := certificator.ParseCertificates(secret.Data[previousCACertKey])
+	if err != nil {
+		return 0, fmt.Errorf("CA secret %s/%s: %w", r.namespace, r.name, err)
+	}
+	// re-issued leaf certificates trust both CAs until the previous one retires, as the caBundles
+	// injected from them must accept the certificates webhook pods serve until they reload
+	bundle := append(append([]byte{}, ca.CertificatePEM...), secret.Data[previousCACertKey]...)
+	if err := reissueLeafCertificates(ctx, r.client, ca, previous, bundle); err != nil {
+		return 0, err
+	}
+
+	if err := r.update(ctx, secret, &caRotation{Phase: rotationRetiring, Since: now}); err != nil {
+		return 0, err
+	}
+	log.Printf("CA secret %s/%s, status: Leaf certificates re-issued, previous CA retiring", r.namespace, r.name)
+
+	return r.gracePeriod, nil
+}
+
+// retire stops trusting the previous CA and completes the rotation
+func (r *caRotator) retire(ctx context.Context, secret *corev1.Secret) (time.Duration, error) {
+	previous, err := certificator.ParseCertificates(secret.Data[previousCACertKey])
+	if err != nil {
+		return 0, fmt.Errorf("CA secret %s/%s: %w", r.namespace, r.name, err)
+	}
+	if err := retireLeafTrust(ctx, r.client, secret.Data[corev1.TLSCertKey], previous); err != nil {
+		return 0, err
+	}
+
+	secret = secret.DeepCopy()
+	delete(secret.Data, previousCACertKey)
+	delete(secret.Data, caCertKey)
+	if err := r.update(ctx, secret, nil); err != nil {
+		return 0, err
+	}
+	log.Printf("CA secret %s/%s, status: Previous CA retired", r.namespace, r.name)
+
+	if err := patchCABundles(ctx, r.client, r.dynamic, r.recorder, r.caBundle, caBundleFromSecret(secret)); err != nil {
+		return 0, err
+	}
+
+	return 0, nil
+}
+
+// update stores the Secret with the rotation progress, a nil rotation removes the annotation
+func (r *caRotator) update(ctx context.Context, secret *corev1.Secret, rotation *caRotation) error {
+	if rotation == nil {
+		delete(secret.Annotations, caRotationAnnotation)
+	} else {
+		value, err := json.Marshal(rotation)
+		if err != nil {
+			return err
+		}
+		if secret.Annotations == nil {
+			secret.Annotations = map[string]string{}
+		}
+		secret.Annotations[caRotationAnnotation] = string(value)
+	}
+
+	updated, err := r.client.CoreV1().Secrets(r.namespace).Update(ctx, secret, metav1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("update CA secret %s/%s: %w", r.namespace, r.name, err)
+	}
+	secret.ResourceVersion = updated.ResourceVersion
+
+	return nil
+}
+
+// reissueLeafCertificates replaces certificates signed by a previous CA in Secrets managed by
+// certify with certificates signed by ca, keeping their lifetime. Their ca.crt is set to bundle,
+// which holds both CAs while the previous one retires.
+func reissueLeafCertificates(ctx context.Context, cs kubernetes.Interface, ca *issuer.CertificateAuthority,
+	previous []*x509.Certificate, bundle []byte) error {
+	secrets, err := listManagedSecrets(ctx, cs)
+	if err != nil {
+		return err
+	}
+
+	for i := range secrets {
+		secret := &secrets[i]
+		certs, err := certificator.ParseCertificates(secret.Data[corev1.TLSCertKey])
+		if err != nil || len(certs) == 0 || certs[0].IsCA || !signedByAny(certs[0], previous) {
+			continue
+		}
+		service, namespace := secret.Labels[certificator.ServiceLabel], secret.Labels[certificator.NamespaceLabel]
+		if service == "" || namespace == "" {
+			log.Printf("Secret %s/%s, status: Signed by previous CA but has no owner labels, skipping", secret.Namespace, secret.Name)
+			continue
+		}
+
+		requestPEM, keyPEM, _, err := certificator.GenerateRequest(service, namespace, leafRequest(certs[0]))
+		if err != nil {
+			return err
+		}
+		block, _ := pem.Decode(requestPEM)
+		request, err := x509.ParseCertificateRequest(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("x509.ParseCertificateRequest: %w", err)
+		}
+		certPEM, err := ca.Sign(request, issuer.ServerUsages, certs[0].NotAfter.Sub(certs[0].NotBefore)-issuer.Backdate)
+		if err != nil {
+			return err
+		}
+
+		secret.Data[corev1.TLSCertKey] = certPEM
+		secret.Data[corev1.TLSPrivateKeyKey] = keyPEM
+		secret.Data[caCertKey] = bundle
+		if _, err := cs.CoreV1().Secrets(secret.Namespace).Update(ctx, secret, metav1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("update secret %s/%s: %w", secret.Namespace, secret.Name, err)
+		}
+		log.Printf("Secret %s/%s, status: Certificate re-issued by the next CA", secret.Namespace, secret.Name)
+	}
+
+	return nil
+}
+
+// retireLeafTrust makes leaf certificate Secrets which still trust a previous CA trust caPEM only
+func retireLeafTrust(ctx context.Context, cs kubernetes.Interface, caPEM []byte, previous []*x509.Certificate) error {
+	secrets, err := listManagedSecrets(ctx, cs)
+	if err != nil {
+		return err
+	}
+
+	for i := range secrets {
+		secret := &secrets[i]
+		certs, err := certificator.ParseCertificates(secret.Data[corev1.TLSCertKey])
+		if err != nil || len(certs) == 0 || certs[0].IsCA {
+			continue
+		}
+		trusted, err := certificator.ParseCertificates(secret.Data[caCertKey])
+		if err != nil || !slices.ContainsFunc(trusted, func(cert *x509.Certificate) bool {
+			return slices.ContainsFunc(previous, cert.Equal)
+		}) {
+			continue
+		}
+
+		secret.Data[caCertKey] = caPEM
+		if _, err := cs.CoreV1().Secrets(secret.Namespace).Update(ctx, secret, metav1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("update secret %s/%s: %w", secret.Namespace, secret.Name, err)
+		}
+		log.Printf("Secret %s/%s, status: Previous CA no longer trusted", secret.Namespace, secret.Name)
+	}
+
+	return nil
+}
+
+// listManagedSecrets returns the Secrets managed by certify in all namespaces
+func listManagedSecrets(ctx context.Context, cs kubernetes.Interface) ([]corev1.Secret, error) {
+	secrets, err := cs.CoreV1().Secrets(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(labels.Set{certificator.ManagedByLabel: certificator.ManagedByValue}).String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list managed secrets: %w", err)
+	}
+
+	return secrets.Items, nil
+}
+
+// leafRequest returns the request re-issuing cert, with its extra DNS names, IP addresses and key algorithm
+func leafRequest(cert *x509.Certificate) *certificator.Request {
+	request := &certificator.Request{DNSNames: cert.DNSNames, IPAddresses: cert.IPAddresses, KeyAlgorithm: certificator.KeyAlgorithmRSA}
+	if cert.PublicKeyAlgorithm == x509.ECDSA {
+		request.KeyAlgorithm = certificator.KeyAlgorithmECDSA
+	}
+
+	return request
+}
+
+// signedByAny reports whether cert is signed by one of the CAs
+func signedByAny(cert *x509.Certificate, cas []*x509.Certificate) bool {
+	for _, ca := range cas {
+		if cert.CheckSignatureFrom(ca) == nil {
+			return true
+		}
+	}
+
+	return false
+}
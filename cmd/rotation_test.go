@@ -0,0 +1,187 @@
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/ealebed/admission-webhook-certificator/pkg/certificator"
+	"github.com/ealebed/admission-webhook-certificator/pkg/issuer"
+)
+
+func TestCARotatorStep(t *testing.T) {
+	caCertPEM, caKeyPEM, err := issuer.GenerateCA("test-ca", 24*time.Hour)
+	if err != nil {
+		t.Fatalf("issuer.GenerateCA() error = %v", err)
+	}
+	oldCA, err := issuer.ParseCA(caCertPEM, caKeyPEM)
+	if err != nil {
+		t.Fatalf("issuer.ParseCA() error = %v", err)
+	}
+	requestPEM, leafKeyPEM, _, err := certificator.GenerateRequest("webhook-svc", "webhook", nil)
+	if err != nil {
+		t.Fatalf("GenerateRequest() error = %v", err)
+	}
+	block, _ := pem.Decode(requestPEM)
+	request, _ := x509.ParseCertificateRequest(block.Bytes)
+	leafPEM, err := oldCA.Sign(request, issuer.ServerUsages, time.Hour)
+	if err != nil {
+		t.Fatalf("sign() error = %v", err)
+	}
+	labels, _ := certificator.OwnerLabels("webhook-svc", "webhook", "webhook-certs")
+
+	cs := fake.NewClientset(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "certificator-ca", Namespace: "webhook",
+				Labels: map[string]string{certificator.ManagedByLabel: certificator.ManagedByValue},
+			},
+			Data: map[string][]byte{corev1.TLSCertKey: caCertPEM, corev1.TLSPrivateKeyKey: caKeyPEM},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "webhook-certs", Namespace: "webhook", Labels: labels},
+			Data:       map[string][]byte{corev1.TLSCertKey: leafPEM, corev1.TLSPrivateKeyKey: leafKeyPEM},
+		},
+		&admissionv1.MutatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: "webhook-cfg"},
+			Webhooks:   []admissionv1.MutatingWebhook{{Name: "a.webhook.io"}},
+		},
+	)
+	r := &caRotator{
+		client:           cs,
+		recorder:         record.NewFakeRecorder(100),
+		namespace:        "webhook",
+		name:             "certificator-ca",
+		caBundle:         &CABundleOptions{mutatingWebhooks: []string{"webhook-cfg"}},
+		validity:         24 * time.Hour,
+		propagationDelay: time.Minute,
+		gracePeriod:      time.Hour,
+	}
+	start := time.Now()
+
+	tests := []struct {
+		name         string
+		at           time.Duration
+		wantNext     time.Duration
+		wantPhase    string
+		wantBundle   int
+		wantReissued bool
+		wantTrusted  int
+	}{
+		{name: "stages next CA", wantNext: time.Minute, wantPhase: rotationStaged, wantBundle: 2},
+		{name: "waits for propagation", at: 30 * time.Second, wantNext: 30 * time.Second, wantPhase: rotationStaged, wantBundle: 2},
+		{
+			name: "re-issues leaf certificates", at: time.Minute, wantNext: time.Hour, wantPhase: rotationRetiring,
+			wantBundle: 2, wantReissued: true, wantTrusted: 2,
+		},
+		{
+			name: "waits for grace period", at: 2 * time.Minute, wantNext: 59 * time.Minute, wantPhase: rotationRetiring,
+			wantBundle: 2, wantReissued: true, wantTrusted: 2,
+		},
+		{name: "retires previous CA", at: 2 * time.Hour, wantBundle: 1, wantReissued: true, wantTrusted: 1},
+	}
+
+	// steps build on each other, like consecutive runs of rotate-ca
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next, err := r.step(context.TODO(), start.Add(tt.at))
+			if err != nil {
+				t.Fatalf("step() error = %v", err)
+			}
+			if next != tt.wantNext {
+				t.Errorf("Expected next step in %s, got %s", tt.wantNext, next)
+			}
+
+			secret, _ := cs.CoreV1().Secrets("webhook").Get(context.TODO(), "certificator-ca", metav1.GetOptions{})
+			var rotation caRotation
+			if value, ok := secret.Annotations[caRotationAnnotation]; ok {
+				if err := json.Unmarshal([]byte(value), &rotation); err != nil {
+					t.Fatalf("Expected valid annotation, got %v", err)
+				}
+			}
+			if rotation.Phase != tt.wantPhase {
+				t.Errorf("Expected phase '%s', got '%s'", tt.wantPhase, rotation.Phase)
+			}
+
+			config, _ := cs.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(context.TODO(), "webhook-cfg", metav1.GetOptions{})
+			if certs, _ := certificator.ParseCertificates(config.Webhooks[0].ClientConfig.CABundle); len(certs) != tt.wantBundle {
+				t.Errorf("Expected %d CAs in caBundle, got %d", tt.wantBundle, len(certs))
+			}
+
+			leaf, _ := cs.CoreV1().Secrets("webhook").Get(context.TODO(), "webhook-certs", metav1.GetOptions{})
+			certs, _ := certificator.ParseCertificates(leaf.Data[corev1.TLSCertKey])
+			if reissued := certs[0].CheckSignatureFrom(oldCA.Certificate) != nil; reissued != tt.wantReissued {
+				t.Errorf("Expected leaf certificate re-issued: %v, got %v", tt.wantReissued, reissued)
+			}
+			if trusted, _ := certificator.ParseCertificates(leaf.Data[caCertKey]); len(trusted) != tt.wantTrusted {
+				t.Errorf("Expected %d CAs in leaf %s, got %d", tt.wantTrusted, caCertKey, len(trusted))
+			}
+		})
+	}
+}
+
+func TestReissueLeafCertificates(t *testing.T) {
+	oldCA, nextCA := newTestCA(t, 24*time.Hour), newTestCA(t, 24*time.Hour)
+	requestPEM, keyPEM, _, err := certificator.GenerateRequest("webhook-svc", "webhook", &certificator.Request{
+		DNSNames:     []string{"webhook.example.io"},
+		IPAddresses:  []net.IP{net.ParseIP("10.0.0.10")},
+		KeyAlgorithm: certificator.KeyAlgorithmECDSA,
+	})
+	if err != nil {
+		t.Fatalf("GenerateRequest() error = %v", err)
+	}
+	block, _ := pem.Decode(requestPEM)
+	request, _ := x509.ParseCertificateRequest(block.Bytes)
+	leafPEM, err := oldCA.Sign(request, issuer.ServerUsages, time.Hour)
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+	labels, _ := certificator.OwnerLabels("webhook-svc", "webhook", "webhook-certs")
+	cs := fake.NewClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "webhook-certs", Namespace: "webhook", Labels: labels},
+		Data:       map[string][]byte{corev1.TLSCertKey: leafPEM, corev1.TLSPrivateKeyKey: keyPEM},
+	})
+
+	bundle := append(append([]byte{}, nextCA.CertificatePEM...), oldCA.CertificatePEM...)
+	if err = reissueLeafCertificates(context.TODO(), cs, nextCA, []*x509.Certificate{oldCA.Certificate}, bundle); err != nil {
+		t.Fatalf("reissueLeafCertificates() error = %v", err)
+	}
+
+	secret, _ := cs.CoreV1().Secrets("webhook").Get(context.TODO(), "webhook-certs", metav1.GetOptions{})
+	leaf, err := certificator.LeafCertificate(secret.Data[corev1.TLSCertKey])
+	if err != nil {
+		t.Fatalf("LeafCertificate() error = %v", err)
+	}
+	if err = leaf.CheckSignatureFrom(nextCA.Certificate); err != nil {
+		t.Errorf("Expected leaf certificate signed by the next CA, got %v", err)
+	}
+	wantDNSNames := []string{"webhook-svc", "webhook-svc.webhook", "webhook-svc.webhook.svc", "webhook.example.io"}
+	if !slices.Equal(leaf.DNSNames, wantDNSNames) {
+		t.Errorf("Expected DNS names %v, got %v", wantDNSNames, leaf.DNSNames)
+	}
+	if len(leaf.IPAddresses) != 1 || !leaf.IPAddresses[0].Equal(net.ParseIP("10.0.0.10")) {
+		t.Errorf("Expected IP address 10.0.0.10, got %v", leaf.IPAddresses)
+	}
+	if leaf.PublicKeyAlgorithm != x509.ECDSA {
+		t.Errorf("Expected ECDSA key, got %s", leaf.PublicKeyAlgorithm)
+	}
+	if _, err = tls.X509KeyPair(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey]); err != nil {
+		t.Errorf("Expected the stored key to match the certificate, got %v", err)
+	}
+	trusted, _ := certificator.ParseCertificates(secret.Data[caCertKey])
+	if !slices.ContainsFunc(trusted, oldCA.Certificate.Equal) || !slices.ContainsFunc(trusted, nextCA.Certificate.Equal) {
+		t.Errorf("Expected %s to trust the previous and the next CA while issuing, got %d CAs", caCertKey, len(trusted))
+	}
+}
@@ -0,0 +1,148 @@
+/*
+Copyright © 2024 Yevhen Lebid ealebed@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"crypto/x509"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/ealebed/admission-webhook-certificator/pkg/certificator"
+)
+
+const (
+	// runMetricsJob is the job label of run results pushed to a Pushgateway
+	runMetricsJob = "certificator"
+	// runMetricsPushTimeout bounds pushing the run result
+	runMetricsPushTimeout = 10 * time.Second
+)
+
+// RunMetricsOptions represents where the result of a one-shot run is published
+type RunMetricsOptions struct {
+	textfile string
+	pushURL  string
+}
+
+// addRunMetricsFlags registers flags publishing the result of a one-shot run, which can't be scraped
+func addRunMetricsFlags(cmd *cobra.Command, options *RunMetricsOptions) {
+	cmd.Flags().StringVar(&options.textfile, "metrics-textfile", "",
+		"Write the run result to this file in the node-exporter textfile collector format.")
+	cmd.Flags().StringVar(&options.pushURL, "metrics-push-url", "",
+		"Push the run result to the Pushgateway at this URL, grouped by namespace and secret.")
+}
+
+// enabled reports whether the run result is published anywhere
+func (o *RunMetricsOptions) enabled() bool {
+	return o.textfile != "" || o.pushURL != ""
+}
+
+// runResult is the outcome of one certify run
+type runResult struct {
+	namespace   string
+	secret      string
+	success     bool
+	err         error
+	duration    time.Duration
+	finished    time.Time
+	certificate *x509.Certificate
+}
+
+// gatherer returns the metrics of the result, labeled with labels
+func (r *runResult) gatherer(labels prometheus.Labels) prometheus.Gatherer {
+	registry := prometheus.NewRegistry()
+	registerer := prometheus.WrapRegistererWith(labels, registry)
+
+	success := 0.0
+	if r.success {
+		success = 1
+	}
+	gauge(registerer, "run_success", "Whether the last certify run succeeded.", nil).Set(success)
+	gauge(registerer, "run_duration_seconds", "Duration of the last certify run.", nil).Set(r.duration.Seconds())
+	gauge(registerer, "run_timestamp_seconds", "When the last certify run finished, in seconds since the epoch.", nil).
+		Set(float64(r.finished.Unix()))
+	if r.certificate != nil {
+		gauge(registerer, "certificate_not_after_seconds", "Expiry of the certificate stored by the last run, in seconds since the epoch.", nil).
+			Set(float64(r.certificate.NotAfter.Unix()))
+		gauge(registerer, "certificate_info", "Serial of the certificate stored by the last run, always 1.",
+			prometheus.Labels{"serial": r.certificate.SerialNumber.Text(16)}).Set(1)
+	}
+
+	return registry
+}
+
+func gauge(registerer prometheus.Registerer, name, help string, labels prometheus.Labels) prometheus.Gauge {
+	g := prometheus.NewGauge(prometheus.GaugeOpts{Namespace: metricsNamespace, Name: name, Help: help, ConstLabels: labels})
+	registerer.MustRegister(g)
+
+	return g
+}
+
+// publishRunResult writes the result to the textfile and pushes it to the Pushgateway, failures
+// are logged only, since the certificate is issued or not regardless of them
+func publishRunResult(ctx context.Context, options *RunMetricsOptions, result *runResult) {
+	if options.textfile != "" {
+		// the textfile is scraped from node-exporter, so it carries namespace and secret itself
+		gatherer := result.gatherer(prometheus.Labels{"namespace": result.namespace, "secret": result.secret})
+		if err := prometheus.WriteToTextfile(options.textfile, gatherer); err != nil {
+			log.Printf("Metrics textfile %s - error occurred, detail: %v", options.textfile, err)
+		} else {
+			log.Printf("Metrics textfile %s, status: Written", options.textfile)
+		}
+	}
+
+	if options.pushURL != "" {
+		pushCtx, cancel := context.WithTimeout(ctx, runMetricsPushTimeout)
+		defer cancel()
+		// a Push replaces the previous result of the same namespace and secret
+		err := push.New(options.pushURL, runMetricsJob).
+			Client(&http.Client{Timeout: runMetricsPushTimeout}).
+			Grouping("namespace", result.namespace).
+			Grouping("secret", result.secret).
+			Gatherer(result.gatherer(nil)).
+			PushContext(pushCtx)
+		if err != nil {
+			log.Printf("Metrics push %s - error occurred, detail: %v", options.pushURL, err)
+		} else {
+			log.Printf("Metrics push %s, status: Pushed", options.pushURL)
+		}
+	}
+}
+
+// storedCertificate returns the certificate stored in the Secret of options, nil when unavailable
+func storedCertificate(ctx context.Context, cs kubernetes.Interface, options *CreateAndSignCertOptions) *x509.Certificate {
+	secret, err := cs.CoreV1().Secrets(options.namespace).Get(ctx, options.secret, metav1.GetOptions{})
+	if err != nil {
+		log.Printf("Secret %s/%s - error occurred, detail: %v", options.namespace, options.secret, err)
+		return nil
+	}
+	cert, err := certificator.LeafCertificate(secret.Data[corev1.TLSCertKey])
+	if err != nil {
+		log.Printf("Secret %s/%s - error occurred, detail: %v", options.namespace, options.secret, err)
+		return nil
+	}
+
+	return cert
+}
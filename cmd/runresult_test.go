@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestPublishRunResult(t *testing.T) {
+	ca := newTestCA(t, 24*time.Hour)
+	secret := newIssuedSecret(t, ca, &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "webhook-svc", Namespace: "webhook"}},
+		"webhook-certs", 12*time.Hour)
+	cert := storedCertificate(context.TODO(), fake.NewClientset(secret), &CreateAndSignCertOptions{namespace: "webhook", secret: "webhook-certs"})
+	if cert == nil {
+		t.Fatal("Expected stored certificate")
+	}
+
+	tests := []struct {
+		name      string
+		result    *runResult
+		wantLines []string
+		skipLines []string
+	}{
+		{
+			name: "publishes issued certificate",
+			result: &runResult{
+				namespace: "webhook", secret: "webhook-certs", success: true,
+				duration: 1500 * time.Millisecond, finished: time.Unix(1700000000, 0), certificate: cert,
+			},
+			wantLines: []string{
+				`certificator_run_success{namespace="webhook",secret="webhook-certs"} 1`,
+				`certificator_run_duration_seconds{namespace="webhook",secret="webhook-certs"} 1.5`,
+				`certificator_run_timestamp_seconds{namespace="webhook",secret="webhook-certs"} 1.7e+09`,
+				`certificator_certificate_info{namespace="webhook",secret="webhook-certs",serial="` + cert.SerialNumber.Text(16) + `"} 1`,
+				`certificator_certificate_not_after_seconds{namespace="webhook",secret="webhook-certs"}`,
+			},
+		},
+		{
+			name:      "publishes failed run",
+			result:    &runResult{namespace: "webhook", secret: "webhook-certs", duration: time.Second, finished: time.Unix(1700000000, 0)},
+			wantLines: []string{`certificator_run_success{namespace="webhook",secret="webhook-certs"} 0`},
+			skipLines: []string{"certificator_certificate_"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var method, path string
+			var body []byte
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				method, path = r.Method, r.URL.Path
+				body, _ = io.ReadAll(r.Body)
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer server.Close()
+
+			options := &RunMetricsOptions{textfile: filepath.Join(t.TempDir(), "certificator.prom"), pushURL: server.URL}
+			publishRunResult(context.TODO(), options, tt.result)
+
+			data, err := os.ReadFile(options.textfile)
+			if err != nil {
+				t.Fatalf("os.ReadFile() error = %v", err)
+			}
+			for _, line := range tt.wantLines {
+				if !strings.Contains(string(data), line) {
+					t.Errorf("Expected textfile to contain %q, got:\n%s", line, data)
+				}
+			}
+			for _, line := range tt.skipLines {
+				if strings.Contains(string(data), line) {
+					t.Errorf("Expected textfile without %q, got:\n%s", line, data)
+				}
+			}
+
+			// the grouping labels follow the job in any order
+			if method != http.MethodPut || !strings.HasPrefix(path, "/metrics/job/certificator/") ||
+				!strings.Contains(path, "/namespace/webhook") || !strings.Contains(path, "/secret/webhook-certs") {
+				t.Errorf("Unexpected push %s %s", method, path)
+			}
+			if !strings.Contains(string(body), "certificator_run_success") || strings.Contains(string(body), `namespace="webhook"`) {
+				t.Errorf("Expected pushed run result grouped by namespace and secret, got %q", body)
+			}
+		})
+	}
+}
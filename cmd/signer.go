@@ -0,0 +1,300 @@
+/*
+Copyright © 2024 Yevhen Lebid ealebed@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/spf13/cobra"
+	certv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	certlisters "k8s.io/client-go/listers/certificates/v1"
+
+	"github.com/ealebed/admission-webhook-certificator/pkg/certificator"
+	"github.com/ealebed/admission-webhook-certificator/pkg/issuer"
+)
+
+const (
+	// webhookServingSignerName is the signer implemented by certificator signer
+	webhookServingSignerName = "certificator.ealebed.io/webhook-serving"
+
+	signerFailedReason = "SignerValidationFailure"
+
+	// defaultCAValidity is the lifetime of CAs generated by certificator
+	defaultCAValidity = 10 * 365 * 24 * time.Hour
+)
+
+// SignerOptions represents options for signer command
+type SignerOptions struct {
+	controller  ControllerOptions
+	signerName  string
+	caSecret    string
+	createCA    bool
+	maxDuration time.Duration
+	trust       TrustOptions
+}
+
+// NewSignerCmd returns new signer command
+func NewSignerCmd() *cobra.Command {
+	options := SignerOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "signer",
+		Short: "Run a controller which signs approved webhook serving CSRs with a CA from a Secret.",
+		Long: "This command watches approved CertificateSigningRequests of its signer name, signs them\n" +
+			"with the CA certificate and key stored in a Secret and writes the certificate into the CSR\n" +
+			"status, followed by the CA certificate. Malformed requests are marked as Failed.",
+		Example: "signer [--ca-secret=webhook/certificator-ca --create-ca]",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runSigner(&options)
+		},
+	}
+
+	addControllerFlags(cmd, &options.controller, "certificator-signer")
+	cmd.Flags().StringVar(&options.signerName, "signer-name", webhookServingSignerName, "Signer name handled by this controller.")
+	cmd.Flags().StringVar(&options.caSecret, "ca-secret", "webhook/certificator-ca",
+		"Secret with CA certificate and key (tls.crt, tls.key), as namespace/name.")
+	cmd.Flags().BoolVar(&options.createCA, "create-ca", false, "Generate a self-signed CA when the CA Secret does not exist.")
+	cmd.Flags().DurationVar(&options.maxDuration, "max-duration", certificator.DefaultDuration,
+		"Lifetime of issued certificates, shorter expirationSeconds of a CSR are honored.")
+	addTrustFlags(cmd, &options.trust)
+
+	return cmd
+}
+
+func runSigner(options *SignerOptions) error {
+	namespace, name, err := splitNamespacedName(options.caSecret)
+	if err != nil {
+		return err
+	}
+	cs, err := initK8sClient(options.controller.kubeconfig)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	if err := ensureCASecret(ctx, cs, namespace, name, options.signerName, options.createCA); err != nil {
+		return err
+	}
+	trustControllers, trustFactories, err := newTrustControllers(cs, &options.trust, &options.controller,
+		options.signerName, namespace, name)
+	if err != nil {
+		return err
+	}
+
+	factory := informers.NewSharedInformerFactory(cs, options.controller.resync)
+	s := &signer{
+		client:      cs,
+		csrLister:   factory.Certificates().V1().CertificateSigningRequests().Lister(),
+		signerName:  options.signerName,
+		caNamespace: namespace,
+		caName:      name,
+		maxDuration: options.maxDuration,
+	}
+	c := newController("signer", s.sync)
+	err = c.watch(factory.Certificates().V1().CertificateSigningRequests().Informer(), func(obj interface{}) []string {
+		if csr, ok := obj.(*certv1.CertificateSigningRequest); ok && s.pending(csr) {
+			return []string{csr.Name}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	controllers := append([]*controller{c}, trustControllers...)
+
+	return runLeaderElected(ctx, cs, &options.controller, controllers, func(ctx context.Context) {
+		factory.Start(ctx.Done())
+		for _, f := range trustFactories {
+			f.Start(ctx.Done())
+		}
+		runControllers(ctx, options.controller.workers, controllers...)
+	})
+}
+
+// ensureCASecret checks that the CA Secret exists, and generates a self-signed CA when asked to
+func ensureCASecret(ctx context.Context, cs kubernetes.Interface, namespace, name, signerName string, create bool) error {
+	_, err := cs.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err == nil || !apierrors.IsNotFound(err) || !create {
+		return err
+	}
+
+	log.Printf("CA secret %s/%s, status: Not exists, generating self-signed CA", namespace, name)
+	certPEM, keyPEM, err := issuer.GenerateCA(signerName, defaultCAValidity)
+	if err != nil {
+		return err
+	}
+	_, err = cs.CoreV1().Secrets(namespace).Create(ctx, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{certificator.ManagedByLabel: certificator.ManagedByValue},
+		},
+		Type: corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       certPEM,
+			corev1.TLSPrivateKeyKey: keyPEM,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return err
+	}
+	log.Printf("CA secret %s/%s, status: Created", namespace, name)
+
+	return nil
+}
+
+// invalidRequestError marks CSRs which can never be signed
+type invalidRequestError struct {
+	message string
+}
+
+func (e *invalidRequestError) Error() string {
+	return e.message
+}
+
+// signer issues certificates for approved CSRs of its signer name
+type signer struct {
+	client      kubernetes.Interface
+	csrLister   certlisters.CertificateSigningRequestLister
+	signerName  string
+	caNamespace string
+	caName      string
+	maxDuration time.Duration
+}
+
+// pending reports whether the CSR is approved for this signer and still awaits a certificate
+func (s *signer) pending(csr *certv1.CertificateSigningRequest) bool {
+	if csr.Spec.SignerName != s.signerName || len(csr.Status.Certificate) > 0 {
+		return false
+	}
+
+	approved := false
+	for _, condition := range csr.Status.Conditions {
+		switch condition.Type {
+		case certv1.CertificateDenied, certv1.CertificateFailed:
+			return false
+		case certv1.CertificateApproved:
+			approved = condition.Status == corev1.ConditionTrue
+		}
+	}
+
+	return approved
+}
+
+func (s *signer) sync(ctx context.Context, name string) error {
+	csr, err := s.csrLister.Get(name)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !s.pending(csr) {
+		return nil
+	}
+
+	ca, err := s.loadCA(ctx)
+	if err != nil {
+		return err
+	}
+
+	csr = csr.DeepCopy()
+	certPEM, err := s.sign(ca, csr)
+	var invalid *invalidRequestError
+	switch {
+	case errors.As(err, &invalid):
+		csr.Status.Conditions = append(csr.Status.Conditions, certv1.CertificateSigningRequestCondition{
+			Type:           certv1.CertificateFailed,
+			Status:         corev1.ConditionTrue,
+			Reason:         signerFailedReason,
+			Message:        invalid.message,
+			LastUpdateTime: metav1.Now(),
+		})
+	case err != nil:
+		return err
+	default:
+		csr.Status.Certificate = append(certPEM, ca.CertificatePEM...)
+	}
+
+	if _, err := s.client.CertificatesV1().CertificateSigningRequests().UpdateStatus(ctx, csr, metav1.UpdateOptions{}); err != nil {
+		return err
+	}
+	if invalid != nil {
+		log.Printf("Certificate signing request %s, status: Failed, %s", csr.Name, invalid.message)
+	} else {
+		log.Printf("Certificate signing request %s, status: Signed", csr.Name)
+	}
+
+	return nil
+}
+
+func (s *signer) loadCA(ctx context.Context) (*issuer.CertificateAuthority, error) {
+	secret, err := s.client.CoreV1().Secrets(s.caNamespace).Get(ctx, s.caName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("get CA secret %s/%s: %w", s.caNamespace, s.caName, err)
+	}
+	ca, err := issuer.ParseCA(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		return nil, fmt.Errorf("CA secret %s/%s: %w", s.caNamespace, s.caName, err)
+	}
+
+	return ca, nil
+}
+
+// sign validates the CSR and issues a serving certificate for it
+func (s *signer) sign(ca *issuer.CertificateAuthority, csr *certv1.CertificateSigningRequest) ([]byte, error) {
+	block, _ := pem.Decode(csr.Spec.Request)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, &invalidRequestError{"request is not a PEM encoded CERTIFICATE REQUEST"}
+	}
+	request, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, &invalidRequestError{fmt.Sprintf("request cannot be parsed: %v", err)}
+	}
+	if err := request.CheckSignature(); err != nil {
+		return nil, &invalidRequestError{fmt.Sprintf("request signature is invalid: %v", err)}
+	}
+	if len(request.DNSNames) == 0 && len(request.IPAddresses) == 0 {
+		return nil, &invalidRequestError{"request has no DNS or IP subject alternative names"}
+	}
+	if !slices.Contains(csr.Spec.Usages, certv1.UsageServerAuth) {
+		return nil, &invalidRequestError{"usages must include server auth"}
+	}
+	if _, _, err := issuer.X509Usages(csr.Spec.Usages); err != nil {
+		return nil, &invalidRequestError{err.Error()}
+	}
+
+	duration := s.maxDuration
+	if csr.Spec.ExpirationSeconds != nil {
+		duration = min(duration, time.Duration(*csr.Spec.ExpirationSeconds)*time.Second)
+	}
+
+	return ca.Sign(request, csr.Spec.Usages, duration)
+}
@@ -0,0 +1,196 @@
+package cmd
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	certv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	certlisters "k8s.io/client-go/listers/certificates/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/ealebed/admission-webhook-certificator/pkg/certificator"
+	"github.com/ealebed/admission-webhook-certificator/pkg/issuer"
+)
+
+// newTestCA returns a freshly generated CA
+func newTestCA(t *testing.T, validity time.Duration) *issuer.CertificateAuthority {
+	t.Helper()
+
+	certPEM, keyPEM, err := issuer.GenerateCA("test-ca", validity)
+	if err != nil {
+		t.Fatalf("issuer.GenerateCA() error = %v", err)
+	}
+	ca, err := issuer.ParseCA(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("issuer.ParseCA() error = %v", err)
+	}
+
+	return ca
+}
+
+// newTestCertificatePEM returns a self-signed certificate valid until notAfter
+func newTestCertificatePEM(t *testing.T, notAfter time.Time) []byte {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "webhook-svc.webhook"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate: %v", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestEnsureCASecret(t *testing.T) {
+	tests := []struct {
+		name       string
+		create     bool
+		wantErr    bool
+		wantSecret bool
+	}{
+		{name: "creates CA when asked to", create: true, wantSecret: true},
+		{name: "fails without CA", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := fake.NewClientset()
+
+			err := ensureCASecret(context.TODO(), cs, "webhook", "certificator-ca", webhookServingSignerName, tt.create)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ensureCASecret() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			secret, err := cs.CoreV1().Secrets("webhook").Get(context.TODO(), "certificator-ca", metav1.GetOptions{})
+			if (err == nil) != tt.wantSecret {
+				t.Fatalf("Expected secret to exist: %v, got error %v", tt.wantSecret, err)
+			}
+			if tt.wantSecret {
+				if _, err := issuer.ParseCA(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey]); err != nil {
+					t.Errorf("Expected valid CA in secret, got %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestSignerSync(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey: %v", err)
+	}
+	approved := []certv1.CertificateSigningRequestCondition{{Type: certv1.CertificateApproved, Status: corev1.ConditionTrue}}
+	tenMinutes := int32(600)
+
+	newCSR := func(name string, request []byte, usages []certv1.KeyUsage,
+		conditions []certv1.CertificateSigningRequestCondition) *certv1.CertificateSigningRequest {
+		return &certv1.CertificateSigningRequest{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: certv1.CertificateSigningRequestSpec{
+				Request:           request,
+				Usages:            usages,
+				SignerName:        webhookServingSignerName,
+				ExpirationSeconds: &tenMinutes,
+			},
+			Status: certv1.CertificateSigningRequestStatus{Conditions: conditions},
+		}
+	}
+	request := newTestCSRPEM(t, key, &x509.CertificateRequest{DNSNames: []string{"webhook-svc.webhook.svc"}})
+	serverAuth := []certv1.KeyUsage{certv1.UsageDigitalSignature, certv1.UsageServerAuth}
+
+	tests := []struct {
+		name       string
+		csr        *certv1.CertificateSigningRequest
+		wantSigned bool
+		wantFailed string
+	}{
+		{
+			name:       "signs approved CSR",
+			csr:        newCSR("approved", request, serverAuth, approved),
+			wantSigned: true,
+		},
+		{
+			name: "ignores pending CSR",
+			csr:  newCSR("pending", request, serverAuth, nil),
+		},
+		{
+			name:       "fails malformed request",
+			csr:        newCSR("malformed", []byte("garbage"), serverAuth, approved),
+			wantFailed: "not a PEM encoded CERTIFICATE REQUEST",
+		},
+		{
+			name:       "fails client certificate request",
+			csr:        newCSR("client", request, []certv1.KeyUsage{certv1.UsageClientAuth}, approved),
+			wantFailed: "usages must include server auth",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := fake.NewClientset(tt.csr)
+			if err := ensureCASecret(context.TODO(), cs, "webhook", "certificator-ca", webhookServingSignerName, true); err != nil {
+				t.Fatalf("ensureCASecret() error = %v", err)
+			}
+			csrs := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			_ = csrs.Add(tt.csr)
+			s := &signer{
+				client:      cs,
+				csrLister:   certlisters.NewCertificateSigningRequestLister(csrs),
+				signerName:  webhookServingSignerName,
+				caNamespace: "webhook",
+				caName:      "certificator-ca",
+				maxDuration: certificator.DefaultDuration,
+			}
+
+			if err := s.sync(context.TODO(), tt.csr.Name); err != nil {
+				t.Fatalf("sync() error = %v", err)
+			}
+
+			csr, _ := cs.CertificatesV1().CertificateSigningRequests().Get(context.TODO(), tt.csr.Name, metav1.GetOptions{})
+			if tt.wantSigned {
+				certPEM, caPEM := certificator.SplitCertificateChain(csr.Status.Certificate)
+				if caPEM == nil {
+					t.Fatal("Expected CA certificate at the end of the chain")
+				}
+				if err := certificator.CheckLifetime(certPEM, 10*time.Minute, time.Now()); err != nil {
+					t.Errorf("Expected expirationSeconds to be honored, got %v", err)
+				}
+			} else if len(csr.Status.Certificate) > 0 {
+				t.Error("Expected no certificate to be issued")
+			}
+
+			var failed *certv1.CertificateSigningRequestCondition
+			for i := range csr.Status.Conditions {
+				if csr.Status.Conditions[i].Type == certv1.CertificateFailed {
+					failed = &csr.Status.Conditions[i]
+				}
+			}
+			if tt.wantFailed == "" && failed != nil {
+				t.Errorf("Expected no Failed condition, got %v", failed)
+			}
+			if tt.wantFailed != "" && (failed == nil || !strings.Contains(failed.Message, tt.wantFailed)) {
+				t.Errorf("Expected Failed condition with '%s', got %v", tt.wantFailed, failed)
+			}
+		})
+	}
+}
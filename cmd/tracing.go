@@ -0,0 +1,122 @@
+/*
+Copyright © 2024 Yevhen Lebid ealebed@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"k8s.io/client-go/rest"
+
+	"github.com/ealebed/admission-webhook-certificator/cmd/version"
+	"github.com/ealebed/admission-webhook-certificator/pkg/certificator"
+)
+
+// tracingShutdownTimeout bounds flushing spans on exit
+const tracingShutdownTimeout = 5 * time.Second
+
+// span attributes of the caBundle targets, the ones of the issuance pipeline are the certificator.Attr* keys
+const (
+	attrKind = attribute.Key("certificator.target.kind")
+	attrName = attribute.Key("certificator.target.name")
+)
+
+// TracingOptions represents where spans are exported to
+type TracingOptions struct {
+	endpoint      string
+	samplingRatio float64
+}
+
+// addTracingFlags registers flags exporting spans, shared by all commands
+func addTracingFlags(cmd *cobra.Command, options *TracingOptions) {
+	cmd.PersistentFlags().StringVar(&options.endpoint, "otlp-endpoint", "",
+		"OTLP/HTTP endpoint URL spans are exported to, e.g. http://otel-collector:4318. Tracing is off "+
+			"unless it is set here or by OTEL_EXPORTER_OTLP_ENDPOINT.")
+	cmd.PersistentFlags().Float64Var(&options.samplingRatio, "trace-sampling-ratio", 1,
+		"Ratio of issuances traced, traces started by a sampled parent are always kept.")
+}
+
+// enabled reports whether an OTLP endpoint is configured by flag or environment
+func (o *TracingOptions) enabled() bool {
+	return o.endpoint != "" || os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+}
+
+// startTracing installs a global tracer provider exporting spans over OTLP/HTTP, spans are
+// dropped by the default no-op provider when tracing isn't enabled
+func startTracing(ctx context.Context, options *TracingOptions) error {
+	if !options.enabled() {
+		return nil
+	}
+
+	var exporterOptions []otlptracehttp.Option
+	if options.endpoint != "" {
+		exporterOptions = append(exporterOptions, otlptracehttp.WithEndpointURL(options.endpoint))
+	}
+	exporter, err := otlptracehttp.New(ctx, exporterOptions...)
+	if err != nil {
+		return fmt.Errorf("otlptracehttp.New: %w", err)
+	}
+
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceName(certificator.ManagedByValue),
+			semconv.ServiceVersion(version.String()),
+		)),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(options.samplingRatio))),
+	))
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	log.Printf("Tracing, status: Exporting spans over OTLP/HTTP")
+
+	return nil
+}
+
+// shutdownTracing flushes spans which are not exported yet, so a certify Job doesn't lose them
+func shutdownTracing() {
+	provider, ok := otel.GetTracerProvider().(*sdktrace.TracerProvider)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+	defer cancel()
+	if err := provider.Shutdown(ctx); err != nil {
+		log.Printf("Tracing - error occurred, detail: %v", err)
+	}
+}
+
+// traceTransport makes API requests of clients created from config child spans of the calling phase
+func traceTransport(config *rest.Config) *rest.Config {
+	config.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return otelhttp.NewTransport(rt)
+	})
+
+	return config
+}
@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/ealebed/admission-webhook-certificator/pkg/certificator"
+)
+
+func TestIssueCertificateSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(previous)
+
+	ca := newTestCA(t, 24*time.Hour)
+	cs := fake.NewClientset(&admissionv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: "webhook-cfg"},
+		Webhooks:   []admissionv1.MutatingWebhook{{Name: "a.webhook.io"}},
+	})
+	signCSRsOnCreate(cs, ca)
+	options := &CreateAndSignCertOptions{
+		service:    "webhook-svc",
+		namespace:  "webhook",
+		secret:     "webhook-certs",
+		duration:   time.Hour,
+		approval:   certificator.ApprovalSelf,
+		timeout:    time.Second,
+		signerName: webhookServingSignerName,
+		caBundle:   CABundleOptions{mutatingWebhooks: []string{"webhook-cfg"}},
+	}
+	labels, _ := certificator.OwnerLabels(options.service, options.namespace, options.secret)
+
+	if err := issueCertificate(context.TODO(), cs, record.NewFakeRecorder(100), options, labels); err != nil {
+		t.Fatalf("issueCertificate() error = %v", err)
+	}
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	root, ok := spans["issueCertificate"]
+	if !ok {
+		t.Fatalf("Expected issueCertificate span, got %v", spans)
+	}
+	for _, name := range []string{"generateCertificateRequest", "createCSR", "approveCSR", "retrieveUpdatedCSR", "createOrUpdateSecret", "patchCABundle"} {
+		span, ok := spans[name]
+		if !ok {
+			t.Errorf("Expected %s span", name)
+			continue
+		}
+		if span.Parent().SpanID() != root.SpanContext().SpanID() {
+			t.Errorf("Expected %s span to be a child of issueCertificate", name)
+		}
+	}
+
+	want := map[attribute.Key]string{certificator.AttrSigner: webhookServingSignerName, certificator.AttrNamespace: "webhook"}
+	for _, name := range []string{"createCSR", "approveCSR", "retrieveUpdatedCSR"} {
+		got := map[attribute.Key]string{}
+		for _, kv := range spans[name].Attributes() {
+			got[kv.Key] = kv.Value.AsString()
+		}
+		for key, value := range want {
+			if got[key] != value {
+				t.Errorf("Expected %s span attribute %s=%s, got %v", name, key, value, got)
+			}
+		}
+		if got[certificator.AttrCSRName] == "" {
+			t.Errorf("Expected %s span to carry the CSR name", name)
+		}
+	}
+}
+
+func TestStartTracing(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	previous := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(previous)
+
+	if err := startTracing(context.TODO(), &TracingOptions{samplingRatio: 1}); err != nil {
+		t.Fatalf("startTracing() error = %v", err)
+	}
+	if _, ok := otel.GetTracerProvider().(*sdktrace.TracerProvider); ok {
+		t.Error("Expected tracing off without an endpoint")
+	}
+
+	if err := startTracing(context.TODO(), &TracingOptions{endpoint: "http://127.0.0.1:4318", samplingRatio: 1}); err != nil {
+		t.Fatalf("startTracing() error = %v", err)
+	}
+	if _, ok := otel.GetTracerProvider().(*sdktrace.TracerProvider); !ok {
+		t.Error("Expected SDK tracer provider with an endpoint")
+	}
+	shutdownTracing()
+}
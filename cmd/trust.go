@@ -0,0 +1,432 @@
+/*
+Copyright © 2024 Yevhen Lebid ealebed@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	certv1alpha1 "k8s.io/api/certificates/v1alpha1"
+	certv1beta1 "k8s.io/api/certificates/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	certsv1alpha1 "k8s.io/client-go/kubernetes/typed/certificates/v1alpha1"
+	certsv1beta1 "k8s.io/client-go/kubernetes/typed/certificates/v1beta1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+
+	"github.com/ealebed/admission-webhook-certificator/pkg/certificator"
+)
+
+const (
+	// retiredCAsAnnotation records when each CA of a trust bundle stopped being the active one
+	retiredCAsAnnotation = "certificator.ealebed.io/retired-cas"
+
+	clusterTrustBundleResource = "clustertrustbundles"
+)
+
+// TrustOptions represents options for publishing the CA to trust targets
+type TrustOptions struct {
+	trustBundle             string
+	trustBundleSignerLinked bool
+	trustBundleOverlap      time.Duration
+	caConfigMap             string
+	caConfigMapSelector     string
+}
+
+// addTrustFlags registers flags for publishing the CA to trust targets
+func addTrustFlags(cmd *cobra.Command, options *TrustOptions) {
+	cmd.Flags().StringVar(&options.trustBundle, "trust-bundle", "",
+		"Name of a ClusterTrustBundle to publish the CA into, disabled when empty.")
+	cmd.Flags().BoolVar(&options.trustBundleSignerLinked, "trust-bundle-signer-linked", false,
+		"Link the ClusterTrustBundle to the signer name, its name is prefixed accordingly.")
+	cmd.Flags().DurationVar(&options.trustBundleOverlap, "trust-bundle-overlap", certificator.DefaultDuration,
+		"How long a replaced CA stays in the ClusterTrustBundle, so certificates it issued remain trusted.")
+	cmd.Flags().StringVar(&options.caConfigMap, "ca-configmap", "",
+		"Name of a ConfigMap to copy the CA into (as ca.crt) in every matching namespace, disabled when empty.")
+	cmd.Flags().StringVar(&options.caConfigMapSelector, "ca-configmap-namespace-selector", "",
+		"Label selector of namespaces receiving the CA ConfigMap, all namespaces when empty.")
+}
+
+// trustBundle is the version independent content of a ClusterTrustBundle
+type trustBundle struct {
+	annotations     map[string]string
+	signerName      string
+	trustBundle     string
+	resourceVersion string
+}
+
+// trustBundleClient reads and writes ClusterTrustBundles of the API version served by the cluster
+type trustBundleClient interface {
+	get(ctx context.Context, name string) (*trustBundle, error)
+	create(ctx context.Context, name string, bundle *trustBundle) error
+	update(ctx context.Context, name string, bundle *trustBundle) error
+}
+
+// newTrustBundleClient detects the served ClusterTrustBundle API version via discovery
+func newTrustBundleClient(cs kubernetes.Interface) (trustBundleClient, error) {
+	for _, groupVersion := range []string{certv1beta1.SchemeGroupVersion.String(), certv1alpha1.SchemeGroupVersion.String()} {
+		resources, err := cs.Discovery().ServerResourcesForGroupVersion(groupVersion)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("discover %s: %w", groupVersion, err)
+		}
+		if !slices.ContainsFunc(resources.APIResources, func(r metav1.APIResource) bool { return r.Name == clusterTrustBundleResource }) {
+			continue
+		}
+
+		log.Printf("ClusterTrustBundle, status: Using %s API", groupVersion)
+		if groupVersion == certv1beta1.SchemeGroupVersion.String() {
+			return &trustBundleV1beta1{client: cs.CertificatesV1beta1().ClusterTrustBundles()}, nil
+		}
+		return &trustBundleV1alpha1{client: cs.CertificatesV1alpha1().ClusterTrustBundles()}, nil
+	}
+
+	return nil, fmt.Errorf("ClusterTrustBundle API is not served by the cluster, " +
+		"it requires certificates.k8s.io/v1beta1 or v1alpha1 to be enabled on kube-apiserver")
+}
+
+type trustBundleV1beta1 struct {
+	client certsv1beta1.ClusterTrustBundleInterface
+}
+
+func (c *trustBundleV1beta1) get(ctx context.Context, name string) (*trustBundle, error) {
+	bundle, err := c.client.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	return &trustBundle{bundle.Annotations, bundle.Spec.SignerName, bundle.Spec.TrustBundle, bundle.ResourceVersion}, nil
+}
+
+func (c *trustBundleV1beta1) create(ctx context.Context, name string, bundle *trustBundle) error {
+	_, err := c.client.Create(ctx, &certv1beta1.ClusterTrustBundle{
+		ObjectMeta: trustBundleMeta(name, bundle),
+		Spec:       certv1beta1.ClusterTrustBundleSpec{SignerName: bundle.signerName, TrustBundle: bundle.trustBundle},
+	}, metav1.CreateOptions{})
+
+	return err
+}
+
+func (c *trustBundleV1beta1) update(ctx context.Context, name string, bundle *trustBundle) error {
+	_, err := c.client.Update(ctx, &certv1beta1.ClusterTrustBundle{
+		ObjectMeta: trustBundleMeta(name, bundle),
+		Spec:       certv1beta1.ClusterTrustBundleSpec{SignerName: bundle.signerName, TrustBundle: bundle.trustBundle},
+	}, metav1.UpdateOptions{})
+
+	return err
+}
+
+type trustBundleV1alpha1 struct {
+	client certsv1alpha1.ClusterTrustBundleInterface
+}
+
+func (c *trustBundleV1alpha1) get(ctx context.Context, name string) (*trustBundle, error) {
+	bundle, err := c.client.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	return &trustBundle{bundle.Annotations, bundle.Spec.SignerName, bundle.Spec.TrustBundle, bundle.ResourceVersion}, nil
+}
+
+func (c *trustBundleV1alpha1) create(ctx context.Context, name string, bundle *trustBundle) error {
+	_, err := c.client.Create(ctx, &certv1alpha1.ClusterTrustBundle{
+		ObjectMeta: trustBundleMeta(name, bundle),
+		Spec:       certv1alpha1.ClusterTrustBundleSpec{SignerName: bundle.signerName, TrustBundle: bundle.trustBundle},
+	}, metav1.CreateOptions{})
+
+	return err
+}
+
+func (c *trustBundleV1alpha1) update(ctx context.Context, name string, bundle *trustBundle) error {
+	_, err := c.client.Update(ctx, &certv1alpha1.ClusterTrustBundle{
+		ObjectMeta: trustBundleMeta(name, bundle),
+		Spec:       certv1alpha1.ClusterTrustBundleSpec{SignerName: bundle.signerName, TrustBundle: bundle.trustBundle},
+	}, metav1.UpdateOptions{})
+
+	return err
+}
+
+func trustBundleMeta(name string, bundle *trustBundle) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:            name,
+		Labels:          map[string]string{certificator.ManagedByLabel: certificator.ManagedByValue},
+		Annotations:     bundle.annotations,
+		ResourceVersion: bundle.resourceVersion,
+	}
+}
+
+// trustBundlePublisher keeps a ClusterTrustBundle in sync with the active CA
+type trustBundlePublisher struct {
+	client     trustBundleClient
+	name       string
+	signerName string
+	overlap    time.Duration
+}
+
+// newTrustBundlePublisher returns a publisher for the configured ClusterTrustBundle, or nil when disabled
+func newTrustBundlePublisher(cs kubernetes.Interface, options *TrustOptions, signerName string) (*trustBundlePublisher, error) {
+	if options.trustBundle == "" {
+		return nil, nil
+	}
+	client, err := newTrustBundleClient(cs)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &trustBundlePublisher{client: client, name: options.trustBundle, overlap: options.trustBundleOverlap}
+	if options.trustBundleSignerLinked {
+		// signer-linked bundles must be named <signer name with / replaced by :>:<suffix>
+		prefix := strings.ReplaceAll(signerName, "/", ":") + ":"
+		if !strings.HasPrefix(p.name, prefix) {
+			p.name = prefix + p.name
+		}
+		p.signerName = signerName
+	}
+
+	return p, nil
+}
+
+// publish makes caPEM the active CA of the bundle, CAs it replaces are kept
+// for the overlap period or until they expire
+func (p *trustBundlePublisher) publish(ctx context.Context, caPEM []byte) error {
+	existing, err := p.client.get(ctx, p.name)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	found := existing != nil
+	if !found {
+		existing = &trustBundle{}
+	}
+
+	bundlePEM, retired, err := mergeTrustBundle([]byte(existing.trustBundle), caPEM, existing.annotations[retiredCAsAnnotation],
+		p.overlap, time.Now())
+	if err != nil {
+		return fmt.Errorf("ClusterTrustBundle %s: %w", p.name, err)
+	}
+
+	annotations := maps.Clone(existing.annotations)
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	delete(annotations, retiredCAsAnnotation)
+	if retired != "" {
+		annotations[retiredCAsAnnotation] = retired
+	}
+	bundle := &trustBundle{
+		annotations:     annotations,
+		signerName:      p.signerName,
+		trustBundle:     string(bundlePEM),
+		resourceVersion: existing.resourceVersion,
+	}
+
+	switch {
+	case !found:
+		log.Printf("ClusterTrustBundle %s, status: Not exists, creating", p.name)
+		err = p.client.create(ctx, p.name, bundle)
+	case existing.trustBundle != bundle.trustBundle || !maps.Equal(existing.annotations, bundle.annotations):
+		log.Printf("ClusterTrustBundle %s, status: Outdated, updating", p.name)
+		err = p.client.update(ctx, p.name, bundle)
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("ClusterTrustBundle %s, status: Published", p.name)
+
+	return nil
+}
+
+// mergeTrustBundle puts the active CA first and keeps previous CAs from the existing
+// bundle until they expire or the overlap since their retirement has passed. The
+// retirement times are tracked by fingerprint in a JSON encoded annotation value.
+func mergeTrustBundle(existingPEM, activePEM []byte, retiredJSON string, overlap time.Duration,
+	now time.Time) (bundlePEM []byte, retired string, err error) {
+	active, err := certificator.ParseCertificates(activePEM)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(active) == 0 {
+		return nil, "", fmt.Errorf("no active CA certificate")
+	}
+	existing, err := certificator.ParseCertificates(existingPEM)
+	if err != nil {
+		return nil, "", err
+	}
+
+	retiredAt := map[string]time.Time{}
+	if retiredJSON != "" {
+		if err = json.Unmarshal([]byte(retiredJSON), &retiredAt); err != nil {
+			return nil, "", fmt.Errorf("annotation %s: %w", retiredCAsAnnotation, err)
+		}
+	}
+
+	seen := map[string]bool{}
+	kept := map[string]time.Time{}
+	for _, cert := range active {
+		fingerprint := certificateFingerprint(cert.Raw)
+		if !seen[fingerprint] {
+			seen[fingerprint] = true
+			bundlePEM = append(bundlePEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+		}
+	}
+	for _, cert := range existing {
+		fingerprint := certificateFingerprint(cert.Raw)
+		if seen[fingerprint] {
+			continue
+		}
+		seen[fingerprint] = true
+
+		since, ok := retiredAt[fingerprint]
+		if !ok {
+			since = now
+		}
+		if now.After(cert.NotAfter) || now.Sub(since) >= overlap {
+			continue
+		}
+		kept[fingerprint] = since
+		bundlePEM = append(bundlePEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+
+	if len(kept) > 0 {
+		var data []byte
+		if data, err = json.Marshal(kept); err != nil {
+			return nil, "", err
+		}
+		retired = string(data)
+	}
+
+	return bundlePEM, retired, nil
+}
+
+// certificateFingerprint returns the hex encoded SHA-256 of a DER certificate
+func certificateFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// newTrustControllers returns controllers publishing the CA of the namespace/name Secret to the
+// configured trust targets, together with the informer factories they need started
+func newTrustControllers(cs kubernetes.Interface, options *TrustOptions, controllerOptions *ControllerOptions,
+	signerName, namespace, name string) ([]*controller, []informers.SharedInformerFactory, error) {
+	trustBundle, err := newTrustBundlePublisher(cs, options, signerName)
+	if err != nil {
+		return nil, nil, err
+	}
+	configMaps, err := newConfigMapPublisher(cs, options, namespace, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if trustBundle == nil && configMaps == nil {
+		return nil, nil, nil
+	}
+
+	var controllers []*controller
+	secretFactory := newSecretInformerFactory(cs, controllerOptions.resync, namespace, name)
+	factories := []informers.SharedInformerFactory{secretFactory}
+	if trustBundle != nil {
+		p := &caPublisher{
+			secretLister: secretFactory.Core().V1().Secrets().Lister(),
+			namespace:    namespace,
+			name:         name,
+			trustBundle:  trustBundle,
+		}
+		c, err := p.controller(secretFactory)
+		if err != nil {
+			return nil, nil, err
+		}
+		controllers = append(controllers, c)
+	}
+	if configMaps != nil {
+		factory := informers.NewSharedInformerFactory(cs, controllerOptions.resync)
+		configMapFactory := newManagedInformerFactory(cs, controllerOptions)
+		c, err := configMaps.controller(factory, configMapFactory, secretFactory)
+		if err != nil {
+			return nil, nil, err
+		}
+		controllers = append(controllers, c)
+		factories = append(factories, factory, configMapFactory)
+	}
+
+	return controllers, factories, nil
+}
+
+// caPublisher publishes the CA certificate of a Secret to the configured trust targets
+type caPublisher struct {
+	secretLister corelisters.SecretLister
+	namespace    string
+	name         string
+	trustBundle  *trustBundlePublisher
+}
+
+// newSecretInformerFactory returns an informer factory which caches a single Secret, so
+// publishers do not cache all Secrets of the namespace
+func newSecretInformerFactory(cs kubernetes.Interface, resync time.Duration, namespace, name string) informers.SharedInformerFactory {
+	return informers.NewSharedInformerFactoryWithOptions(cs, resync,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+		}))
+}
+
+// controller returns a controller which publishes the CA whenever its Secret changes
+func (p *caPublisher) controller(factory informers.SharedInformerFactory) (*controller, error) {
+	c := newController("trust", p.sync)
+	err := c.watch(factory.Core().V1().Secrets().Informer(), func(obj interface{}) []string {
+		if secret, ok := obj.(*corev1.Secret); ok && secret.Namespace == p.namespace && secret.Name == p.name {
+			return []string{p.namespace + "/" + p.name}
+		}
+		return nil
+	})
+
+	return c, err
+}
+
+func (p *caPublisher) sync(ctx context.Context, _ string) error {
+	secret, err := p.secretLister.Secrets(p.namespace).Get(p.name)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if p.trustBundle != nil {
+		if err := p.trustBundle.publish(ctx, caBundleFromSecret(secret)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
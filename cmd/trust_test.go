@@ -0,0 +1,196 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/ealebed/admission-webhook-certificator/pkg/certificator"
+	"github.com/ealebed/admission-webhook-certificator/pkg/issuer"
+)
+
+// newTrustBundleClientset returns a fake clientset which serves ClusterTrustBundles in the given versions
+func newTrustBundleClientset(groupVersions ...string) *fake.Clientset {
+	cs := fake.NewClientset()
+	for _, groupVersion := range groupVersions {
+		cs.Discovery().(*fakediscovery.FakeDiscovery).Resources = append(cs.Discovery().(*fakediscovery.FakeDiscovery).Resources,
+			&metav1.APIResourceList{
+				GroupVersion: groupVersion,
+				APIResources: []metav1.APIResource{{Name: clusterTrustBundleResource}},
+			})
+	}
+
+	return cs
+}
+
+func TestNewTrustBundleClient(t *testing.T) {
+	tests := []struct {
+		name          string
+		groupVersions []string
+		wantType      string
+		wantErr       bool
+	}{
+		{name: "prefers v1beta1", groupVersions: []string{"certificates.k8s.io/v1alpha1", "certificates.k8s.io/v1beta1"}, wantType: "*cmd.trustBundleV1beta1"},
+		{name: "falls back to v1alpha1", groupVersions: []string{"certificates.k8s.io/v1alpha1"}, wantType: "*cmd.trustBundleV1alpha1"},
+		{name: "not served", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newTrustBundleClient(newTrustBundleClientset(tt.groupVersions...))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newTrustBundleClient() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != nil && fmt.Sprintf("%T", got) != tt.wantType {
+				t.Errorf("Expected %s client, got %T", tt.wantType, got)
+			}
+		})
+	}
+}
+
+func TestMergeTrustBundle(t *testing.T) {
+	now := time.Now()
+	oldCA := newTestCA(t, 24*time.Hour)
+	newCA := newTestCA(t, 24*time.Hour)
+	expiredCA := newTestCA(t, -time.Minute)
+	retired := func(ca *issuer.CertificateAuthority, at time.Time) string {
+		data, _ := json.Marshal(map[string]time.Time{certificateFingerprint(ca.Certificate.Raw): at})
+		return string(data)
+	}
+
+	tests := []struct {
+		name        string
+		existingPEM []byte
+		retiredJSON string
+		want        []*issuer.CertificateAuthority
+		wantRetired bool
+	}{
+		{name: "empty bundle", want: []*issuer.CertificateAuthority{newCA}},
+		{name: "unchanged CA", existingPEM: newCA.CertificatePEM, want: []*issuer.CertificateAuthority{newCA}},
+		{
+			name:        "keeps replaced CA",
+			existingPEM: oldCA.CertificatePEM,
+			want:        []*issuer.CertificateAuthority{newCA, oldCA},
+			wantRetired: true,
+		},
+		{
+			name:        "drops CA after overlap",
+			existingPEM: append(append([]byte{}, newCA.CertificatePEM...), oldCA.CertificatePEM...),
+			retiredJSON: retired(oldCA, now.Add(-2*time.Hour)),
+			want:        []*issuer.CertificateAuthority{newCA},
+		},
+		{
+			name:        "drops expired CA",
+			existingPEM: expiredCA.CertificatePEM,
+			want:        []*issuer.CertificateAuthority{newCA},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bundlePEM, retired, err := mergeTrustBundle(tt.existingPEM, newCA.CertificatePEM, tt.retiredJSON, time.Hour, now)
+			if err != nil {
+				t.Fatalf("mergeTrustBundle() error = %v", err)
+			}
+			var want []byte
+			for _, ca := range tt.want {
+				want = append(want, ca.CertificatePEM...)
+			}
+			if string(bundlePEM) != string(want) {
+				t.Errorf("Expected bundle with %d CAs, got:\n%s", len(tt.want), bundlePEM)
+			}
+			if (retired != "") != tt.wantRetired {
+				t.Errorf("Expected retired CAs recorded: %v, got '%s'", tt.wantRetired, retired)
+			}
+		})
+	}
+}
+
+func TestTrustBundlePublisherPublish(t *testing.T) {
+	tests := []struct {
+		name         string
+		signerLinked bool
+		wantName     string
+		wantSigner   string
+	}{
+		{name: "unlinked bundle", wantName: "webhook-ca"},
+		{
+			name:         "signer-linked bundle",
+			signerLinked: true,
+			wantName:     "certificator.ealebed.io:webhook-serving:webhook-ca",
+			wantSigner:   webhookServingSignerName,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := newTrustBundleClientset("certificates.k8s.io/v1beta1")
+			options := &TrustOptions{trustBundle: "webhook-ca", trustBundleSignerLinked: tt.signerLinked, trustBundleOverlap: time.Hour}
+			p, err := newTrustBundlePublisher(cs, options, webhookServingSignerName)
+			if err != nil {
+				t.Fatalf("newTrustBundlePublisher() error = %v", err)
+			}
+
+			oldCA := newTestCA(t, time.Hour)
+			newCA := newTestCA(t, time.Hour)
+			for _, ca := range []*issuer.CertificateAuthority{oldCA, newCA} {
+				if err := p.publish(context.TODO(), ca.CertificatePEM); err != nil {
+					t.Fatalf("publish() error = %v", err)
+				}
+			}
+
+			bundle, err := cs.CertificatesV1beta1().ClusterTrustBundles().Get(context.TODO(), tt.wantName, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("Expected ClusterTrustBundle %s, got %v", tt.wantName, err)
+			}
+			if bundle.Spec.SignerName != tt.wantSigner {
+				t.Errorf("Expected signerName '%s', got '%s'", tt.wantSigner, bundle.Spec.SignerName)
+			}
+			if want := string(newCA.CertificatePEM) + string(oldCA.CertificatePEM); bundle.Spec.TrustBundle != want {
+				t.Errorf("Expected new CA followed by old CA, got:\n%s", bundle.Spec.TrustBundle)
+			}
+			if bundle.Labels[certificator.ManagedByLabel] != certificator.ManagedByValue {
+				t.Errorf("Expected managed-by label, got %v", bundle.Labels)
+			}
+		})
+	}
+}
+
+func TestCAPublisherSync(t *testing.T) {
+	ca := newTestCA(t, time.Hour)
+	cs := newTrustBundleClientset("certificates.k8s.io/v1beta1")
+	p, err := newTrustBundlePublisher(cs, &TrustOptions{trustBundle: "webhook-ca", trustBundleOverlap: time.Hour}, webhookServingSignerName)
+	if err != nil {
+		t.Fatalf("newTrustBundlePublisher() error = %v", err)
+	}
+	secrets := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	_ = secrets.Add(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "certificator-ca", Namespace: "webhook"},
+		Data:       map[string][]byte{corev1.TLSCertKey: ca.CertificatePEM},
+	})
+	publisher := &caPublisher{
+		secretLister: corelisters.NewSecretLister(secrets),
+		namespace:    "webhook",
+		name:         "certificator-ca",
+		trustBundle:  p,
+	}
+
+	if err := publisher.sync(context.TODO(), "webhook/certificator-ca"); err != nil {
+		t.Fatalf("sync() error = %v", err)
+	}
+
+	bundles, _ := cs.CertificatesV1beta1().ClusterTrustBundles().List(context.TODO(), metav1.ListOptions{})
+	if len(bundles.Items) != 1 || !strings.Contains(bundles.Items[0].Spec.TrustBundle, strings.TrimSpace(string(ca.CertificatePEM))) {
+		t.Errorf("Expected one ClusterTrustBundle with the CA, got %v", bundles.Items)
+	}
+}
@@ -0,0 +1,207 @@
+/*
+Copyright © 2024 Yevhen Lebid ealebed@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/ealebed/admission-webhook-certificator/pkg/certificator"
+)
+
+const (
+	// verifyEndpointService dials the webhook Service, the value of a bare --verify-endpoint
+	verifyEndpointService = "service"
+	defaultVerifyTimeout  = 2 * time.Minute
+	// defaultVerifyInterval is the delay between handshakes, the kubelet takes up to a minute to update a mounted Secret
+	defaultVerifyInterval = 5 * time.Second
+	// verifyDialTimeout bounds one handshake
+	verifyDialTimeout = 10 * time.Second
+	defaultHTTPSPort  = 443
+)
+
+// VerifyEndpointOptions represents the TLS handshake proving that the webhook serves the issued certificate
+type VerifyEndpointOptions struct {
+	endpoint string
+	timeout  time.Duration
+	// interval is the delay between handshakes, defaultVerifyInterval when zero
+	interval time.Duration
+}
+
+// addVerifyEndpointFlags registers flags verifying the webhook endpoint after issuance
+func addVerifyEndpointFlags(cmd *cobra.Command, options *VerifyEndpointOptions) {
+	cmd.Flags().StringVar(&options.endpoint, "verify-endpoint", "",
+		"After issuance, dial the webhook with TLS until it serves the new certificate. Without a value the Service is dialed, "+
+			"otherwise the given URL or host:port.")
+	cmd.Flags().Lookup("verify-endpoint").NoOptDefVal = verifyEndpointService
+	cmd.Flags().DurationVar(&options.timeout, "verify-timeout", defaultVerifyTimeout,
+		"How long to wait for the webhook to serve the new certificate.")
+}
+
+// enabled reports whether the endpoint is verified
+func (o *VerifyEndpointOptions) enabled() bool {
+	return o.endpoint != ""
+}
+
+// args returns command line arguments reproducing the options
+func (o *VerifyEndpointOptions) args() []string {
+	if !o.enabled() {
+		return nil
+	}
+
+	args := []string{"--verify-endpoint=" + o.endpoint}
+	if o.timeout != 0 && o.timeout != defaultVerifyTimeout {
+		args = append(args, "--verify-timeout="+o.timeout.String())
+	}
+
+	return args
+}
+
+// verifyEndpoint dials the webhook until it serves certPEM, trusting caBundle and sending the
+// Service DNS name as SNI, like the API server does
+func verifyEndpoint(ctx context.Context, cs kubernetes.Interface, options *CreateAndSignCertOptions, caBundle, certPEM []byte) error {
+	ctx, span := certificator.StartSpan(ctx, "verifyEndpoint",
+		certificator.AttrNamespace.String(options.namespace), certificator.AttrService.String(options.service))
+	defer span.End()
+
+	issued, err := certificator.LeafCertificate(certPEM)
+	if err != nil {
+		return certificator.SpanError(span, err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caBundle) {
+		return certificator.SpanError(span, errors.New("no CA certificate to verify the endpoint with"))
+	}
+	address, err := endpointAddress(ctx, cs, options)
+	if err != nil {
+		return certificator.SpanError(span, err)
+	}
+	config := &tls.Config{
+		RootCAs:    roots,
+		ServerName: fmt.Sprintf("%s.%s.svc", options.service, options.namespace),
+		MinVersion: tls.VersionTLS12,
+	}
+
+	interval, timeout := options.verify.interval, options.verify.timeout
+	if interval <= 0 {
+		interval = defaultVerifyInterval
+	}
+	if timeout <= 0 {
+		timeout = defaultVerifyTimeout
+	}
+	serial := issued.SerialNumber.Text(16)
+	var lastErr error
+	err = wait.PollUntilContextTimeout(ctx, interval, timeout, true, func(ctx context.Context) (bool, error) {
+		served, dialErr := servedCertificate(ctx, address, config)
+		switch {
+		case dialErr != nil && lastErr != nil && pastDeadline(ctx):
+			// the timeout cut the handshake short, the previous one tells why the endpoint fails
+			return false, nil
+		case dialErr != nil:
+			lastErr = dialErr
+		case served.SerialNumber.Cmp(issued.SerialNumber) != 0:
+			lastErr = fmt.Errorf("serves certificate %s", served.SerialNumber.Text(16))
+		default:
+			return true, nil
+		}
+		log.Printf("Endpoint %s, status: Not serving certificate %s yet, %v", address, serial, lastErr)
+		return false, nil
+	})
+	if err != nil {
+		return certificator.SpanError(span, fmt.Errorf("endpoint %s doesn't serve certificate %s after %s: %w", address, serial, timeout, lastErr))
+	}
+	log.Printf("Endpoint %s, status: Serving certificate %s", address, serial)
+
+	return nil
+}
+
+// pastDeadline reports whether the deadline of ctx passed, a dial fails at the deadline before ctx reports it
+func pastDeadline(ctx context.Context) bool {
+	deadline, ok := ctx.Deadline()
+	return ok && !time.Now().Before(deadline)
+}
+
+// servedCertificate completes a TLS handshake with address and returns the certificate it serves
+func servedCertificate(ctx context.Context, address string, config *tls.Config) (*x509.Certificate, error) {
+	dialer := &tls.Dialer{NetDialer: &net.Dialer{Timeout: verifyDialTimeout}, Config: config}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok || len(tlsConn.ConnectionState().PeerCertificates) == 0 {
+		return nil, errors.New("no certificate served")
+	}
+
+	return tlsConn.ConnectionState().PeerCertificates[0], nil
+}
+
+// endpointAddress returns the host:port of --verify-endpoint, the Service DNS name and port by default
+func endpointAddress(ctx context.Context, cs kubernetes.Interface, options *CreateAndSignCertOptions) (string, error) {
+	endpoint := options.verify.endpoint
+	if endpoint != verifyEndpointService {
+		if strings.Contains(endpoint, "://") {
+			u, err := url.Parse(endpoint)
+			if err != nil {
+				return "", fmt.Errorf("invalid endpoint %q: %w", endpoint, err)
+			}
+			endpoint = u.Host
+		}
+		if _, _, err := net.SplitHostPort(endpoint); err != nil {
+			endpoint = net.JoinHostPort(endpoint, strconv.Itoa(defaultHTTPSPort))
+		}
+		return endpoint, nil
+	}
+
+	service, err := cs.CoreV1().Services(options.namespace).Get(ctx, options.service, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("get Service %s/%s: %w", options.namespace, options.service, err)
+	}
+
+	return net.JoinHostPort(fmt.Sprintf("%s.%s.svc", service.Name, service.Namespace), strconv.Itoa(int(servicePort(service)))), nil
+}
+
+// servicePort returns the port webhook configurations would use, the https or only port of the Service, 443 otherwise
+func servicePort(service *corev1.Service) int32 {
+	for _, port := range service.Spec.Ports {
+		if port.Name == "https" {
+			return port.Port
+		}
+	}
+	if len(service.Spec.Ports) == 1 {
+		return service.Spec.Ports[0].Port
+	}
+
+	return defaultHTTPSPort
+}
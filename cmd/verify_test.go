@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// serveTLS serves the certificates of secrets over TLS, the first handshakes get the first ones,
+// like a webhook pod before the kubelet updates its mounted Secret
+func serveTLS(t *testing.T, secrets ...*corev1.Secret) string {
+	t.Helper()
+
+	var certificates []tls.Certificate
+	for _, secret := range secrets {
+		certificate, err := tls.X509KeyPair(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+		if err != nil {
+			t.Fatalf("tls.X509KeyPair() error = %v", err)
+		}
+		certificates = append(certificates, certificate)
+	}
+	var handshakes atomic.Int32
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			i := min(int(handshakes.Add(1))-1, len(certificates)-1)
+			return &certificates[i], nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("tls.Listen() error = %v", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.(*tls.Conn).Handshake()
+			_ = conn.Close()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func TestVerifyEndpoint(t *testing.T) {
+	ca := newTestCA(t, 24*time.Hour)
+	other := newTestCA(t, 24*time.Hour)
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "webhook-svc", Namespace: "webhook"}}
+	stale := newIssuedSecret(t, ca, service, "webhook-certs", time.Hour)
+	issued := newIssuedSecret(t, ca, service, "webhook-certs", time.Hour)
+
+	tests := []struct {
+		name     string
+		served   []*corev1.Secret
+		caBundle []byte
+		wantErr  string
+	}{
+		{
+			name:     "serves issued certificate after reload",
+			served:   []*corev1.Secret{stale, stale, issued},
+			caBundle: ca.CertificatePEM,
+		},
+		{
+			name:     "keeps serving stale certificate",
+			served:   []*corev1.Secret{stale},
+			caBundle: ca.CertificatePEM,
+			wantErr:  "serves certificate",
+		},
+		{
+			name:     "serves certificate of another CA",
+			served:   []*corev1.Secret{issued},
+			caBundle: other.CertificatePEM,
+			wantErr:  "certificate signed by unknown authority",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := &CreateAndSignCertOptions{
+				service: "webhook-svc", namespace: "webhook",
+				verify: VerifyEndpointOptions{endpoint: "https://" + serveTLS(t, tt.served...), timeout: time.Second, interval: 10 * time.Millisecond},
+			}
+
+			err := verifyEndpoint(context.TODO(), fake.NewClientset(), options, tt.caBundle, issued.Data[corev1.TLSCertKey])
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("verifyEndpoint() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestEndpointAddress(t *testing.T) {
+	cs := fake.NewClientset(
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "webhook-svc", Namespace: "webhook"},
+			Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Name: "metrics", Port: 8080}, {Name: "https", Port: 9443}}},
+		},
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "portless-svc", Namespace: "webhook"}},
+	)
+
+	tests := []struct {
+		service  string
+		endpoint string
+		want     string
+	}{
+		{service: "webhook-svc", endpoint: verifyEndpointService, want: "webhook-svc.webhook.svc:9443"},
+		{service: "portless-svc", endpoint: verifyEndpointService, want: net.JoinHostPort("portless-svc.webhook.svc", "443")},
+		{service: "webhook-svc", endpoint: "https://webhook.example.io:8443/validate", want: "webhook.example.io:8443"},
+		{service: "webhook-svc", endpoint: "webhook.example.io", want: "webhook.example.io:443"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.endpoint, func(t *testing.T) {
+			options := &CreateAndSignCertOptions{service: tt.service, namespace: "webhook", verify: VerifyEndpointOptions{endpoint: tt.endpoint}}
+			got, err := endpointAddress(context.TODO(), cs, options)
+			if err != nil {
+				t.Fatalf("endpointAddress() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Expected address %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
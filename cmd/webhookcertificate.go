@@ -0,0 +1,265 @@
+/*
+Copyright © 2024 Yevhen Lebid ealebed@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+
+	"github.com/ealebed/admission-webhook-certificator/pkg/certificator"
+)
+
+const (
+	webhookCertificateGroup   = "certificator.ealebed.io"
+	webhookCertificateVersion = "v1alpha1"
+	webhookCertificateKind    = "WebhookCertificate"
+
+	// issuerKindSigner issues through a CSR for the signer named by the issuerRef
+	issuerKindSigner = "Signer"
+	// issuerKindCA signs with the CA of the Secret named by the issuerRef
+	issuerKindCA = "CA"
+	// issuerKindSelfSigned signs with a CA generated for the certificate
+	issuerKindSelfSigned = "SelfSigned"
+
+	// WebhookCertificate condition types
+	conditionReady   = "Ready"
+	conditionIssuing = "Issuing"
+	conditionFailed  = "Failed"
+)
+
+var webhookCertificateResource = schema.GroupVersionResource{
+	Group:    webhookCertificateGroup,
+	Version:  webhookCertificateVersion,
+	Resource: "webhookcertificates",
+}
+
+// WebhookCertificate declares a webhook serving certificate which is kept issued in a Secret
+type WebhookCertificate struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   WebhookCertificateSpec   `json:"spec"`
+	Status WebhookCertificateStatus `json:"status,omitempty"`
+}
+
+// WebhookCertificateSpec describes the certificate, where it is stored and where its CA is injected
+type WebhookCertificateSpec struct {
+	// Service is the name of the webhook Service the certificate is issued for
+	Service string `json:"service"`
+	// Namespace of the Service and the Secret, it has to be the namespace of the WebhookCertificate when set
+	Namespace string `json:"namespace,omitempty"`
+	// SecretName is the Secret receiving the certificate, private key and CA
+	SecretName string `json:"secretName"`
+	// DNSNames are added to the Service DNS names, they are names of Services in the namespace or of
+	// the domains allowed by the reconciler
+	DNSNames []string `json:"dnsNames,omitempty"`
+	// IPAddresses are added as IP SANs, they are cluster IPs of the Service or in the IP ranges
+	// allowed by the reconciler
+	IPAddresses []string `json:"ipAddresses,omitempty"`
+	// Duration is the requested certificate lifetime, one year when empty
+	Duration *metav1.Duration `json:"duration,omitempty"`
+	// RenewBefore is how long before expiry the certificate is renewed, a third of its lifetime when empty
+	RenewBefore *metav1.Duration `json:"renewBefore,omitempty"`
+	// KeyAlgorithm of the private key, RSA or ECDSA
+	KeyAlgorithm string `json:"keyAlgorithm,omitempty"`
+	// IssuerRef selects who signs the certificate
+	IssuerRef IssuerReference `json:"issuerRef,omitempty"`
+	// WebhookConfigurations get the CA as caBundle of all their webhooks, they opt in with the
+	// inject-ca-from annotation naming the Secret
+	WebhookConfigurations []WebhookConfigurationReference `json:"webhookConfigurations,omitempty"`
+	// Notifiers are told about issuance, renewal and failure of the certificate
+	Notifiers *NotifierSpec `json:"notifiers,omitempty"`
+}
+
+// NotifierSpec lists who is notified about the certificate, the URLs have to point to hosts allowed
+// by the reconciler and commands are configured on the reconciler only
+type NotifierSpec struct {
+	// Webhooks receive the issuance result as JSON POST
+	Webhooks []string `json:"webhooks,omitempty"`
+	// SlackWebhooks are Slack-compatible incoming webhooks, e.g. of Slack or Teams, receiving a message
+	SlackWebhooks []string `json:"slackWebhooks,omitempty"`
+}
+
+// IssuerReference selects who signs the certificate
+type IssuerReference struct {
+	// Kind of the issuer, Signer issues through a CSR for the signer Name, CA signs with the CA
+	// of the Secret Name and SelfSigned with a CA generated for the certificate
+	Kind string `json:"kind,omitempty"`
+	// Name of the issuer, for Signer the signer of the reconciler or one it allows, for CA a Secret
+	// in the namespace of the WebhookCertificate or the namespace/name of a Secret allowed by the reconciler
+	Name string `json:"name,omitempty"`
+}
+
+// WebhookConfigurationReference names a webhook configuration whose caBundle is injected
+type WebhookConfigurationReference struct {
+	// Kind is MutatingWebhookConfiguration or ValidatingWebhookConfiguration
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+}
+
+// WebhookCertificateStatus reports the state of the issued certificate
+type WebhookCertificateStatus struct {
+	// Conditions are Ready, Issuing and Failed
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// NotAfter is the expiry of the issued certificate
+	NotAfter *metav1.Time `json:"notAfter,omitempty"`
+	// Serial is the hex serial number of the issued certificate
+	Serial string `json:"serial,omitempty"`
+	// LastRenewalTime is when the certificate was last issued
+	LastRenewalTime *metav1.Time `json:"lastRenewalTime,omitempty"`
+}
+
+// webhookCertificateEnums restricts string fields of the CRD schema, by their path
+var webhookCertificateEnums = map[string][]string{
+	"spec.keyAlgorithm":               {certificator.KeyAlgorithmRSA, certificator.KeyAlgorithmECDSA},
+	"spec.issuerRef.kind":             {issuerKindSigner, issuerKindCA, issuerKindSelfSigned},
+	"spec.webhookConfigurations.kind": {"MutatingWebhookConfiguration", "ValidatingWebhookConfiguration"},
+}
+
+// NewCRDCmd returns new crd command
+func NewCRDCmd(out io.Writer) *cobra.Command {
+	return &cobra.Command{
+		Use:   "crd",
+		Short: "Print the WebhookCertificate CustomResourceDefinition.",
+		Long: "This command prints the CustomResourceDefinition of WebhookCertificate, generated from the\n" +
+			"types the reconciler uses. manifests/webhookcertificate-crd.yaml is its output.",
+		Example: "crd | kubectl apply -f -",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			data, err := yaml.Marshal(webhookCertificateCRD())
+			if err != nil {
+				return err
+			}
+			_, err = out.Write(data)
+			return err
+		},
+	}
+}
+
+// webhookCertificateCRD returns the CustomResourceDefinition of WebhookCertificate
+func webhookCertificateCRD() map[string]interface{} {
+	specSchema := openAPISchema(reflect.TypeFor[WebhookCertificateSpec](), "spec")
+	statusSchema := openAPISchema(reflect.TypeFor[WebhookCertificateStatus](), "status")
+
+	return map[string]interface{}{
+		"apiVersion": "apiextensions.k8s.io/v1",
+		"kind":       "CustomResourceDefinition",
+		"metadata": map[string]interface{}{
+			"name":   webhookCertificateResource.GroupResource().String(),
+			"labels": map[string]interface{}{certificator.ManagedByLabel: certificator.ManagedByValue},
+		},
+		"spec": map[string]interface{}{
+			"group": webhookCertificateGroup,
+			"names": map[string]interface{}{
+				"kind":     webhookCertificateKind,
+				"listKind": webhookCertificateKind + "List",
+				"plural":   webhookCertificateResource.Resource,
+				"singular": strings.ToLower(webhookCertificateKind),
+				"shortNames": []string{
+					"whcert",
+				},
+			},
+			"scope": "Namespaced",
+			"versions": []interface{}{
+				map[string]interface{}{
+					"name":         webhookCertificateVersion,
+					"served":       true,
+					"storage":      true,
+					"subresources": map[string]interface{}{"status": map[string]interface{}{}},
+					"additionalPrinterColumns": []interface{}{
+						printerColumn("Ready", "string", `.status.conditions[?(@.type=="Ready")].status`),
+						printerColumn("Secret", "string", ".spec.secretName"),
+						printerColumn("NotAfter", "date", ".status.notAfter"),
+						printerColumn("Age", "date", ".metadata.creationTimestamp"),
+					},
+					"schema": map[string]interface{}{
+						"openAPIV3Schema": map[string]interface{}{
+							"type":     "object",
+							"required": []string{"spec"},
+							"properties": map[string]interface{}{
+								"apiVersion": map[string]interface{}{"type": "string"},
+								"kind":       map[string]interface{}{"type": "string"},
+								"metadata":   map[string]interface{}{"type": "object"},
+								"spec":       specSchema,
+								"status":     statusSchema,
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func printerColumn(name, columnType, jsonPath string) map[string]interface{} {
+	return map[string]interface{}{"name": name, "type": columnType, "jsonPath": jsonPath}
+}
+
+// openAPISchema returns the structural schema of a JSON serialized type, path is
+// the dotted field path used to look up enums
+func openAPISchema(t reflect.Type, path string) map[string]interface{} {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t {
+	case reflect.TypeFor[metav1.Duration]():
+		return map[string]interface{}{"type": "string"}
+	case reflect.TypeFor[metav1.Time]():
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		property := map[string]interface{}{"type": "string"}
+		if enum, ok := webhookCertificateEnums[path]; ok {
+			property["enum"] = enum
+		}
+		return property
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer", "format": t.Kind().String()}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": openAPISchema(t.Elem(), path)}
+	case reflect.Struct:
+		properties := map[string]interface{}{}
+		var required []string
+		for i := range t.NumField() {
+			field := t.Field(i)
+			name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "" || name == "-" {
+				continue
+			}
+			properties[name] = openAPISchema(field.Type, path+"."+name)
+			if !strings.Contains(options, "omitempty") {
+				required = append(required, name)
+			}
+		}
+		object := map[string]interface{}{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			object["required"] = required
+		}
+		return object
+	default:
+		return map[string]interface{}{"type": "object", "x-kubernetes-preserve-unknown-fields": true}
+	}
+}
@@ -0,0 +1,116 @@
+/*
+Copyright © 2024 Yevhen Lebid ealebed@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certificator
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"time"
+)
+
+// minDurationTolerance absorbs clock skew between the signer and certificator
+const minDurationTolerance = 5 * time.Minute
+
+// ParseCertificates decodes all CERTIFICATE blocks of a PEM bundle
+func ParseCertificates(bundlePEM []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for rest := bundlePEM; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("x509.ParseCertificate: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+
+	return certs, nil
+}
+
+// LeafCertificate decodes the first certificate of a PEM bundle
+func LeafCertificate(bundlePEM []byte) (*x509.Certificate, error) {
+	certs, err := ParseCertificates(bundlePEM)
+	if err != nil {
+		return nil, err
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificate found in PEM bundle")
+	}
+
+	return certs[0], nil
+}
+
+// SplitCertificateChain separates a trailing self-signed CA from an issued certificate
+// chain, so it can be stored as ca.crt next to the serving certificate
+func SplitCertificateChain(chainPEM []byte) (certPEM, caPEM []byte) {
+	certs, err := ParseCertificates(chainPEM)
+	if err != nil || len(certs) < 2 {
+		return chainPEM, nil
+	}
+	root := certs[len(certs)-1]
+	if !root.IsCA || !bytes.Equal(root.RawSubject, root.RawIssuer) || root.CheckSignatureFrom(root) != nil {
+		return chainPEM, nil
+	}
+
+	for _, cert := range certs[:len(certs)-1] {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+
+	return certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.Raw})
+}
+
+// CheckLifetime reports an error when the signer issued a certificate whose
+// NotAfter noticeably differs from the requested duration, e.g. because it ignores
+// expirationSeconds or caps it with its own maximum
+func CheckLifetime(certPEM []byte, requested time.Duration, issuedAt time.Time) error {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return fmt.Errorf("failed to decode issued certificate PEM")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("x509.ParseCertificate: %w", err)
+	}
+
+	expected := issuedAt.Add(requested)
+	tolerance := max(requested/10, minDurationTolerance)
+	if diff := cert.NotAfter.Sub(expected).Abs(); diff > tolerance {
+		return fmt.Errorf("signer issued certificate valid until %s, requested duration %s expected about %s",
+			cert.NotAfter.UTC().Format(time.RFC3339), requested, expected.UTC().Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// RenewalTime returns when the certificate is renewed, renewBefore its expiry, or once a third
+// of its lifetime remains when renewBefore is zero or not shorter than the lifetime
+func RenewalTime(cert *x509.Certificate, renewBefore time.Duration) time.Time {
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	if renewBefore <= 0 || renewBefore >= lifetime {
+		renewBefore = lifetime / 3
+	}
+
+	return cert.NotAfter.Add(-renewBefore)
+}
@@ -0,0 +1,145 @@
+package certificator
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// newTestCertificatePEM returns a self-signed certificate valid until notAfter, a CA when isCA is set
+func newTestCertificatePEM(t *testing.T, notAfter time.Time, isCA bool) []byte {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "webhook-svc.webhook"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              notAfter,
+		IsCA:                  isCA,
+		BasicConstraintsValid: isCA,
+	}
+	if isCA {
+		template.Subject.CommonName = "test-ca"
+		template.KeyUsage = x509.KeyUsageCertSign
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate: %v", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestSplitCertificateChain(t *testing.T) {
+	caPEM := newTestCertificatePEM(t, time.Now().Add(time.Hour), true)
+	leafPEM := newTestCertificatePEM(t, time.Now().Add(time.Hour), false)
+
+	tests := []struct {
+		name     string
+		chainPEM []byte
+		wantCert []byte
+		wantCA   []byte
+	}{
+		{name: "leaf only", chainPEM: leafPEM, wantCert: leafPEM},
+		{name: "leaf and CA", chainPEM: append(append([]byte{}, leafPEM...), caPEM...), wantCert: leafPEM, wantCA: caPEM},
+		{name: "leaf and leaf", chainPEM: append(append([]byte{}, leafPEM...), leafPEM...), wantCert: append(append([]byte{}, leafPEM...), leafPEM...)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			certPEM, gotCA := SplitCertificateChain(tt.chainPEM)
+			if string(certPEM) != string(tt.wantCert) {
+				t.Errorf("Expected certificate:\n%s\ngot:\n%s", tt.wantCert, certPEM)
+			}
+			if string(gotCA) != string(tt.wantCA) {
+				t.Errorf("Expected CA:\n%s\ngot:\n%s", tt.wantCA, gotCA)
+			}
+		})
+	}
+}
+
+func TestCheckLifetime(t *testing.T) {
+	issuedAt := time.Now()
+
+	tests := []struct {
+		name      string
+		requested time.Duration
+		notAfter  time.Time
+		certPEM   []byte
+		wantErr   bool
+	}{
+		{
+			name:      "lifetime as requested",
+			requested: 24 * time.Hour,
+			notAfter:  issuedAt.Add(24 * time.Hour),
+		},
+		{
+			name:      "lifetime within tolerance",
+			requested: time.Hour,
+			notAfter:  issuedAt.Add(time.Hour + 2*time.Minute),
+		},
+		{
+			name:      "signer ignored requested duration",
+			requested: time.Hour,
+			notAfter:  issuedAt.Add(365 * 24 * time.Hour),
+			wantErr:   true,
+		},
+		{
+			name:      "signer capped requested duration",
+			requested: 365 * 24 * time.Hour,
+			notAfter:  issuedAt.Add(30 * 24 * time.Hour),
+			wantErr:   true,
+		},
+		{
+			name:      "malformed certificate",
+			requested: time.Hour,
+			certPEM:   []byte("not a certificate"),
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			certPEM := tt.certPEM
+			if certPEM == nil {
+				certPEM = newTestCertificatePEM(t, tt.notAfter, false)
+			}
+			if err := CheckLifetime(certPEM, tt.requested, issuedAt); (err != nil) != tt.wantErr {
+				t.Errorf("CheckLifetime() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRenewalTime(t *testing.T) {
+	notBefore := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cert := &x509.Certificate{NotBefore: notBefore, NotAfter: notBefore.Add(30 * time.Hour)}
+
+	tests := []struct {
+		name        string
+		renewBefore time.Duration
+		want        time.Time
+	}{
+		{name: "a third of the lifetime by default", want: notBefore.Add(20 * time.Hour)},
+		{name: "renew before expiry", renewBefore: time.Hour, want: notBefore.Add(29 * time.Hour)},
+		{name: "renew before longer than the lifetime", renewBefore: 48 * time.Hour, want: notBefore.Add(20 * time.Hour)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RenewalTime(cert, tt.renewBefore); !got.Equal(tt.want) {
+				t.Errorf("RenewalTime() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+/*
+Copyright © 2024 Yevhen Lebid ealebed@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package certificator issues webhook serving certificates through the Kubernetes
+// CertificateSigningRequest API and stores them in a Secret, the pipeline behind the
+// certificator commands:
+//
+//	issuer, err := certificator.New(clientset, "webhook-svc", "webhook", "webhook-certs",
+//		certificator.WithSignerName("certificator.ealebed.io/webhook-serving"),
+//		certificator.WithKeyAlgorithm(certificator.KeyAlgorithmECDSA),
+//		certificator.WithDNSNames("webhook.example.io"))
+//	if err != nil {
+//		return err
+//	}
+//	result, err := issuer.Renew(ctx)
+//
+// Issue generates a private key and a CSR for the Service DNS names, has the CSR signed and stores
+// the certificate, the key and the CA returned by the signer in a kubernetes.io/tls Secret. The CSR
+// is signed through the Kubernetes CertificateSigningRequest API by default, WithIssuer selects
+// another backend of the issuer package. Inspect reads the stored certificate and Renew issues a
+// new one once it is due.
+package certificator
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// ManagedByLabel marks objects created by certificator, so they can be found later
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+	ManagedByValue = "certificator"
+
+	// owner labels bind a CSR and a Secret to the certificate they were created for
+	ServiceLabel   = "certificator.ealebed.io/service"
+	NamespaceLabel = "certificator.ealebed.io/namespace"
+	SecretLabel    = "certificator.ealebed.io/secret"
+
+	// CACertKey is the Secret key of the CA which signed the certificate
+	CACertKey = "ca.crt"
+
+	// DefaultDuration matches the default signing duration of kube-controller-manager
+	DefaultDuration = 365 * 24 * time.Hour
+	// MinDuration is the lowest expirationSeconds accepted by the CSR API
+	MinDuration = 10 * time.Minute
+	// DefaultTimeout is how long the signer is waited for by default
+	DefaultTimeout = 5 * time.Minute
+
+	// ApprovalSelf makes certificator approve its own CSR, ApprovalExternal leaves
+	// it to a human or an approver controller
+	ApprovalSelf     = "self"
+	ApprovalExternal = "external"
+
+	// KubeAPIServerClientSignerName is the built-in signer used by default
+	KubeAPIServerClientSignerName = "kubernetes.io/kube-apiserver-client"
+)
+
+// OwnerLabels returns labels which mark a CSR and a Secret as created by certificator for the given certificate
+func OwnerLabels(service, namespace, secret string) (map[string]string, error) {
+	labels := map[string]string{
+		ManagedByLabel: ManagedByValue,
+		ServiceLabel:   service,
+		NamespaceLabel: namespace,
+		SecretLabel:    secret,
+	}
+	for key, value := range labels {
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid value %q for label %s: %s", value, key, strings.Join(errs, "; "))
+		}
+	}
+
+	return labels, nil
+}
+
+// ValidateDuration checks the requested certificate lifetime against the CSR API limits
+func ValidateDuration(duration time.Duration) error {
+	if duration < MinDuration {
+		return fmt.Errorf("certificate duration %s is below the minimum of %s", duration, MinDuration)
+	}
+	if duration.Seconds() > math.MaxInt32 {
+		return fmt.Errorf("certificate duration %s is too long", duration)
+	}
+
+	return nil
+}
+
+// ValidateApproval checks the approval mode
+func ValidateApproval(approval string) error {
+	if approval != ApprovalSelf && approval != ApprovalExternal {
+		return fmt.Errorf("unsupported approval mode %q, expected one of: %s, %s", approval, ApprovalSelf, ApprovalExternal)
+	}
+
+	return nil
+}
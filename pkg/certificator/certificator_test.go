@@ -0,0 +1,59 @@
+package certificator
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOwnerLabels(t *testing.T) {
+	tests := []struct {
+		name    string
+		secret  string
+		wantErr bool
+	}{
+		{
+			name:   "valid names",
+			secret: "webhook-certs",
+		},
+		{
+			name:    "secret name too long for a label value",
+			secret:  strings.Repeat("a", 64),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			labels, err := OwnerLabels("webhook-svc", "webhook", tt.secret)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("OwnerLabels() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && labels[SecretLabel] != tt.secret {
+				t.Errorf("Expected label %s=%s, got %v", SecretLabel, tt.secret, labels)
+			}
+		})
+	}
+}
+
+func TestValidateDuration(t *testing.T) {
+	tests := []struct {
+		name     string
+		duration time.Duration
+		wantErr  bool
+	}{
+		{name: "default duration", duration: DefaultDuration},
+		{name: "minimum duration", duration: 10 * time.Minute},
+		{name: "below minimum", duration: 9 * time.Minute, wantErr: true},
+		{name: "too long", duration: 100 * 365 * 24 * time.Hour, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateDuration(tt.duration); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateDuration() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,230 @@
+/*
+Copyright © 2024 Yevhen Lebid ealebed@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certificator
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	certv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/ealebed/admission-webhook-certificator/pkg/issuer"
+)
+
+const (
+	// csrNameSuffixLength is the length of the random suffix which makes CSR names unique
+	csrNameSuffixLength = 5
+
+	csrPollInterval = 1 * time.Second
+)
+
+// csrIssuer is the issuer.Issuer of the Kubernetes CertificateSigningRequest API and the default
+// of an Issuer, it is configured by the signer, approval, timeout and keep CSR options
+type csrIssuer struct {
+	i *Issuer
+	// serviceEvent records the progress on the Service
+	serviceEvent func(eventtype, reason, messageFmt string, args ...any)
+	// csr is the last CSR created, deleted by the Issuer once its certificate is stored
+	csr *certv1.CertificateSigningRequest
+	// wait is how long the last CSR took to be approved and issued
+	wait time.Duration
+}
+
+func (c *csrIssuer) String() string { return c.i.signerName }
+
+// Issue creates the CSR, approves it unless approval is external and waits for the signer.
+// The certificate chain is returned as issued, the CA is known only when it ends the chain. The
+// CSR is kept, so the certificate can be recovered from it until the Issuer stored it.
+func (c *csrIssuer) Issue(ctx context.Context, csrPEM []byte, duration time.Duration) (*issuer.Certificate, error) {
+	i := c.i
+	block, _ := pem.Decode(csrPEM)
+	if block == nil {
+		return nil, Failure(ReasonRequest, fmt.Errorf("failed to decode certificate request PEM"))
+	}
+	request, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, Failure(ReasonRequest, fmt.Errorf("x509.ParseCertificateRequest: %w", err))
+	}
+
+	csr, err := i.createCSR(ctx, newCSR(generateCSRName(request.Subject.CommonName), i.labels, csrPEM, i.signerName, duration))
+	if err != nil {
+		return nil, Failure(ReasonCSRCreate, fmt.Errorf("create CertificateSigningRequest: %w", err))
+	}
+	c.csr = csr
+	created := time.Now()
+	c.serviceEvent(corev1.EventTypeNormal, EventReasonCSRCreated, "CertificateSigningRequest %s created for signer %s", csr.Name, i.signerName)
+
+	if i.approval == ApprovalSelf {
+		if err = i.approveCSR(ctx, csr); err != nil {
+			return nil, Failure(ReasonApproval, fmt.Errorf("approve CertificateSigningRequest %s: %w", csr.Name, err))
+		}
+		c.serviceEvent(corev1.EventTypeNormal, EventReasonCSRApproved, "CertificateSigningRequest %s approved by certificator", csr.Name)
+	} else {
+		i.logger.Printf("Certificate signing request %s, status: Waiting for external approval, approve with: "+
+			"kubectl certificate approve %s", csr.Name, csr.Name)
+	}
+
+	updatedCsr, err := i.retrieveUpdatedCSR(ctx, csr.Name)
+	if err != nil {
+		return nil, Failure(ReasonCSRWait, fmt.Errorf("retrieve updated CertificateSigningRequest %s: %w", csr.Name, err))
+	}
+	c.wait = time.Since(created)
+
+	return &issuer.Certificate{ChainPEM: updatedCsr.Status.Certificate}, nil
+}
+
+// generateCSRName returns a unique CSR name, so concurrent runs for the same
+// service, or other tools using the same naming convention, never collide
+func generateCSRName(prefix string) string {
+	return prefix + "-" + utilrand.String(csrNameSuffixLength)
+}
+
+// newCSR returns the CertificateSigningRequest object of csrPEM
+func newCSR(csrName string, labels map[string]string, csrPEM []byte,
+	signerName string, duration time.Duration) *certv1.CertificateSigningRequest {
+	expirationSeconds := int32(duration.Seconds())
+
+	return &certv1.CertificateSigningRequest{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   csrName,
+			Labels: labels,
+		},
+		Spec: certv1.CertificateSigningRequestSpec{
+			Request:           csrPEM,
+			ExpirationSeconds: &expirationSeconds,
+			Usages:            issuer.ServerUsages,
+			Groups:            []string{"system:authenticated"},
+			SignerName:        signerName,
+		},
+	}
+}
+
+func (i *Issuer) createCSR(ctx context.Context, csr *certv1.CertificateSigningRequest) (*certv1.CertificateSigningRequest, error) {
+	ctx, span := StartSpan(ctx, "createCSR", csrAttributes(csr)...)
+	defer span.End()
+
+	i.logger.Printf("Certificate signing request %s, status: Creating", csr.Name)
+	created, err := i.client.CertificatesV1().CertificateSigningRequests().Create(ctx, csr, metav1.CreateOptions{})
+	if err != nil {
+		i.logger.Printf("Create CertificateSigningRequest - error occurred, detail: %v", err)
+		return nil, SpanError(span, err)
+	}
+	i.logger.Printf("Certificate signing request, status: Created")
+
+	return created, nil
+}
+
+// deleteCSR deletes the CSR only if it is still the object created by this run
+// and carries certificator labels, so a foreign CSR is never removed
+func (i *Issuer) deleteCSR(ctx context.Context, csr *certv1.CertificateSigningRequest) error {
+	if csr.Labels[ManagedByLabel] != ManagedByValue {
+		return fmt.Errorf("certificate signing request %s is not managed by certificator", csr.Name)
+	}
+
+	i.logger.Printf("Certificate signing request, status: Deleting")
+	if err := i.client.CertificatesV1().CertificateSigningRequests().Delete(ctx, csr.Name, metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{UID: &csr.UID},
+	}); err != nil {
+		return err
+	}
+	i.logger.Printf("Certificate signing request, status: Deleted")
+
+	return nil
+}
+
+func (i *Issuer) approveCSR(ctx context.Context, csr *certv1.CertificateSigningRequest) error {
+	ctx, span := StartSpan(ctx, "approveCSR", csrAttributes(csr)...)
+	defer span.End()
+
+	i.logger.Printf("Certificate signing request, status: Approving")
+
+	csr.Status.Conditions = append(csr.Status.Conditions, certv1.CertificateSigningRequestCondition{
+		Type:           certv1.CertificateApproved,
+		Status:         corev1.ConditionTrue,
+		Reason:         "Self-generated and auto-approved by certificator",
+		Message:        "This CSR was approved by certificator cli",
+		LastUpdateTime: metav1.Now(),
+	})
+
+	if _, err := i.client.CertificatesV1().CertificateSigningRequests().UpdateApproval(ctx, csr.Name, csr, metav1.UpdateOptions{}); err != nil {
+		i.logger.Printf("UpdateApproval - error occurred, detail: %v", err)
+		return SpanError(span, err)
+	}
+	i.logger.Printf("Certificate signing request, status: Approved")
+
+	return nil
+}
+
+// retrieveUpdatedCSR waits until the CSR is issued a certificate, and fails early
+// when it is denied or the signer marks it as failed
+func (i *Issuer) retrieveUpdatedCSR(ctx context.Context, csrName string) (*certv1.CertificateSigningRequest, error) {
+	ctx, span := StartSpan(ctx, "retrieveUpdatedCSR", AttrCSRName.String(csrName))
+	defer span.End()
+
+	i.logger.Printf("Certificate signing request, status: Retrieving updated CSR")
+
+	csrClient := i.client.CertificatesV1().CertificateSigningRequests()
+	var updatedCsr *certv1.CertificateSigningRequest
+	err := wait.PollUntilContextTimeout(ctx, csrPollInterval, i.timeout, true, func(ctx context.Context) (bool, error) {
+		res, err := csrClient.Get(ctx, csrName, metav1.GetOptions{})
+		if err != nil {
+			return false, fmt.Errorf("get CertificateSigningRequest: %w", err)
+		}
+		updatedCsr = res
+		span.SetAttributes(csrAttributes(updatedCsr)...)
+
+		for _, condition := range updatedCsr.Status.Conditions {
+			if condition.Status != corev1.ConditionTrue {
+				continue
+			}
+			switch condition.Type {
+			case certv1.CertificateDenied:
+				return false, Failure(ReasonDenied, fmt.Errorf("certificate signing request %s was denied, reason: %s, message: %s",
+					csrName, condition.Reason, condition.Message))
+			case certv1.CertificateFailed:
+				return false, Failure(ReasonSigner, fmt.Errorf("certificate signing request %s failed, reason: %s, message: %s",
+					csrName, condition.Reason, condition.Message))
+			}
+		}
+
+		if updatedCsr.Status.Certificate != nil {
+			i.logger.Printf("Certificate signing request, status: Certificate Found")
+			return true, nil
+		}
+		i.logger.Printf("Certificate signing request, status: No certificate found trying after %s", csrPollInterval)
+
+		return false, nil
+	})
+	if wait.Interrupted(err) {
+		i.logger.Printf("Certificate signing request, status: No certificate found, backed off after %s", i.timeout)
+		return nil, SpanError(span, Failure(ReasonTimeout, fmt.Errorf("certificate signing request, status: No certificate found")))
+	}
+	if err != nil {
+		return nil, SpanError(span, err)
+	}
+
+	i.logger.Printf("Certificate signing request, status: Retrieved")
+
+	return updatedCsr, nil
+}
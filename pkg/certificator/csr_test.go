@@ -0,0 +1,241 @@
+package certificator
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	certv1 "k8s.io/api/certificates/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestNewCSR(t *testing.T) {
+	tests := []struct {
+		name     string
+		csrName  string
+		csrPEM   []byte
+		wantErr  bool
+		validate func(t *testing.T, csr *certv1.CertificateSigningRequest)
+	}{
+		{
+			name:    "valid CSR object",
+			csrName: "webhook-svc.webhook",
+			csrPEM:  []byte("test-csr-data"),
+			wantErr: false,
+			validate: func(t *testing.T, csr *certv1.CertificateSigningRequest) {
+				if csr == nil {
+					t.Fatal("CSR object should not be nil")
+				}
+				if csr.Name != "webhook-svc.webhook" {
+					t.Errorf("Expected CSR name 'webhook-svc.webhook', got '%s'", csr.Name)
+				}
+				if len(csr.Spec.Request) == 0 {
+					t.Error("CSR request should not be empty")
+				}
+				if len(csr.Spec.Usages) == 0 {
+					t.Error("CSR usages should not be empty")
+				}
+				// Validate required usages
+				expectedUsages := []certv1.KeyUsage{
+					certv1.UsageDigitalSignature,
+					certv1.UsageKeyEncipherment,
+					certv1.UsageServerAuth,
+				}
+				if len(csr.Spec.Usages) != len(expectedUsages) {
+					t.Errorf("Expected %d usages, got %d", len(expectedUsages), len(csr.Spec.Usages))
+				}
+				for _, expected := range expectedUsages {
+					found := false
+					for _, usage := range csr.Spec.Usages {
+						if usage == expected {
+							found = true
+							break
+						}
+					}
+					if !found {
+						t.Errorf("Expected usage '%s' not found", expected)
+					}
+				}
+				if csr.Spec.SignerName != "kubernetes.io/kube-apiserver-client" {
+					t.Errorf("Expected signer name 'kubernetes.io/kube-apiserver-client', got '%s'", csr.Spec.SignerName)
+				}
+				if len(csr.Spec.Groups) == 0 || csr.Spec.Groups[0] != "system:authenticated" {
+					t.Errorf("Expected groups to contain 'system:authenticated', got %v", csr.Spec.Groups)
+				}
+				if csr.Labels[ManagedByLabel] != ManagedByValue || csr.Labels[ServiceLabel] != "webhook-svc" {
+					t.Errorf("Expected certificator owner labels, got %v", csr.Labels)
+				}
+				if csr.Spec.ExpirationSeconds == nil || *csr.Spec.ExpirationSeconds != int32(DefaultDuration.Seconds()) {
+					t.Errorf("Expected expirationSeconds %d, got %v", int32(DefaultDuration.Seconds()), csr.Spec.ExpirationSeconds)
+				}
+			},
+		},
+		{
+			name:    "CSR with different name",
+			csrName: "test-service.default",
+			csrPEM:  []byte("test-csr-data"),
+			wantErr: false,
+			validate: func(t *testing.T, csr *certv1.CertificateSigningRequest) {
+				if csr.Name != "test-service.default" {
+					t.Errorf("Expected CSR name 'test-service.default', got '%s'", csr.Name)
+				}
+			},
+		},
+		{
+			name:    "CSR with empty request",
+			csrName: "test-service.default",
+			csrPEM:  []byte{},
+			wantErr: false,
+			validate: func(t *testing.T, csr *certv1.CertificateSigningRequest) {
+				if csr == nil {
+					t.Fatal("CSR object should not be nil even with empty request")
+				}
+				if len(csr.Spec.Request) != 0 {
+					t.Error("CSR request should be empty when the request is empty")
+				}
+			},
+		},
+		{
+			name:    "CSR with long name",
+			csrName: "very-long-service-name.very-long-namespace-name",
+			csrPEM:  []byte("test-csr-data"),
+			wantErr: false,
+			validate: func(t *testing.T, csr *certv1.CertificateSigningRequest) {
+				if csr.Name != "very-long-service-name.very-long-namespace-name" {
+					t.Errorf("Expected CSR name 'very-long-service-name.very-long-namespace-name', got '%s'", csr.Name)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			labels, _ := OwnerLabels("webhook-svc", "webhook", "webhook-certs")
+			csr := newCSR(tt.csrName, labels, tt.csrPEM, KubeAPIServerClientSignerName, DefaultDuration)
+			if csr == nil && !tt.wantErr {
+				t.Error("newCSR() returned nil, expected valid CSR object")
+				return
+			}
+			if !tt.wantErr && tt.validate != nil {
+				tt.validate(t, csr)
+			}
+		})
+	}
+}
+
+func TestGenerateCSRName(t *testing.T) {
+	first := generateCSRName("webhook-svc.webhook")
+	second := generateCSRName("webhook-svc.webhook")
+
+	if !strings.HasPrefix(first, "webhook-svc.webhook-") {
+		t.Errorf("Expected CSR name prefix 'webhook-svc.webhook-', got '%s'", first)
+	}
+	if len(first) != len("webhook-svc.webhook-")+csrNameSuffixLength {
+		t.Errorf("Expected random suffix of %d characters, got '%s'", csrNameSuffixLength, first)
+	}
+	if first == second {
+		t.Errorf("Expected unique CSR names, got '%s' twice", first)
+	}
+}
+
+func TestDeleteCSR(t *testing.T) {
+	labels, _ := OwnerLabels("webhook-svc", "webhook", "webhook-certs")
+
+	tests := []struct {
+		name    string
+		csr     *certv1.CertificateSigningRequest
+		wantErr bool
+	}{
+		{
+			name: "managed CSR is deleted",
+			csr:  &certv1.CertificateSigningRequest{ObjectMeta: metav1.ObjectMeta{Name: "webhook-svc.webhook-abcde", Labels: labels}},
+		},
+		{
+			name:    "foreign CSR is kept",
+			csr:     &certv1.CertificateSigningRequest{ObjectMeta: metav1.ObjectMeta{Name: "webhook-svc.webhook"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := fake.NewClientset(tt.csr)
+			csrClient := cs.CertificatesV1().CertificateSigningRequests()
+
+			err := newTestIssuer(t, cs).deleteCSR(context.TODO(), tt.csr)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("deleteCSR() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			_, getErr := csrClient.Get(context.TODO(), tt.csr.Name, metav1.GetOptions{})
+			if tt.wantErr && getErr != nil {
+				t.Errorf("Expected foreign CSR to be kept, got %v", getErr)
+			}
+			if !tt.wantErr && getErr == nil {
+				t.Error("Expected managed CSR to be deleted")
+			}
+		})
+	}
+}
+
+func TestRetrieveUpdatedCSR(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     certv1.CertificateSigningRequestStatus
+		wantErr    bool
+		wantDetail string
+	}{
+		{
+			name:   "certificate issued",
+			status: certv1.CertificateSigningRequestStatus{Certificate: []byte("certificate")},
+		},
+		{
+			name: "denied by approver",
+			status: certv1.CertificateSigningRequestStatus{Conditions: []certv1.CertificateSigningRequestCondition{{
+				Type:    certv1.CertificateDenied,
+				Status:  "True",
+				Reason:  "PolicyViolation",
+				Message: "usages are not allowed",
+			}}},
+			wantErr:    true,
+			wantDetail: "reason: PolicyViolation, message: usages are not allowed",
+		},
+		{
+			name: "failed by signer",
+			status: certv1.CertificateSigningRequestStatus{Conditions: []certv1.CertificateSigningRequestCondition{{
+				Type:   certv1.CertificateFailed,
+				Status: "True",
+				Reason: "SignerValidationFailure",
+			}}},
+			wantErr:    true,
+			wantDetail: "reason: SignerValidationFailure",
+		},
+		{
+			name:       "not approved before timeout",
+			wantErr:    true,
+			wantDetail: "No certificate found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			csr := &certv1.CertificateSigningRequest{
+				ObjectMeta: metav1.ObjectMeta{Name: "webhook-svc.webhook-abcde"},
+				Status:     tt.status,
+			}
+			issuer := newTestIssuer(t, fake.NewClientset(csr), WithTimeout(100*time.Millisecond))
+
+			updated, err := issuer.retrieveUpdatedCSR(context.TODO(), csr.Name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("retrieveUpdatedCSR() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !strings.Contains(err.Error(), tt.wantDetail) {
+				t.Errorf("Expected error to contain '%s', got '%v'", tt.wantDetail, err)
+			}
+			if !tt.wantErr && string(updated.Status.Certificate) != "certificate" {
+				t.Errorf("Expected issued certificate, got '%s'", updated.Status.Certificate)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+/*
+Copyright © 2024 Yevhen Lebid ealebed@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certificator
+
+import "errors"
+
+// issuance failure reasons, the phase of the pipeline which failed
+const (
+	ReasonRequest   = "request"
+	ReasonCSRCreate = "csr_create"
+	ReasonApproval  = "approval"
+	ReasonCSRWait   = "csr_wait"
+	ReasonDenied    = "denied"
+	ReasonSigner    = "signer_failed"
+	ReasonTimeout   = "timeout"
+	ReasonLifetime  = "lifetime"
+	ReasonSecret    = "secret"
+	ReasonUnknown   = "unknown"
+)
+
+// Error is an issuance error together with the phase which failed
+type Error struct {
+	Reason string
+	Err    error
+}
+
+func (e *Error) Error() string { return e.Err.Error() }
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Failure attaches reason to err, unless err already carries a more specific one
+func Failure(reason string, err error) error {
+	var failure *Error
+	if errors.As(err, &failure) {
+		return err
+	}
+
+	return &Error{Reason: reason, Err: err}
+}
+
+// FailureReason returns the reason attached to err by Failure, ReasonUnknown without one
+func FailureReason(err error) string {
+	var failure *Error
+	if errors.As(err, &failure) {
+		return failure.Reason
+	}
+
+	return ReasonUnknown
+}
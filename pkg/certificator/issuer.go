@@ -0,0 +1,360 @@
+/*
+Copyright © 2024 Yevhen Lebid ealebed@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certificator
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"maps"
+	"net"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/ealebed/admission-webhook-certificator/pkg/issuer"
+)
+
+// Event reasons recorded on the Service and the Secret when an EventRecorder is set
+const (
+	EventReasonCSRCreated        = "CSRCreated"
+	EventReasonCSRApproved       = "CSRApproved"
+	EventReasonCertificateIssued = "CertificateIssued"
+	EventReasonSecretUpdated     = "SecretUpdated"
+)
+
+// Logger receives the progress of the issuance, *log.Logger satisfies it
+type Logger interface {
+	Printf(format string, v ...any)
+}
+
+// Issuer issues the serving certificate of a Service into a Secret
+type Issuer struct {
+	client    kubernetes.Interface
+	service   string
+	namespace string
+	secret    string
+
+	backend        issuer.Issuer
+	signerName     string
+	request        Request
+	duration       time.Duration
+	strictDuration bool
+	approval       string
+	timeout        time.Duration
+	keepCSR        bool
+	renewBefore    time.Duration
+	labels         map[string]string
+	recorder       record.EventRecorder
+	logger         Logger
+}
+
+// Option configures an Issuer
+type Option func(*Issuer)
+
+// WithIssuer sets the backend signing the certificate request, the Kubernetes CSR API by default.
+// The signer, approval and keep CSR options apply to the Kubernetes CSR API only.
+func WithIssuer(backend issuer.Issuer) Option {
+	return func(i *Issuer) {
+		i.backend = backend
+	}
+}
+
+// WithSignerName sets the signer of the CSR, KubeAPIServerClientSignerName by default
+func WithSignerName(signerName string) Option {
+	return func(i *Issuer) {
+		i.signerName = signerName
+	}
+}
+
+// WithKeyAlgorithm sets the algorithm of the private key, KeyAlgorithmRSA by default
+func WithKeyAlgorithm(algorithm string) Option {
+	return func(i *Issuer) {
+		i.request.KeyAlgorithm = algorithm
+	}
+}
+
+// WithDNSNames adds DNS names to the Service DNS names of the certificate
+func WithDNSNames(names ...string) Option {
+	return func(i *Issuer) {
+		i.request.DNSNames = append(i.request.DNSNames, names...)
+	}
+}
+
+// WithIPAddresses adds IP SANs to the certificate
+func WithIPAddresses(ips ...net.IP) Option {
+	return func(i *Issuer) {
+		i.request.IPAddresses = append(i.request.IPAddresses, ips...)
+	}
+}
+
+// WithDuration sets the requested certificate lifetime, DefaultDuration by default
+func WithDuration(duration time.Duration) Option {
+	return func(i *Issuer) {
+		i.duration = duration
+	}
+}
+
+// WithStrictDuration makes Issue fail, instead of logging a warning, when the signer
+// doesn't honor the requested lifetime
+func WithStrictDuration(strict bool) Option {
+	return func(i *Issuer) {
+		i.strictDuration = strict
+	}
+}
+
+// WithApproval sets who approves the CSR, ApprovalSelf by default
+func WithApproval(approval string) Option {
+	return func(i *Issuer) {
+		i.approval = approval
+	}
+}
+
+// WithTimeout sets how long the CSR is waited for to be approved and issued, DefaultTimeout by default
+func WithTimeout(timeout time.Duration) Option {
+	return func(i *Issuer) {
+		i.timeout = timeout
+	}
+}
+
+// WithKeepCSR keeps the CSR after the certificate has been stored
+func WithKeepCSR(keep bool) Option {
+	return func(i *Issuer) {
+		i.keepCSR = keep
+	}
+}
+
+// WithRenewBefore sets how long before its expiry Renew replaces the certificate, once a third
+// of its lifetime remains by default
+func WithRenewBefore(renewBefore time.Duration) Option {
+	return func(i *Issuer) {
+		i.renewBefore = renewBefore
+	}
+}
+
+// WithLabels adds labels to the CSR and the Secret next to the owner labels
+func WithLabels(labels map[string]string) Option {
+	return func(i *Issuer) {
+		maps.Copy(i.labels, labels)
+	}
+}
+
+// WithEventRecorder records the progress as Events on the Service and the Secret
+func WithEventRecorder(recorder record.EventRecorder) Option {
+	return func(i *Issuer) {
+		i.recorder = recorder
+	}
+}
+
+// WithLogger sets the Logger receiving the progress, log.Default() by default
+func WithLogger(logger Logger) Option {
+	return func(i *Issuer) {
+		i.logger = logger
+	}
+}
+
+// New returns an Issuer of the certificate of service in namespace, stored in the Secret secret
+func New(client kubernetes.Interface, service, namespace, secret string, options ...Option) (*Issuer, error) {
+	labels, err := OwnerLabels(service, namespace, secret)
+	if err != nil {
+		return nil, err
+	}
+	i := &Issuer{
+		client:     client,
+		service:    service,
+		namespace:  namespace,
+		secret:     secret,
+		signerName: KubeAPIServerClientSignerName,
+		duration:   DefaultDuration,
+		approval:   ApprovalSelf,
+		timeout:    DefaultTimeout,
+		labels:     labels,
+		logger:     log.Default(),
+	}
+	for _, option := range options {
+		option(i)
+	}
+
+	if err = ValidateDuration(i.duration); err != nil {
+		return nil, err
+	}
+	if err = ValidateApproval(i.approval); err != nil {
+		return nil, err
+	}
+
+	return i, nil
+}
+
+// Result is the outcome of an issuance
+type Result struct {
+	// Secret the certificate is stored in
+	Secret *corev1.Secret
+	// Certificate is the issued leaf certificate
+	Certificate *x509.Certificate
+	// CertificatePEM is the issued certificate chain without the CA
+	CertificatePEM []byte
+	// CAPEM is the CA returned by the issuer, nil when it returned none, like the kubernetes.io/* signers
+	CAPEM []byte
+	// CSRName is the name of the CertificateSigningRequest, empty unless issued through the CSR API
+	CSRName string
+	// CSRWait is how long the CSR took to be approved and issued, zero unless issued through the CSR API
+	CSRWait time.Duration
+}
+
+// Issue issues a new certificate and stores it in the Secret, whether the current one is due or not.
+// Errors carry the failed phase, see FailureReason.
+func (i *Issuer) Issue(ctx context.Context) (*Result, error) {
+	serviceEvent := i.serviceEvents(ctx)
+
+	_, span := StartSpan(ctx, "generateCertificateRequest", AttrNamespace.String(i.namespace))
+	csrPEM, keyPEM, _, err := GenerateRequest(i.service, i.namespace, &i.request)
+	endSpan(span, err)
+	if err != nil {
+		return nil, Failure(ReasonRequest, err)
+	}
+
+	backend := i.backend
+	if backend == nil {
+		backend = &csrIssuer{i: i, serviceEvent: serviceEvent}
+	}
+	result, err := i.sign(ctx, backend, csrPEM)
+	if err != nil {
+		return nil, err
+	}
+	if err = CheckLifetime(result.CertificatePEM, i.duration, time.Now()); err != nil {
+		if i.strictDuration {
+			return nil, Failure(ReasonLifetime, err)
+		}
+		i.logger.Printf("Certificate, status: Warning, %v", err)
+	}
+	if result.Certificate, err = LeafCertificate(result.CertificatePEM); err == nil {
+		serviceEvent(corev1.EventTypeNormal, EventReasonCertificateIssued, "Certificate issued by %s, valid until %s",
+			backend, result.Certificate.NotAfter.UTC().Format(time.RFC3339))
+	}
+
+	if result.Secret, err = i.createOrUpdateSecret(ctx, result.CertificatePEM, result.CAPEM, keyPEM); err != nil {
+		return nil, Failure(ReasonSecret, fmt.Errorf("store secret %s/%s: %w", i.namespace, i.secret, err))
+	}
+	if i.recorder != nil {
+		i.recorder.Eventf(result.Secret, corev1.EventTypeNormal, EventReasonSecretUpdated, "Certificate issued by %s stored", backend)
+	}
+
+	if csr, ok := backend.(*csrIssuer); ok && !i.keepCSR {
+		// the certificate is safely stored, the CSR has no further use
+		if err = i.deleteCSR(ctx, csr.csr); err != nil {
+			i.logger.Printf("Delete CertificateSigningRequest - error occurred, detail: %v, but ignored", err)
+		}
+	}
+
+	return result, nil
+}
+
+// sign has backend issue a certificate for csrPEM, errors of backends other than the CSR API
+// are signer failures
+func (i *Issuer) sign(ctx context.Context, backend issuer.Issuer, csrPEM []byte) (*Result, error) {
+	certificate, err := backend.Issue(ctx, csrPEM, i.duration)
+	if err != nil {
+		return nil, Failure(ReasonSigner, err)
+	}
+
+	result := &Result{CertificatePEM: certificate.ChainPEM, CAPEM: certificate.CAPEM}
+	if result.CAPEM == nil {
+		// signers may return the CA certificate at the end of the chain
+		result.CertificatePEM, result.CAPEM = SplitCertificateChain(certificate.ChainPEM)
+	}
+	if csr, ok := backend.(*csrIssuer); ok {
+		result.CSRName, result.CSRWait = csr.csr.Name, csr.wait
+	}
+
+	return result, nil
+}
+
+// serviceEvents returns a function recording Events on the Service, the Issuer may run before
+// the Service is created or without an EventRecorder, its Events are skipped then
+func (i *Issuer) serviceEvents(ctx context.Context) func(eventtype, reason, messageFmt string, args ...any) {
+	var service *corev1.Service
+	if i.recorder != nil {
+		var err error
+		if service, err = i.client.CoreV1().Services(i.namespace).Get(ctx, i.service, metav1.GetOptions{}); err != nil {
+			i.logger.Printf("Service %s/%s, status: Not available for Events, %v", i.namespace, i.service, err)
+			service = nil
+		}
+	}
+
+	return func(eventtype, reason, messageFmt string, args ...any) {
+		if service != nil {
+			i.recorder.Eventf(service, eventtype, reason, messageFmt, args...)
+		}
+	}
+}
+
+// Status is the certificate currently stored in the Secret
+type Status struct {
+	// Secret is nil when it doesn't exist yet
+	Secret *corev1.Secret
+	// Certificate is nil when the Secret holds no valid certificate
+	Certificate *x509.Certificate
+	// RenewalTime is when Renew replaces the certificate
+	RenewalTime time.Time
+}
+
+// Due reports whether the certificate is missing or due for renewal at now
+func (s *Status) Due(now time.Time) bool {
+	return s.Certificate == nil || !now.Before(s.RenewalTime)
+}
+
+// Inspect reads the certificate stored in the Secret, a missing Secret is not an error
+func (i *Issuer) Inspect(ctx context.Context) (*Status, error) {
+	secret, err := i.client.CoreV1().Secrets(i.namespace).Get(ctx, i.secret, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return &Status{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get secret %s/%s: %w", i.namespace, i.secret, err)
+	}
+
+	status := &Status{Secret: secret}
+	cert, err := LeafCertificate(secret.Data[corev1.TLSCertKey])
+	if err != nil {
+		i.logger.Printf("Secret %s/%s, status: No valid certificate, %v", i.namespace, i.secret, err)
+		return status, nil
+	}
+	status.Certificate, status.RenewalTime = cert, RenewalTime(cert, i.renewBefore)
+
+	return status, nil
+}
+
+// Renew issues a new certificate when the stored one is missing or due for renewal, it
+// returns a nil Result when the certificate is up to date
+func (i *Issuer) Renew(ctx context.Context) (*Result, error) {
+	status, err := i.Inspect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !status.Due(time.Now()) {
+		i.logger.Printf("Secret %s/%s, status: Up to date, renewal at %s", i.namespace, i.secret,
+			status.RenewalTime.UTC().Format(time.RFC3339))
+		return nil, nil
+	}
+
+	return i.Issue(ctx)
+}
@@ -0,0 +1,311 @@
+package certificator
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	certv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/ealebed/admission-webhook-certificator/pkg/issuer"
+)
+
+// newTestIssuer returns an Issuer of webhook-svc in webhook, stored in webhook-certs, which doesn't log
+func newTestIssuer(t *testing.T, cs *fake.Clientset, options ...Option) *Issuer {
+	t.Helper()
+
+	issuer, err := New(cs, "webhook-svc", "webhook", "webhook-certs", append([]Option{WithLogger(log.New(io.Discard, "", 0))}, options...)...)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	return issuer
+}
+
+// testSigner is a CA signing the CSRs of a fake clientset as they are created
+type testSigner struct {
+	cert    *x509.Certificate
+	certPEM []byte
+	key     crypto.Signer
+	// lifetime overrides the requested expirationSeconds when set
+	lifetime time.Duration
+}
+
+func newTestSigner(t *testing.T) *testSigner {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * 365 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+
+	return &testSigner{cert: cert, certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), key: key}
+}
+
+// install makes cs issue CSRs as they are created, with the CA at the end of the chain
+func (s *testSigner) install(cs *fake.Clientset) {
+	cs.PrependReactor("create", "certificatesigningrequests", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		csr := action.(k8stesting.CreateAction).GetObject().(*certv1.CertificateSigningRequest)
+		block, _ := pem.Decode(csr.Spec.Request)
+		request, err := x509.ParseCertificateRequest(block.Bytes)
+		if err != nil {
+			return true, nil, err
+		}
+		lifetime := s.lifetime
+		if lifetime == 0 {
+			lifetime = time.Duration(*csr.Spec.ExpirationSeconds) * time.Second
+		}
+		now := time.Now()
+		der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+			SerialNumber: big.NewInt(now.UnixNano()),
+			Subject:      request.Subject,
+			DNSNames:     request.DNSNames,
+			NotBefore:    now,
+			NotAfter:     now.Add(lifetime),
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}, s.cert, request.PublicKey, s.key)
+		if err != nil {
+			return true, nil, err
+		}
+		csr.Status.Certificate = append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), s.certPEM...)
+		return false, nil, nil
+	})
+}
+
+func TestIssuerIssue(t *testing.T) {
+	tests := []struct {
+		name       string
+		options    []Option
+		lifetime   time.Duration
+		existing   *corev1.Secret
+		failSecret bool
+		wantReason string
+		wantCSRs   int
+	}{
+		{
+			name:    "issues certificate into new Secret",
+			options: []Option{WithDuration(time.Hour), WithDNSNames("webhook.example.io")},
+		},
+		{
+			name:     "updates existing Secret and keeps CSR",
+			options:  []Option{WithDuration(time.Hour), WithKeepCSR(true)},
+			existing: &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "webhook-certs", Namespace: "webhook"}},
+			wantCSRs: 1,
+		},
+		{
+			name:     "warns about a lifetime differing from the requested one",
+			options:  []Option{WithDuration(time.Hour)},
+			lifetime: 24 * time.Hour,
+		},
+		{
+			name:       "fails on a lifetime differing from the requested one with strict duration",
+			options:    []Option{WithDuration(time.Hour), WithStrictDuration(true)},
+			lifetime:   24 * time.Hour,
+			wantReason: ReasonLifetime,
+			wantCSRs:   1,
+		},
+		{
+			name:       "keeps the CSR when the Secret is not stored",
+			options:    []Option{WithDuration(time.Hour)},
+			failSecret: true,
+			wantReason: ReasonSecret,
+			wantCSRs:   1,
+		},
+		{
+			name:    "issues through another issuer",
+			options: []Option{WithDuration(time.Hour), WithIssuer(issuer.SelfSigned{})},
+		},
+		{
+			name:       "fails when the issuer fails",
+			options:    []Option{WithIssuer(issuer.NewSecretCA(fake.NewClientset(), "certificator", "missing-ca"))},
+			wantReason: ReasonSigner,
+		},
+		{
+			name:       "times out waiting for external approval",
+			options:    []Option{WithApproval(ApprovalExternal), WithTimeout(100 * time.Millisecond)},
+			wantReason: ReasonTimeout,
+			wantCSRs:   1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := fake.NewClientset()
+			if tt.existing != nil {
+				cs = fake.NewClientset(tt.existing)
+			}
+			signer := newTestSigner(t)
+			signer.lifetime = tt.lifetime
+			if tt.wantReason != ReasonTimeout {
+				signer.install(cs)
+			}
+			if tt.failSecret {
+				cs.PrependReactor("create", "secrets", func(k8stesting.Action) (bool, runtime.Object, error) {
+					return true, nil, errors.New("etcd unavailable")
+				})
+			}
+
+			result, err := newTestIssuer(t, cs, tt.options...).Issue(context.TODO())
+			if tt.wantReason != "" {
+				if FailureReason(err) != tt.wantReason {
+					t.Fatalf("Issue() error = %v, want reason %s", err, tt.wantReason)
+				}
+			} else if err != nil {
+				t.Fatalf("Issue() error = %v", err)
+			}
+
+			csrs, _ := cs.CertificatesV1().CertificateSigningRequests().List(context.TODO(), metav1.ListOptions{})
+			if len(csrs.Items) != tt.wantCSRs {
+				t.Errorf("Expected %d CSRs left, got %d", tt.wantCSRs, len(csrs.Items))
+			}
+			if err != nil {
+				return
+			}
+
+			secret, err := cs.CoreV1().Secrets("webhook").Get(context.TODO(), "webhook-certs", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("Expected Secret stored, got %v", err)
+			}
+			if secret.Type != corev1.SecretTypeTLS || secret.Labels[ManagedByLabel] != ManagedByValue {
+				t.Errorf("Expected managed kubernetes.io/tls Secret, got %v", secret)
+			}
+			if string(secret.Data[corev1.TLSCertKey]) != string(result.CertificatePEM) || string(secret.Data[CACertKey]) != string(result.CAPEM) {
+				t.Error("Expected issued certificate and its CA stored")
+			}
+			roots := x509.NewCertPool()
+			roots.AppendCertsFromPEM(secret.Data[CACertKey])
+			if _, err = result.Certificate.Verify(x509.VerifyOptions{Roots: roots, DNSName: "webhook-svc.webhook.svc"}); err != nil {
+				t.Errorf("Expected certificate trusted by the stored CA, got %v", err)
+			}
+			if result.Certificate == nil || result.Certificate.Subject.CommonName != "webhook-svc.webhook" {
+				t.Errorf("Expected leaf certificate of webhook-svc.webhook, got %v", result.Certificate)
+			}
+			if result.CSRName == "" && result.CSRWait != 0 {
+				t.Errorf("Expected no CSR wait without a CSR, got %s", result.CSRWait)
+			}
+		})
+	}
+}
+
+func TestIssuerIssueEvents(t *testing.T) {
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "webhook-svc", Namespace: "webhook"}}
+	cs := fake.NewClientset(service)
+	newTestSigner(t).install(cs)
+	recorder := record.NewFakeRecorder(10)
+
+	if _, err := newTestIssuer(t, cs, WithEventRecorder(recorder)).Issue(context.TODO()); err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+
+	close(recorder.Events)
+	var got []string
+	for event := range recorder.Events {
+		got = append(got, event)
+	}
+	want := []string{EventReasonCSRCreated, EventReasonCSRApproved, EventReasonCertificateIssued, EventReasonSecretUpdated}
+	if len(got) != len(want) {
+		t.Fatalf("Expected events %v, got %v", want, got)
+	}
+	for i, reason := range want {
+		if !strings.HasPrefix(got[i], corev1.EventTypeNormal+" "+reason+" ") {
+			t.Errorf("Expected event %d to be %s, got %q", i, reason, got[i])
+		}
+	}
+}
+
+func TestIssuerRenew(t *testing.T) {
+	tests := []struct {
+		name      string
+		certPEM   []byte
+		wantIssue bool
+	}{
+		{name: "issues missing certificate", wantIssue: true},
+		{name: "keeps up to date certificate", certPEM: newTestCertificatePEM(t, time.Now().Add(24*time.Hour), false)},
+		{name: "renews expiring certificate", certPEM: newTestCertificatePEM(t, time.Now().Add(time.Second), false), wantIssue: true},
+		{name: "replaces malformed certificate", certPEM: []byte("not a certificate"), wantIssue: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := fake.NewClientset()
+			if tt.certPEM != nil {
+				cs = fake.NewClientset(&corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{Name: "webhook-certs", Namespace: "webhook"},
+					Data:       map[string][]byte{corev1.TLSCertKey: tt.certPEM},
+				})
+			}
+			newTestSigner(t).install(cs)
+			issuer := newTestIssuer(t, cs, WithDuration(time.Hour))
+
+			status, err := issuer.Inspect(context.TODO())
+			if err != nil {
+				t.Fatalf("Inspect() error = %v", err)
+			}
+			if status.Due(time.Now()) != tt.wantIssue {
+				t.Errorf("Expected Due() = %t, got status %+v", tt.wantIssue, status)
+			}
+
+			result, err := issuer.Renew(context.TODO())
+			if err != nil {
+				t.Fatalf("Renew() error = %v", err)
+			}
+			if (result != nil) != tt.wantIssue {
+				t.Errorf("Expected certificate issued = %t, got %+v", tt.wantIssue, result)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		secret  string
+		options []Option
+		wantErr bool
+	}{
+		{name: "defaults", secret: "webhook-certs"},
+		{name: "invalid secret label value", secret: strings.Repeat("a", 64), wantErr: true},
+		{name: "duration below minimum", secret: "webhook-certs", options: []Option{WithDuration(time.Minute)}, wantErr: true},
+		{name: "unsupported approval", secret: "webhook-certs", options: []Option{WithApproval("manual")}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(fake.NewClientset(), "webhook-svc", "webhook", tt.secret, tt.options...); (err != nil) != tt.wantErr {
+				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+/*
+Copyright © 2024 Yevhen Lebid ealebed@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certificator
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+)
+
+const (
+	csrNameTemplate0 = "${service}"
+	csrNameTemplate1 = "${service}.${namespace}"
+	csrNameTemplate2 = "${service}.${namespace}.svc"
+
+	// key algorithms of the generated private key, RSA 2048 or ECDSA P-256
+	KeyAlgorithmRSA   = "RSA"
+	KeyAlgorithmECDSA = "ECDSA"
+)
+
+// Request extends the certificate request beyond the Service DNS names
+type Request struct {
+	// DNSNames are added to the Service DNS names
+	DNSNames []string
+	// IPAddresses are added as IP SANs
+	IPAddresses []net.IP
+	// KeyAlgorithm of the private key, KeyAlgorithmRSA when empty
+	KeyAlgorithm string
+}
+
+// GenerateRequest generates a private key and a CSR for the Service DNS names, request adds names
+// and selects the key algorithm and may be nil. It returns both in PEM and the common name of the CSR.
+func GenerateRequest(service, namespace string, request *Request) (csrPEM, keyPEM []byte, commonName string, err error) {
+	if request == nil {
+		request = &Request{}
+	}
+	r := strings.NewReplacer("${service}", service, "${namespace}", namespace)
+
+	privateKey, keyBlock, err := generatePrivateKey(request.KeyAlgorithm)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	csrNameWithService := r.Replace(csrNameTemplate0)
+	csrNameWithServiceAndNamespace := r.Replace(csrNameTemplate1)
+	csrNameFull := r.Replace(csrNameTemplate2)
+
+	dnsNames := []string{csrNameWithService, csrNameWithServiceAndNamespace, csrNameFull}
+	for _, name := range request.DNSNames {
+		if !slices.Contains(dnsNames, name) {
+			dnsNames = append(dnsNames, name)
+		}
+	}
+	template := x509.CertificateRequest{
+		Subject: pkix.Name{
+			CommonName: csrNameWithServiceAndNamespace,
+		},
+		DNSNames:    dnsNames,
+		IPAddresses: request.IPAddresses,
+	}
+
+	csrBytes, err := x509.CreateCertificateRequest(rand.Reader, &template, privateKey)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("x509.CreateCertificateRequest: %w", err)
+	}
+
+	csrPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrBytes})
+	keyPEM = pem.EncodeToMemory(keyBlock)
+
+	return csrPEM, keyPEM, csrNameWithServiceAndNamespace, nil
+}
+
+// generatePrivateKey generates a private key of the algorithm, RSA when empty, and its PEM block
+func generatePrivateKey(algorithm string) (crypto.Signer, *pem.Block, error) {
+	switch algorithm {
+	case "", KeyAlgorithmRSA:
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, nil, fmt.Errorf("rsa.GenerateKey: %w", err)
+		}
+		return key, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}, nil
+	case KeyAlgorithmECDSA:
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, nil, fmt.Errorf("ecdsa.GenerateKey: %w", err)
+		}
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, nil, fmt.Errorf("x509.MarshalECPrivateKey: %w", err)
+		}
+		return key, &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported key algorithm %q, expected one of: %s, %s", algorithm, KeyAlgorithmRSA, KeyAlgorithmECDSA)
+	}
+}
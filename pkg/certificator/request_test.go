@@ -0,0 +1,194 @@
+package certificator
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"testing"
+)
+
+func TestGenerateRequest(t *testing.T) {
+	tests := []struct {
+		name      string
+		service   string
+		namespace string
+		request   *Request
+		wantErr   bool
+		validate  func(t *testing.T, csrPEM, keyPEM []byte, csrName string)
+	}{
+		{
+			name:      "valid service and namespace",
+			service:   "webhook-svc",
+			namespace: "webhook",
+			wantErr:   false,
+			validate: func(t *testing.T, csrPEM, keyPEM []byte, csrName string) {
+				if len(csrPEM) == 0 {
+					t.Error("CSR PEM should not be empty")
+				}
+				if len(keyPEM) == 0 {
+					t.Error("Private key PEM should not be empty")
+				}
+				if csrName != "webhook-svc.webhook" {
+					t.Errorf("Expected CSR name 'webhook-svc.webhook', got '%s'", csrName)
+				}
+
+				// Validate CSR PEM format
+				block, _ := pem.Decode(csrPEM)
+				if block == nil {
+					t.Error("Failed to decode CSR PEM")
+				}
+				if block.Type != "CERTIFICATE REQUEST" {
+					t.Errorf("Expected block type 'CERTIFICATE REQUEST', got '%s'", block.Type)
+				}
+
+				// Validate CSR content
+				csr, err := x509.ParseCertificateRequest(block.Bytes)
+				if err != nil {
+					t.Errorf("Failed to parse certificate request: %v", err)
+				}
+				if csr.Subject.CommonName != "webhook-svc.webhook" {
+					t.Errorf("Expected CommonName 'webhook-svc.webhook', got '%s'", csr.Subject.CommonName)
+				}
+
+				// Validate DNS names
+				expectedDNSNames := []string{"webhook-svc", "webhook-svc.webhook", "webhook-svc.webhook.svc"}
+				if len(csr.DNSNames) != len(expectedDNSNames) {
+					t.Errorf("Expected %d DNS names, got %d", len(expectedDNSNames), len(csr.DNSNames))
+				}
+				for _, expected := range expectedDNSNames {
+					found := false
+					for _, dns := range csr.DNSNames {
+						if dns == expected {
+							found = true
+							break
+						}
+					}
+					if !found {
+						t.Errorf("Expected DNS name '%s' not found in %v", expected, csr.DNSNames)
+					}
+				}
+
+				// Validate private key PEM format
+				keyBlock, _ := pem.Decode(keyPEM)
+				if keyBlock == nil {
+					t.Error("Failed to decode private key PEM")
+				}
+				if keyBlock.Type != "RSA PRIVATE KEY" {
+					t.Errorf("Expected block type 'RSA PRIVATE KEY', got '%s'", keyBlock.Type)
+				}
+			},
+		},
+		{
+			name:      "service with special characters",
+			service:   "webhook-svc-v2",
+			namespace: "default",
+			wantErr:   false,
+			validate: func(t *testing.T, csrPEM, keyPEM []byte, csrName string) {
+				if csrName != "webhook-svc-v2.default" {
+					t.Errorf("Expected CSR name 'webhook-svc-v2.default', got '%s'", csrName)
+				}
+			},
+		},
+		{
+			name:      "long service and namespace names",
+			service:   "very-long-service-name-with-many-characters",
+			namespace: "very-long-namespace-name-with-many-characters",
+			wantErr:   false,
+			validate: func(t *testing.T, csrPEM, keyPEM []byte, csrName string) {
+				expectedName := "very-long-service-name-with-many-characters.very-long-namespace-name-with-many-characters"
+				if csrName != expectedName {
+					t.Errorf("Expected CSR name '%s', got '%s'", expectedName, csrName)
+				}
+				// Verify CSR still has valid structure
+				block, _ := pem.Decode(csrPEM)
+				if block == nil {
+					t.Error("Failed to decode CSR PEM for long names")
+				}
+			},
+		},
+		{
+			name:      "service with underscores",
+			service:   "webhook_svc",
+			namespace: "default",
+			wantErr:   false,
+			validate: func(t *testing.T, csrPEM, keyPEM []byte, csrName string) {
+				if csrName != "webhook_svc.default" {
+					t.Errorf("Expected CSR name 'webhook_svc.default', got '%s'", csrName)
+				}
+			},
+		},
+		{
+			name:      "namespace with underscores",
+			service:   "webhook-svc",
+			namespace: "kube_system",
+			wantErr:   false,
+			validate: func(t *testing.T, csrPEM, keyPEM []byte, csrName string) {
+				if csrName != "webhook-svc.kube_system" {
+					t.Errorf("Expected CSR name 'webhook-svc.kube_system', got '%s'", csrName)
+				}
+			},
+		},
+		{
+			name:      "single character service name",
+			service:   "a",
+			namespace: "b",
+			wantErr:   false,
+			validate: func(t *testing.T, csrPEM, keyPEM []byte, csrName string) {
+				if csrName != "a.b" {
+					t.Errorf("Expected CSR name 'a.b', got '%s'", csrName)
+				}
+			},
+		},
+		{
+			name:      "extra names and ECDSA key",
+			service:   "webhook-svc",
+			namespace: "webhook",
+			request: &Request{
+				DNSNames:     []string{"webhook.example.io", "webhook-svc"},
+				IPAddresses:  []net.IP{net.ParseIP("10.0.0.1")},
+				KeyAlgorithm: KeyAlgorithmECDSA,
+			},
+			validate: func(t *testing.T, csrPEM, keyPEM []byte, csrName string) {
+				block, _ := pem.Decode(csrPEM)
+				request, err := x509.ParseCertificateRequest(block.Bytes)
+				if err != nil {
+					t.Fatalf("Failed to parse CSR: %v", err)
+				}
+				if len(request.DNSNames) != 4 || request.DNSNames[3] != "webhook.example.io" {
+					t.Errorf("Expected extra DNS name appended once, got %v", request.DNSNames)
+				}
+				if len(request.IPAddresses) != 1 || !request.IPAddresses[0].Equal(net.ParseIP("10.0.0.1")) {
+					t.Errorf("Expected IP address 10.0.0.1, got %v", request.IPAddresses)
+				}
+				if _, ok := request.PublicKey.(*ecdsa.PublicKey); !ok {
+					t.Errorf("Expected ECDSA public key, got %T", request.PublicKey)
+				}
+				keyBlock, _ := pem.Decode(keyPEM)
+				if _, err := x509.ParseECPrivateKey(keyBlock.Bytes); err != nil {
+					t.Errorf("Failed to parse private key: %v", err)
+				}
+			},
+		},
+		{
+			name:      "unsupported key algorithm",
+			service:   "webhook-svc",
+			namespace: "webhook",
+			request:   &Request{KeyAlgorithm: "DSA"},
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			csrPEM, keyPEM, csrName, err := GenerateRequest(tt.service, tt.namespace, tt.request)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GenerateRequest() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && tt.validate != nil {
+				tt.validate(t, csrPEM, keyPEM, csrName)
+			}
+		})
+	}
+}
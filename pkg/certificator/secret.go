@@ -0,0 +1,70 @@
+/*
+Copyright © 2024 Yevhen Lebid ealebed@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certificator
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// createOrUpdateSecret stores the certificate, the CA, when the signer returned one, and the key
+// in the kubernetes.io/tls Secret of the Issuer
+func (i *Issuer) createOrUpdateSecret(ctx context.Context, certPEM, caPEM, keyPEM []byte) (*corev1.Secret, error) {
+	ctx, span := StartSpan(ctx, "createOrUpdateSecret", AttrNamespace.String(i.namespace), AttrSecret.String(i.secret))
+	defer span.End()
+
+	tlsSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   i.secret,
+			Labels: i.labels,
+		},
+		Type: corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSPrivateKeyKey: keyPEM,
+			corev1.TLSCertKey:       certPEM,
+		},
+	}
+	if caPEM != nil {
+		tlsSecret.Data[CACertKey] = caPEM
+	}
+
+	secrets := i.client.CoreV1().Secrets(i.namespace)
+	i.logger.Printf("Secret, status: Check if already exists...")
+	secretExistsInNamespace, _ := secrets.Get(ctx, i.secret, metav1.GetOptions{})
+	if secretExistsInNamespace.Name == i.secret {
+		i.logger.Printf("Secret, status: Already exists, updating")
+		updated, err := secrets.Update(ctx, tlsSecret, metav1.UpdateOptions{})
+		if err != nil {
+			i.logger.Printf("Update secret - error occurred, detail: %v", err)
+			return nil, SpanError(span, err)
+		}
+		i.logger.Printf("Secret, status: Updated")
+		return updated, nil
+	}
+
+	i.logger.Printf("Secret, status: Not exists, creating")
+	created, err := secrets.Create(ctx, tlsSecret, metav1.CreateOptions{})
+	if err != nil {
+		i.logger.Printf("Create secret - error occurred, detail: %v", err)
+		return nil, SpanError(span, err)
+	}
+	i.logger.Printf("Secret, status: Created")
+
+	return created, nil
+}
@@ -0,0 +1,69 @@
+/*
+Copyright © 2024 Yevhen Lebid ealebed@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certificator
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	certv1 "k8s.io/api/certificates/v1"
+)
+
+// TracerName is the name of the tracer the issuance spans are started with
+const TracerName = "github.com/ealebed/admission-webhook-certificator"
+
+// span attributes of the issuance pipeline
+const (
+	AttrNamespace = attribute.Key("k8s.namespace.name")
+	AttrService   = attribute.Key("certificator.service")
+	AttrSecret    = attribute.Key("certificator.secret")
+	AttrCSRName   = attribute.Key("certificator.csr.name")
+	AttrSigner    = attribute.Key("certificator.signer")
+)
+
+// StartSpan starts a span of the issuance pipeline, or of a command running it
+func StartSpan(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(TracerName).Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// SpanError records err on span and returns it
+func SpanError(span trace.Span, err error) error {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	return err
+}
+
+// endSpan records err on span, when not nil, and ends it
+func endSpan(span trace.Span, err error) {
+	_ = SpanError(span, err)
+	span.End()
+}
+
+// csrAttributes returns the span attributes of a CSR, its namespace is the one of the certificate
+func csrAttributes(csr *certv1.CertificateSigningRequest) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		AttrCSRName.String(csr.Name),
+		AttrSigner.String(csr.Spec.SignerName),
+		AttrNamespace.String(csr.Labels[NamespaceLabel]),
+	}
+}
@@ -0,0 +1,196 @@
+/*
+Copyright © 2024 Yevhen Lebid ealebed@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package certwatcher serves a TLS certificate and key pair from files, typically a mounted
+// Kubernetes Secret, and reloads it when the files change, so webhook servers pick up
+// certificates renewed by certificator without a restart:
+//
+//	watcher, err := certwatcher.New("/certs/tls.crt", "/certs/tls.key",
+//		certwatcher.WithErrorHandler(func(err error) { log.Printf("Reload certificate: %v", err) }))
+//	if err != nil {
+//		return err
+//	}
+//	go watcher.Start(ctx)
+//	server := &http.Server{TLSConfig: &tls.Config{GetCertificate: watcher.GetCertificate}}
+//	return server.ListenAndServeTLS("", "")
+//
+// The kubelet updates a mounted Secret by writing the new files into a fresh directory and
+// swapping the ..data symlink the file names point through, the files are therefore polled by
+// their content rather than watched for events, which symlink swaps don't reliably produce.
+package certwatcher
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultInterval is how often the files are checked for changes
+const DefaultInterval = 10 * time.Second
+
+// Watcher serves the certificate and key pair of two files and reloads it when they change
+type Watcher struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+	onError  func(error)
+	onReload func(*x509.Certificate)
+
+	mu          sync.RWMutex
+	certificate *tls.Certificate
+	certPEM     []byte
+	keyPEM      []byte
+}
+
+// Option configures a Watcher
+type Option func(*Watcher)
+
+// WithInterval sets how often the files are checked for changes, DefaultInterval by default
+func WithInterval(interval time.Duration) Option {
+	return func(w *Watcher) {
+		if interval > 0 {
+			w.interval = interval
+		}
+	}
+}
+
+// WithErrorHandler sets the callback receiving reload errors, the previous certificate is served meanwhile
+func WithErrorHandler(onError func(error)) Option {
+	return func(w *Watcher) {
+		w.onError = onError
+	}
+}
+
+// WithReloadHandler sets the callback receiving each newly loaded certificate
+func WithReloadHandler(onReload func(*x509.Certificate)) Option {
+	return func(w *Watcher) {
+		w.onReload = onReload
+	}
+}
+
+// New returns a Watcher of certFile and keyFile, which fails when they don't hold a valid pair
+func New(certFile, keyFile string, options ...Option) (*Watcher, error) {
+	w := &Watcher{certFile: certFile, keyFile: keyFile, interval: DefaultInterval}
+	for _, option := range options {
+		option(w)
+	}
+	if _, err := w.Reload(); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// Start checks the files for changes until ctx is done
+func (w *Watcher) Start(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := w.Reload(); err != nil && w.onError != nil {
+				w.onError(err)
+			}
+		}
+	}
+}
+
+// Reload loads the files when their content changed and reports whether it did. The served
+// certificate is replaced only by a valid pair, it is kept on errors.
+func (w *Watcher) Reload() (bool, error) {
+	certPEM, keyPEM, err := w.read()
+	if err != nil {
+		return false, err
+	}
+
+	w.mu.RLock()
+	unchanged := bytes.Equal(certPEM, w.certPEM) && bytes.Equal(keyPEM, w.keyPEM)
+	w.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	certificate, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		// the files may have been read on both sides of a symlink swap, a second read sees one side
+		if certPEM, keyPEM, err = w.read(); err != nil {
+			return false, err
+		}
+		if certificate, err = tls.X509KeyPair(certPEM, keyPEM); err != nil {
+			return false, fmt.Errorf("load certificate %s and key %s: %w", w.certFile, w.keyFile, err)
+		}
+	}
+	if certificate.Leaf == nil {
+		if certificate.Leaf, err = x509.ParseCertificate(certificate.Certificate[0]); err != nil {
+			return false, fmt.Errorf("parse certificate %s: %w", w.certFile, err)
+		}
+	}
+
+	w.mu.Lock()
+	w.certificate, w.certPEM, w.keyPEM = &certificate, certPEM, keyPEM
+	w.mu.Unlock()
+	if w.onReload != nil {
+		w.onReload(certificate.Leaf)
+	}
+
+	return true, nil
+}
+
+// read returns the content of both files
+func (w *Watcher) read() (certPEM, keyPEM []byte, err error) {
+	if certPEM, err = os.ReadFile(w.certFile); err != nil {
+		return nil, nil, err
+	}
+	if keyPEM, err = os.ReadFile(w.keyFile); err != nil {
+		return nil, nil, err
+	}
+
+	return certPEM, keyPEM, nil
+}
+
+// GetCertificate returns the current certificate, it is meant for tls.Config.GetCertificate
+func (w *Watcher) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.certificate == nil {
+		return nil, errors.New("no certificate loaded")
+	}
+
+	return w.certificate, nil
+}
+
+// Certificate returns the current leaf certificate
+func (w *Watcher) Certificate() *x509.Certificate {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	return w.certificate.Leaf
+}
+
+// NotAfter returns the expiry of the current certificate
+func (w *Watcher) NotAfter() time.Time {
+	return w.Certificate().NotAfter
+}
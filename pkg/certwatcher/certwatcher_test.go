@@ -0,0 +1,186 @@
+package certwatcher
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newKeyPair returns a self-signed certificate and key in PEM, valid for validity
+func newKeyPair(t *testing.T, serial int64, validity time.Duration) (certPEM, keyPEM []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey() error = %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "webhook-svc.webhook.svc"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(validity),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate() error = %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("x509.MarshalECPrivateKey() error = %v", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// writeSecretVolume writes the files like the kubelet updates a Secret volume: into a fresh
+// directory, which the ..data symlink is then atomically swapped to
+func writeSecretVolume(t *testing.T, dir, version string, files map[string][]byte) {
+	t.Helper()
+
+	dataDir := filepath.Join(dir, "..v"+version)
+	if err := os.Mkdir(dataDir, 0o755); err != nil {
+		t.Fatalf("os.Mkdir() error = %v", err)
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dataDir, name), data, 0o600); err != nil {
+			t.Fatalf("os.WriteFile() error = %v", err)
+		}
+		link := filepath.Join(dir, name)
+		if _, err := os.Lstat(link); os.IsNotExist(err) {
+			if err = os.Symlink(filepath.Join("..data", name), link); err != nil {
+				t.Fatalf("os.Symlink() error = %v", err)
+			}
+		}
+	}
+	tmp := filepath.Join(dir, "..data_tmp")
+	if err := os.Symlink(filepath.Base(dataDir), tmp); err != nil {
+		t.Fatalf("os.Symlink() error = %v", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, "..data")); err != nil {
+		t.Fatalf("os.Rename() error = %v", err)
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	dir := t.TempDir()
+	certPEM, keyPEM := newKeyPair(t, 1, time.Hour)
+	writeSecretVolume(t, dir, "1", map[string][]byte{"tls.crt": certPEM, "tls.key": keyPEM})
+
+	var mu sync.Mutex
+	var reloaded []int64
+	var errs []error
+	reloads := make(chan struct{}, 10)
+	w, err := New(filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"),
+		WithInterval(10*time.Millisecond),
+		WithReloadHandler(func(cert *x509.Certificate) {
+			mu.Lock()
+			reloaded = append(reloaded, cert.SerialNumber.Int64())
+			mu.Unlock()
+			reloads <- struct{}{}
+		}),
+		WithErrorHandler(func(err error) {
+			mu.Lock()
+			errs = append(errs, err)
+			mu.Unlock()
+		}),
+	)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	<-reloads
+	if time.Until(w.NotAfter()) > time.Hour || time.Until(w.NotAfter()) < 59*time.Minute {
+		t.Errorf("Expected certificate valid for an hour, got NotAfter %s", w.NotAfter())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Start(ctx)
+
+	// a broken pair keeps the served certificate and is reported
+	_, otherKeyPEM := newKeyPair(t, 2, time.Hour)
+	writeSecretVolume(t, dir, "2", map[string][]byte{"tls.crt": certPEM, "tls.key": otherKeyPEM})
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(errs) > 0
+	})
+	if served, _ := w.GetCertificate(&tls.ClientHelloInfo{}); served.Leaf.SerialNumber.Int64() != 1 {
+		t.Errorf("Expected certificate 1 served after a failed reload, got %d", served.Leaf.SerialNumber.Int64())
+	}
+
+	renewedPEM, renewedKeyPEM := newKeyPair(t, 3, 2*time.Hour)
+	writeSecretVolume(t, dir, "3", map[string][]byte{"tls.crt": renewedPEM, "tls.key": renewedKeyPEM})
+	select {
+	case <-reloads:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected renewed certificate reloaded")
+	}
+	served, err := w.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatalf("GetCertificate() error = %v", err)
+	}
+	if served.Leaf.SerialNumber.Int64() != 3 || w.Certificate().SerialNumber.Int64() != 3 {
+		t.Errorf("Expected renewed certificate 3 served, got %d", served.Leaf.SerialNumber.Int64())
+	}
+	if time.Until(w.NotAfter()) < time.Hour {
+		t.Errorf("Expected expiry of the renewed certificate, got %s", w.NotAfter())
+	}
+
+	// unchanged files aren't reloaded again
+	if changed, err := w.Reload(); changed || err != nil {
+		t.Errorf("Expected no reload of unchanged files, got %v, %v", changed, err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(reloaded) != 2 {
+		t.Errorf("Expected 2 reloads, got %v", reloaded)
+	}
+}
+
+func TestNew(t *testing.T) {
+	dir := t.TempDir()
+	certPEM, _ := newKeyPair(t, 1, time.Hour)
+	_, keyPEM := newKeyPair(t, 2, time.Hour)
+	writeSecretVolume(t, dir, "1", map[string][]byte{"tls.crt": certPEM, "tls.key": keyPEM})
+
+	tests := []struct {
+		name     string
+		certFile string
+		keyFile  string
+	}{
+		{name: "missing files", certFile: filepath.Join(dir, "missing.crt"), keyFile: filepath.Join(dir, "missing.key")},
+		{name: "mismatched pair", certFile: filepath.Join(dir, "tls.crt"), keyFile: filepath.Join(dir, "tls.key")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.certFile, tt.keyFile); err == nil {
+				t.Error("Expected error")
+			}
+		})
+	}
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for condition")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
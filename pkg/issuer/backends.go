@@ -0,0 +1,155 @@
+/*
+Copyright © 2024 Yevhen Lebid ealebed@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package issuer
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// caBundleKey is the Secret key of a CA bundle, which trusts the previous CA too during rotation
+const caBundleKey = "ca.crt"
+
+// SecretCA issues from a CA whose tls.crt and tls.key are stored in a Secret, e.g. the one
+// of the certificator signer. The Secret is read on every issuance, so a rotated CA is used
+// right away.
+type SecretCA struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+}
+
+// NewSecretCA returns a SecretCA of the Secret namespace/name
+func NewSecretCA(client kubernetes.Interface, namespace, name string) *SecretCA {
+	return &SecretCA{client: client, namespace: namespace, name: name}
+}
+
+func (s *SecretCA) String() string { return "CA of Secret " + s.namespace + "/" + s.name }
+
+// Issue signs the request with the CA, the CA returned is the ca.crt bundle of the Secret when
+// it has one, the CA certificate otherwise
+func (s *SecretCA) Issue(ctx context.Context, csrPEM []byte, duration time.Duration) (*Certificate, error) {
+	request, err := parseRequest(csrPEM)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := s.client.CoreV1().Secrets(s.namespace).Get(ctx, s.name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("get CA secret %s/%s: %w", s.namespace, s.name, err)
+	}
+	ca, err := ParseCA(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		return nil, fmt.Errorf("load CA of secret %s/%s: %w", s.namespace, s.name, err)
+	}
+
+	certificate, err := signRequest(ca, request, duration)
+	if err != nil {
+		return nil, err
+	}
+	if bundle := secret.Data[caBundleKey]; len(bundle) > 0 {
+		certificate.CAPEM = bundle
+	}
+
+	return certificate, nil
+}
+
+// SelfSigned issues from a CA generated for every certificate, whose key is discarded right
+// after. The CA trusts this one certificate only, its caBundle targets change on every renewal.
+type SelfSigned struct{}
+
+func (SelfSigned) String() string { return "self-signed CA" }
+
+// Issue generates a CA valid for duration and signs the request with it
+func (SelfSigned) Issue(_ context.Context, csrPEM []byte, duration time.Duration) (*Certificate, error) {
+	request, err := parseRequest(csrPEM)
+	if err != nil {
+		return nil, err
+	}
+	certPEM, keyPEM, err := GenerateCA(request.Subject.CommonName+" CA", duration)
+	if err != nil {
+		return nil, err
+	}
+	ca, err := ParseCA(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	return signRequest(ca, request, duration)
+}
+
+// signRequest signs the request with ca for a serving certificate
+func signRequest(ca *CertificateAuthority, request *x509.CertificateRequest, duration time.Duration) (*Certificate, error) {
+	certPEM, err := ca.Sign(request, ServerUsages, duration)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Certificate{ChainPEM: certPEM, CAPEM: ca.CertificatePEM}, nil
+}
+
+// Exec issues by running an external command, e.g. a client of a corporate PKI or Vault. The
+// command receives the PEM encoded request on stdin and the requested lifetime in seconds as
+// CERTIFICATOR_DURATION, and prints the PEM certificate chain on stdout. The chain may end with
+// the self-signed CA, the cluster CA is assumed otherwise.
+type Exec struct {
+	command []string
+	timeout time.Duration
+}
+
+// NewExec returns an Exec running command, killed after timeout unless it is zero
+func NewExec(command []string, timeout time.Duration) *Exec {
+	return &Exec{command: command, timeout: timeout}
+}
+
+func (e *Exec) String() string { return "exec " + e.command[0] }
+
+// Issue runs the command for the request
+func (e *Exec) Issue(ctx context.Context, csrPEM []byte, duration time.Duration) (*Certificate, error) {
+	if e.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.timeout)
+		defer cancel()
+	}
+
+	//nolint:gosec // commands are given on the certificator command line only, never by a certificate
+	cmd := exec.CommandContext(ctx, e.command[0], e.command[1:]...)
+	cmd.Stdin = bytes.NewReader(csrPEM)
+	cmd.Env = append(os.Environ(), "CERTIFICATOR_DURATION="+strconv.FormatInt(int64(duration.Seconds()), 10))
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s: %w, stderr: %s", e, err, strings.TrimSpace(stderr.String()))
+	}
+
+	if block, _ := pem.Decode(stdout.Bytes()); block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("%s printed no PEM certificate", e)
+	}
+
+	return &Certificate{ChainPEM: stdout.Bytes()}, nil
+}
@@ -0,0 +1,200 @@
+package issuer
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// newTestRequestPEM returns a PEM encoded certificate request for webhook-svc.webhook.svc
+func newTestRequestPEM(t *testing.T) []byte {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey: %v", err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: "webhook-svc.webhook.svc"},
+		DNSNames: []string{"webhook-svc.webhook.svc"},
+	}, key)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificateRequest: %v", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+}
+
+// verifyCertificate checks that certificate holds a serving certificate for the request
+// trusted by its CA
+func verifyCertificate(t *testing.T, certificate *Certificate, caPEM []byte, duration time.Duration) {
+	t.Helper()
+
+	block, _ := pem.Decode(certificate.ChainPEM)
+	if block == nil {
+		t.Fatalf("Expected a PEM certificate, got %q", certificate.ChainPEM)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("x509.ParseCertificate: %v", err)
+	}
+	if cert.Subject.CommonName != "webhook-svc.webhook.svc" {
+		t.Errorf("Expected CN webhook-svc.webhook.svc, got %s", cert.Subject.CommonName)
+	}
+	if lifetime := cert.NotAfter.Sub(cert.NotBefore) - Backdate; lifetime != duration {
+		t.Errorf("Expected lifetime %s, got %s", duration, lifetime)
+	}
+	if string(certificate.CAPEM) != string(caPEM) {
+		t.Errorf("Expected CA %q, got %q", caPEM, certificate.CAPEM)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(certificate.CAPEM)
+	if _, err = cert.Verify(x509.VerifyOptions{
+		Roots: roots, DNSName: "webhook-svc.webhook.svc", KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}); err != nil {
+		t.Errorf("Expected certificate trusted by its CA, got %v", err)
+	}
+}
+
+func TestSecretCA(t *testing.T) {
+	caPEM, keyPEM, err := GenerateCA("test-ca", time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateCA() error = %v", err)
+	}
+	bundlePEM := append(append([]byte{}, caPEM...), caPEM...)
+
+	tests := []struct {
+		name       string
+		data       map[string][]byte
+		secretName string
+		wantCA     []byte
+		wantErr    string
+	}{
+		{
+			name:   "signs with the CA",
+			data:   map[string][]byte{corev1.TLSCertKey: caPEM, corev1.TLSPrivateKeyKey: keyPEM},
+			wantCA: caPEM,
+		},
+		{
+			name:   "returns the CA bundle",
+			data:   map[string][]byte{corev1.TLSCertKey: caPEM, corev1.TLSPrivateKeyKey: keyPEM, caBundleKey: bundlePEM},
+			wantCA: bundlePEM,
+		},
+		{
+			name:       "missing Secret",
+			secretName: "other-ca",
+			wantErr:    "get CA secret certificator/other-ca",
+		},
+		{
+			name:    "Secret without key",
+			data:    map[string][]byte{corev1.TLSCertKey: caPEM},
+			wantErr: "load CA of secret certificator/webhook-ca",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := fake.NewClientset(&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "webhook-ca", Namespace: "certificator"},
+				Data:       tt.data,
+			})
+			secretName := "webhook-ca"
+			if tt.secretName != "" {
+				secretName = tt.secretName
+			}
+
+			certificate, err := NewSecretCA(cs, "certificator", secretName).Issue(context.TODO(), newTestRequestPEM(t), time.Hour)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Issue() error = %v", err)
+			}
+			verifyCertificate(t, certificate, tt.wantCA, time.Hour)
+		})
+	}
+}
+
+func TestSelfSigned(t *testing.T) {
+	certificate, err := SelfSigned{}.Issue(context.TODO(), newTestRequestPEM(t), time.Hour)
+	if err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+	verifyCertificate(t, certificate, certificate.CAPEM, time.Hour)
+
+	block, _ := pem.Decode(certificate.CAPEM)
+	ca, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("x509.ParseCertificate: %v", err)
+	}
+	if ca.Subject.CommonName != "webhook-svc.webhook.svc CA" || !ca.IsCA {
+		t.Errorf("Expected the CA webhook-svc.webhook.svc CA, got %s", ca.Subject.CommonName)
+	}
+
+	if _, err = (SelfSigned{}).Issue(context.TODO(), []byte("not a request"), time.Hour); err == nil {
+		t.Error("Expected an error for an invalid request")
+	}
+}
+
+func TestExec(t *testing.T) {
+	ca := newTestCA(t, time.Hour)
+	request, err := parseRequest(newTestRequestPEM(t))
+	if err != nil {
+		t.Fatalf("parseRequest() error = %v", err)
+	}
+	certPEM, err := ca.Sign(request, ServerUsages, time.Hour)
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+	chain := filepath.Join(t.TempDir(), "chain.pem")
+	if err = os.WriteFile(chain, append(certPEM, ca.CertificatePEM...), 0o600); err != nil {
+		t.Fatalf("os.WriteFile: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		script  string
+		timeout time.Duration
+		wantErr string
+	}{
+		{name: "prints the chain", script: `grep -q "BEGIN CERTIFICATE REQUEST" && test "$CERTIFICATOR_DURATION" = 3600 && cat ` + chain},
+		{name: "fails", script: "echo denied >&2; exit 1", wantErr: "stderr: denied"},
+		{name: "prints no certificate", script: "echo issued", wantErr: "printed no PEM certificate"},
+		{name: "times out", script: "exec sleep 5", timeout: 100 * time.Millisecond, wantErr: "exec sh"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			certificate, err := NewExec([]string{"sh", "-c", tt.script}, tt.timeout).Issue(context.TODO(), newTestRequestPEM(t), time.Hour)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Issue() error = %v", err)
+			}
+			if string(certificate.ChainPEM) != string(append(certPEM, ca.CertificatePEM...)) || certificate.CAPEM != nil {
+				t.Errorf("Expected the printed chain without CA, got %+v", certificate)
+			}
+		})
+	}
+}
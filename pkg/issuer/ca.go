@@ -0,0 +1,200 @@
+/*
+Copyright © 2024 Yevhen Lebid ealebed@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package issuer
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"time"
+
+	certv1 "k8s.io/api/certificates/v1"
+)
+
+// Backdate tolerates clock skew between the issuer and clients, like kube-controller-manager does
+const Backdate = 5 * time.Minute
+
+// CertificateAuthority is a CA certificate together with its signing key
+type CertificateAuthority struct {
+	Certificate    *x509.Certificate
+	CertificatePEM []byte
+	Key            crypto.Signer
+}
+
+// GenerateCA creates a self-signed CA and returns its certificate and private key in PEM
+func GenerateCA(commonName string, validity time.Duration) (certPEM, keyPEM []byte, err error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, fmt.Errorf("rsa.GenerateKey: %w", err)
+	}
+	serial, err := randomSerialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             now.Add(-Backdate),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("x509.CreateCertificate: %w", err)
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	return certPEM, keyPEM, nil
+}
+
+// ParseCA loads a CA from its PEM encoded certificate and private key
+func ParseCA(certPEM, keyPEM []byte) (*CertificateAuthority, error) {
+	var cert *x509.Certificate
+	for rest := certPEM; cert == nil; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			return nil, fmt.Errorf("no CA certificate found")
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		var err error
+		if cert, err = x509.ParseCertificate(block.Bytes); err != nil {
+			return nil, fmt.Errorf("x509.ParseCertificate: %w", err)
+		}
+	}
+	if !cert.IsCA {
+		return nil, fmt.Errorf("certificate %s is not a CA", cert.Subject.CommonName)
+	}
+
+	key, err := ParsePrivateKey(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CertificateAuthority{
+		Certificate:    cert,
+		CertificatePEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}),
+		Key:            key,
+	}, nil
+}
+
+// ParsePrivateKey decodes a PKCS#1, SEC 1 or PKCS#8 PEM encoded private key
+func ParsePrivateKey(keyPEM []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode private key PEM")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("private key type %T is not supported", key)
+		}
+		return signer, nil
+	default:
+		return nil, fmt.Errorf("unsupported private key PEM block %q", block.Type)
+	}
+}
+
+// Sign issues a certificate for the request, valid for duration but never beyond the CA itself
+func (ca *CertificateAuthority) Sign(request *x509.CertificateRequest, usages []certv1.KeyUsage,
+	duration time.Duration) ([]byte, error) {
+	serial, err := randomSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+	keyUsage, extKeyUsage, err := X509Usages(usages)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	notAfter := now.Add(duration)
+	if notAfter.After(ca.Certificate.NotAfter) {
+		notAfter = ca.Certificate.NotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               request.Subject,
+		DNSNames:              request.DNSNames,
+		IPAddresses:           request.IPAddresses,
+		URIs:                  request.URIs,
+		EmailAddresses:        request.EmailAddresses,
+		NotBefore:             now.Add(-Backdate),
+		NotAfter:              notAfter,
+		KeyUsage:              keyUsage,
+		ExtKeyUsage:           extKeyUsage,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.Certificate, request.PublicKey, ca.Key)
+	if err != nil {
+		return nil, fmt.Errorf("x509.CreateCertificate: %w", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), nil
+}
+
+// X509Usages maps CSR API key usages onto x509 key usages
+func X509Usages(usages []certv1.KeyUsage) (x509.KeyUsage, []x509.ExtKeyUsage, error) {
+	var keyUsage x509.KeyUsage
+	var extKeyUsage []x509.ExtKeyUsage
+
+	for _, usage := range usages {
+		switch usage {
+		case certv1.UsageDigitalSignature:
+			keyUsage |= x509.KeyUsageDigitalSignature
+		case certv1.UsageKeyEncipherment:
+			keyUsage |= x509.KeyUsageKeyEncipherment
+		case certv1.UsageServerAuth:
+			extKeyUsage = append(extKeyUsage, x509.ExtKeyUsageServerAuth)
+		default:
+			return 0, nil, fmt.Errorf("usage %q is not supported", usage)
+		}
+	}
+
+	return keyUsage, extKeyUsage, nil
+}
+
+func randomSerialNumber() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("rand.Int: %w", err)
+	}
+
+	return serial, nil
+}
@@ -0,0 +1,171 @@
+package issuer
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	certv1 "k8s.io/api/certificates/v1"
+)
+
+// newTestCA returns a freshly generated CA
+func newTestCA(t *testing.T, validity time.Duration) *CertificateAuthority {
+	t.Helper()
+
+	certPEM, keyPEM, err := GenerateCA("test-ca", validity)
+	if err != nil {
+		t.Fatalf("GenerateCA() error = %v", err)
+	}
+	ca, err := ParseCA(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("ParseCA() error = %v", err)
+	}
+
+	return ca
+}
+
+// newTestPublicKey returns the public key of a freshly generated ECDSA key
+func newTestPublicKey(t *testing.T) *ecdsa.PublicKey {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey: %v", err)
+	}
+
+	return &key.PublicKey
+}
+
+func TestGenerateAndParseCA(t *testing.T) {
+	ca := newTestCA(t, time.Hour)
+
+	if !ca.Certificate.IsCA {
+		t.Error("Expected generated certificate to be a CA")
+	}
+	if ca.Certificate.Subject.CommonName != "test-ca" {
+		t.Errorf("Expected CommonName 'test-ca', got '%s'", ca.Certificate.Subject.CommonName)
+	}
+	if time.Until(ca.Certificate.NotAfter) > time.Hour {
+		t.Errorf("Expected CA valid for an hour, got NotAfter %s", ca.Certificate.NotAfter)
+	}
+}
+
+func TestParseCA(t *testing.T) {
+	certPEM, keyPEM, err := GenerateCA("test-ca", time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateCA() error = %v", err)
+	}
+	leafPEM, err := newTestCA(t, time.Hour).Sign(&x509.CertificateRequest{PublicKey: newTestPublicKey(t)}, ServerUsages, time.Hour)
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		certPEM []byte
+		keyPEM  []byte
+		wantErr bool
+	}{
+		{name: "valid CA", certPEM: certPEM, keyPEM: keyPEM},
+		{name: "missing certificate", keyPEM: keyPEM, wantErr: true},
+		{name: "not a CA", certPEM: leafPEM, keyPEM: keyPEM, wantErr: true},
+		{name: "malformed key", certPEM: certPEM, keyPEM: []byte("key"), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseCA(tt.certPEM, tt.keyPEM); (err != nil) != tt.wantErr {
+				t.Errorf("ParseCA() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey: %v", err)
+	}
+	sec1, _ := x509.MarshalECPrivateKey(key)
+	pkcs8, _ := x509.MarshalPKCS8PrivateKey(key)
+
+	tests := []struct {
+		name    string
+		block   *pem.Block
+		wantErr bool
+	}{
+		{name: "SEC 1", block: &pem.Block{Type: "EC PRIVATE KEY", Bytes: sec1}},
+		{name: "PKCS#8", block: &pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}},
+		{name: "unsupported block", block: &pem.Block{Type: "CERTIFICATE", Bytes: pkcs8}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParsePrivateKey(pem.EncodeToMemory(tt.block)); (err != nil) != tt.wantErr {
+				t.Errorf("ParsePrivateKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCertificateAuthoritySign(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("ecdsa.GenerateKey: %v", err)
+	}
+	request := &x509.CertificateRequest{
+		Subject:   pkix.Name{CommonName: "webhook-svc.webhook"},
+		DNSNames:  []string{"webhook-svc.webhook.svc"},
+		PublicKey: &key.PublicKey,
+	}
+	usages := []certv1.KeyUsage{certv1.UsageDigitalSignature, certv1.UsageServerAuth}
+
+	tests := []struct {
+		name         string
+		caValidity   time.Duration
+		duration     time.Duration
+		wantLifetime time.Duration
+	}{
+		{name: "requested duration", caValidity: 24 * time.Hour, duration: time.Hour, wantLifetime: time.Hour},
+		{name: "capped by CA", caValidity: time.Hour, duration: 24 * time.Hour, wantLifetime: time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ca := newTestCA(t, tt.caValidity)
+
+			certPEM, err := ca.Sign(request, usages, tt.duration)
+			if err != nil {
+				t.Fatalf("Sign() error = %v", err)
+			}
+			block, rest := pem.Decode(certPEM)
+			if block == nil || len(rest) != 0 {
+				t.Fatalf("Expected one certificate, got %q", certPEM)
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				t.Fatalf("x509.ParseCertificate: %v", err)
+			}
+			if err := cert.CheckSignatureFrom(ca.Certificate); err != nil {
+				t.Errorf("Expected certificate signed by CA, got %v", err)
+			}
+			if cert.NotAfter.After(ca.Certificate.NotAfter) {
+				t.Errorf("Expected NotAfter %s not beyond CA NotAfter %s", cert.NotAfter, ca.Certificate.NotAfter)
+			}
+			if lifetime := time.Until(cert.NotAfter); lifetime > tt.wantLifetime || lifetime < tt.wantLifetime-time.Minute {
+				t.Errorf("Expected lifetime about %s, got %s", tt.wantLifetime, lifetime)
+			}
+			if len(cert.ExtKeyUsage) != 1 || cert.ExtKeyUsage[0] != x509.ExtKeyUsageServerAuth {
+				t.Errorf("Expected server auth extended key usage, got %v", cert.ExtKeyUsage)
+			}
+			if cert.DNSNames[0] != "webhook-svc.webhook.svc" {
+				t.Errorf("Expected DNS names copied from request, got %v", cert.DNSNames)
+			}
+		})
+	}
+}
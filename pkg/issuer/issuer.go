@@ -0,0 +1,88 @@
+/*
+Copyright © 2024 Yevhen Lebid ealebed@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package issuer defines how a certificate request is signed, so the certificator pipeline, which
+// generates the private key, stores the certificate in a Secret and injects its CA, works with
+// any signing backend:
+//
+//	backend := issuer.NewSecretCA(clientset, "certificator", "webhook-ca")
+//	issuer, err := certificator.New(clientset, "webhook-svc", "webhook", "webhook-certs",
+//		certificator.WithIssuer(backend))
+//
+// The Kubernetes CertificateSigningRequest API is the default backend of certificator.Issuer,
+// this package provides a CA stored in a Secret, a self-signed CA and an external command.
+package issuer
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	certv1 "k8s.io/api/certificates/v1"
+)
+
+// kinds of issuers, as selected by the certify --issuer flag
+const (
+	KindKubernetes = "kubernetes"
+	KindSecretCA   = "ca"
+	KindSelfSigned = "selfsigned"
+	KindExec       = "exec"
+)
+
+// Kinds lists the supported kinds of issuers
+var Kinds = []string{KindKubernetes, KindSecretCA, KindSelfSigned, KindExec}
+
+// ServerUsages are the key usages of webhook serving certificates
+var ServerUsages = []certv1.KeyUsage{
+	certv1.UsageDigitalSignature,
+	certv1.UsageKeyEncipherment,
+	certv1.UsageServerAuth,
+}
+
+// Certificate is a certificate issued for a request
+type Certificate struct {
+	// ChainPEM is the issued certificate, followed by intermediates if any
+	ChainPEM []byte
+	// CAPEM is the CA which trusts the certificate, nil when unknown. A self-signed CA at the
+	// end of ChainPEM is split off by certificator then.
+	CAPEM []byte
+}
+
+// Issuer signs a PEM encoded certificate request, the certificate is valid for about duration.
+// String names the issuer in Events and logs.
+type Issuer interface {
+	fmt.Stringer
+	Issue(ctx context.Context, csrPEM []byte, duration time.Duration) (*Certificate, error)
+}
+
+// parseRequest decodes a PEM encoded certificate request and checks its signature
+func parseRequest(csrPEM []byte) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode(csrPEM)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, fmt.Errorf("failed to decode certificate request PEM")
+	}
+	request, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("x509.ParseCertificateRequest: %w", err)
+	}
+	if err = request.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("certificate request signature: %w", err)
+	}
+
+	return request, nil
+}